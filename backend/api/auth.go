@@ -0,0 +1,291 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/krackenservices/threadwell/auth"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+// DefaultSessionTTL is how long a login token stays valid.
+const DefaultSessionTTL = 30 * 24 * time.Hour
+
+// WithLocalMode turns authentication off: every request is served as the
+// single local user and sees all data. Only use it when the server is not
+// reachable by anyone else.
+func WithLocalMode() Option {
+	return func(h *Handler) {
+		h.local = true
+	}
+}
+
+// WithSignup lets anyone create an account through /api/auth/register.
+// Without it accounts are created with `threadwell user add`.
+func WithSignup() Option {
+	return func(h *Handler) {
+		h.signup = true
+	}
+}
+
+// WithSessionTTL overrides DefaultSessionTTL. Zero means tokens never expire.
+func WithSessionTTL(d time.Duration) Option {
+	return func(h *Handler) {
+		h.sessionTTL = d
+	}
+}
+
+// publicPaths are served without a token.
+var publicPaths = map[string]bool{
+	"/health":            true,
+	"/version":           true,
+	"/api/auth/login":    true,
+	"/api/auth/register": true,
+}
+
+type userKey struct{}
+
+// CurrentUser returns the authenticated caller, or nil in local mode and on
+// public paths.
+func CurrentUser(r *http.Request) *models.User {
+	u, _ := r.Context().Value(userKey{}).(*models.User)
+	return u
+}
+
+// store returns the storage the request may use: everything in local mode,
+// otherwise only the caller's own data.
+func (h *Handler) store(r *http.Request) storage.Storage {
+	if u := CurrentUser(r); u != nil {
+		return storage.ForUser(h.backend, u.ID)
+	}
+	return h.backend
+}
+
+// requireAuth rejects requests without a valid bearer token, and personal
+// access tokens without the scope the endpoint needs, and records the
+// caller for the handlers. In local mode every request passes.
+func (h *Handler) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.local || publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := bearerToken(r)
+		if !ok {
+			unauthorized(w, "missing bearer token")
+			return
+		}
+		var (
+			user   *models.User
+			access *models.AccessToken
+			err    error
+		)
+		if strings.HasPrefix(token, auth.AccessTokenPrefix) {
+			user, access, err = auth.AuthenticateAccessToken(h.backend, token)
+		} else {
+			user, err = auth.Authenticate(h.backend, token)
+		}
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, "failed to check token")
+			return
+		}
+		if user == nil {
+			unauthorized(w, "invalid or expired token")
+			return
+		}
+		if access != nil {
+			scope, ok := requiredScope(r)
+			if !ok {
+				WriteError(w, http.StatusForbidden, "access tokens cannot use this endpoint")
+				return
+			}
+			if scope != "" && !slices.Contains(access.Scopes, scope) {
+				WriteError(w, http.StatusForbidden, "access token lacks scope "+scope)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+// requiredScope names the scope an access token needs for r; "" means any
+// token will do. ok is false for endpoints that need a login session.
+func requiredScope(r *http.Request) (scope string, ok bool) {
+	path := r.URL.Path
+	switch {
+	case path == "/api/tokens" || strings.HasPrefix(path, "/api/tokens/"):
+		// A token must not be able to mint itself broader tokens.
+		return "", false
+	case strings.HasPrefix(path, "/api/auth/"):
+		return "", true
+	case path == "/api/settings" || path == "/api/providers" ||
+		strings.HasPrefix(path, "/api/providers/") || strings.HasPrefix(path, "/api/admin/"):
+		return auth.ScopeSettingsAdmin, true
+	case strings.HasPrefix(path, "/api/messages/") && strings.HasSuffix(path, "/stream"):
+		// A GET, but it stores a reply and spends provider credit.
+		return auth.ScopeMessagesWrite, true
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return auth.ScopeThreadsRead, true
+	default:
+		return auth.ScopeMessagesWrite, true
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="threadwell"`)
+	WriteError(w, http.StatusUnauthorized, msg)
+}
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// loginResponse carries a new bearer token. ExpiresAt is 0 when it never
+// expires.
+type loginResponse struct {
+	Token     string      `json:"token"`
+	ExpiresAt int64       `json:"expires_at"`
+	User      models.User `json:"user"`
+}
+
+// publicUser strips the password hash.
+func publicUser(u models.User) models.User {
+	u.PasswordHash = ""
+	return u
+}
+
+// registerHandler creates an account
+// @Summary Register a user
+// @Description Only available when signup is enabled (AUTH_SIGNUP=true).
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body credentials true "Username and password"
+// @Success 201 {object} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/auth/register [post]
+func (h *Handler) registerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if h.local {
+		WriteError(w, http.StatusNotFound, "accounts are disabled in local mode")
+		return
+	}
+	if !h.signup {
+		WriteError(w, http.StatusForbidden, "signup is disabled")
+		return
+	}
+	var c credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	u, err := auth.CreateUser(h.backend, c.Username, c.Password)
+	switch {
+	case errors.Is(err, auth.ErrInvalidAccount):
+		WriteError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, storage.ErrConflict):
+		WriteError(w, http.StatusConflict, "username is taken")
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, "failed to create user")
+	default:
+		WriteJSON(w, http.StatusCreated, publicUser(*u))
+	}
+}
+
+// loginHandler exchanges a username and password for a bearer token
+// @Summary Log in
+// @Description Send the returned token as `Authorization: Bearer <token>`.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body credentials true "Username and password"
+// @Success 200 {object} loginResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/login [post]
+func (h *Handler) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if h.local {
+		WriteError(w, http.StatusNotFound, "accounts are disabled in local mode")
+		return
+	}
+	var c credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	token, sess, u, err := auth.Login(h.backend, c.Username, c.Password, h.sessionTTL)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		WriteError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to log in")
+		return
+	}
+	WriteJSON(w, http.StatusOK, loginResponse{Token: token, ExpiresAt: sess.ExpiresAt, User: publicUser(*u)})
+}
+
+// logoutHandler revokes the token the request was made with
+// @Summary Log out
+// @Tags auth
+// @Success 204
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/logout [post]
+func (h *Handler) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if token, ok := bearerToken(r); ok {
+		if err := h.backend.DeleteSession(auth.HashToken(token)); err != nil {
+			WriteError(w, http.StatusInternalServerError, "failed to log out")
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// meHandler reports who the caller is
+// @Summary Current user
+// @Description In local mode the user is "local" with an empty ID.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} models.User
+// @Failure 401 {object} map[string]string
+// @Router /api/auth/me [get]
+func (h *Handler) meHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	u := CurrentUser(r)
+	if u == nil {
+		WriteJSON(w, http.StatusOK, models.User{Username: "local"})
+		return
+	}
+	WriteJSON(w, http.StatusOK, publicUser(*u))
+}
@@ -0,0 +1,261 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/krackenservices/threadwell/api"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage/memory"
+)
+
+func authRequest(t *testing.T, method, url, token string, body interface{}) *http.Response {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req, err := http.NewRequest(method, url, &buf)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return res
+}
+
+func register(t *testing.T, srv *httptest.Server, username string) string {
+	t.Helper()
+	creds := map[string]string{"username": username, "password": "correct horse"}
+	res := authRequest(t, http.MethodPost, srv.URL+"/api/auth/register", "", creds)
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	res = authRequest(t, http.MethodPost, srv.URL+"/api/auth/login", "", creds)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var out struct {
+		Token     string      `json:"token"`
+		ExpiresAt int64       `json:"expires_at"`
+		User      models.User `json:"user"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&out))
+	res.Body.Close()
+	require.NotEmpty(t, out.Token)
+	require.NotZero(t, out.ExpiresAt)
+	require.Equal(t, username, out.User.Username)
+	require.Empty(t, out.User.PasswordHash)
+	return out.Token
+}
+
+func TestAuthRequiresToken(t *testing.T) {
+	srv := httptest.NewServer(api.RegisterRoutes(memory.New(), api.WithSignup()))
+	defer srv.Close()
+
+	res := authRequest(t, http.MethodGet, srv.URL+"/api/threads", "", nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	require.Contains(t, res.Header.Get("WWW-Authenticate"), "Bearer")
+
+	res = authRequest(t, http.MethodGet, srv.URL+"/api/threads", "not-a-token", nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res = authRequest(t, http.MethodGet, srv.URL+"/health", "", nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = authRequest(t, http.MethodPost, srv.URL+"/api/auth/login", "", map[string]string{"username": "nobody", "password": "whatever1"})
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	token := register(t, srv, "alice")
+	res = authRequest(t, http.MethodGet, srv.URL+"/api/auth/me", token, nil)
+	var me models.User
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&me))
+	res.Body.Close()
+	require.Equal(t, "alice", me.Username)
+
+	res = authRequest(t, http.MethodPost, srv.URL+"/api/auth/register", "", map[string]string{"username": "alice", "password": "another pass"})
+	res.Body.Close()
+	require.Equal(t, http.StatusConflict, res.StatusCode)
+
+	res = authRequest(t, http.MethodPost, srv.URL+"/api/auth/logout", token, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	res = authRequest(t, http.MethodGet, srv.URL+"/api/auth/me", token, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestAuthSignupDisabled(t *testing.T) {
+	srv := httptest.NewServer(api.RegisterRoutes(memory.New()))
+	defer srv.Close()
+
+	res := authRequest(t, http.MethodPost, srv.URL+"/api/auth/register", "", map[string]string{"username": "alice", "password": "correct horse"})
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+}
+
+func TestAuthIsolatesUsers(t *testing.T) {
+	store := memory.New()
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithSignup()))
+	defer srv.Close()
+
+	alice := register(t, srv, "alice")
+	bob := register(t, srv, "bob")
+
+	res := authRequest(t, http.MethodPost, srv.URL+"/api/threads", alice, map[string]string{"title": "private"})
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var thread models.Thread
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&thread))
+	res.Body.Close()
+
+	stored, err := store.GetThread(thread.ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, stored.OwnerID)
+
+	res = authRequest(t, http.MethodPatch, srv.URL+"/api/threads/"+thread.ID, bob, map[string]string{"title": "mine now"})
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	res = authRequest(t, http.MethodDelete, srv.URL+"/api/threads/"+thread.ID, bob, nil)
+	res.Body.Close()
+	stored, err = store.GetThread(thread.ID)
+	require.NoError(t, err)
+	require.Equal(t, "private", stored.Title)
+
+	res = authRequest(t, http.MethodGet, srv.URL+"/api/threads", bob, nil)
+	var threads []models.Thread
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&threads))
+	res.Body.Close()
+	require.Empty(t, threads)
+
+	res = authRequest(t, http.MethodGet, srv.URL+"/api/threads", alice, nil)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&threads))
+	res.Body.Close()
+	require.Len(t, threads, 1)
+
+	// Settings are kept per user
+	res = authRequest(t, http.MethodPut, srv.URL+"/api/settings", alice, models.Settings{LLMName: "alice-model"})
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res = authRequest(t, http.MethodGet, srv.URL+"/api/settings", bob, nil)
+	var settings models.Settings
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&settings))
+	res.Body.Close()
+	require.NotEqual(t, "alice-model", settings.LLMName)
+
+	// So are provider profiles; bob cannot use or even see alice's.
+	res = authRequest(t, http.MethodPost, srv.URL+"/api/providers", alice, models.ProviderProfile{Name: "hosted", LLMProvider: "openai"})
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var profile models.ProviderProfile
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&profile))
+	res.Body.Close()
+	res = authRequest(t, http.MethodGet, srv.URL+"/api/providers/"+profile.ID, bob, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	res = authRequest(t, http.MethodPost, srv.URL+"/api/threads", bob, models.Thread{Title: "borrowed", ProviderID: profile.ID})
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestLocalModeDisablesAccounts(t *testing.T) {
+	srv := httptest.NewServer(api.RegisterRoutes(memory.New(), api.WithLocalMode()))
+	defer srv.Close()
+
+	res := authRequest(t, http.MethodGet, srv.URL+"/api/auth/me", "", nil)
+	var me models.User
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&me))
+	res.Body.Close()
+	require.Equal(t, "local", me.Username)
+
+	res = authRequest(t, http.MethodPost, srv.URL+"/api/auth/login", "", map[string]string{"username": "alice", "password": "correct horse"})
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestAccessTokenScopes(t *testing.T) {
+	store := memory.New()
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithSignup()))
+	defer srv.Close()
+	session := register(t, srv, "alice")
+
+	res := authRequest(t, http.MethodPost, srv.URL+"/api/tokens", session,
+		map[string]interface{}{"name": "ci", "scopes": []string{"messages:write"}})
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var created struct {
+		ID        string   `json:"id"`
+		Token     string   `json:"token"`
+		TokenHash string   `json:"token_hash"`
+		Scopes    []string `json:"scopes"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+	res.Body.Close()
+	require.NotEmpty(t, created.Token)
+	require.Empty(t, created.TokenHash)
+	require.Equal(t, []string{"messages:write"}, created.Scopes)
+
+	res = authRequest(t, http.MethodPost, srv.URL+"/api/tokens", session,
+		map[string]interface{}{"name": "bad", "scopes": []string{"everything"}})
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// messages:write allows writes but not reads, settings or tokens.
+	res = authRequest(t, http.MethodPost, srv.URL+"/api/threads", created.Token, map[string]string{"title": "from ci"})
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	res = authRequest(t, http.MethodGet, srv.URL+"/api/threads", created.Token, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	res = authRequest(t, http.MethodGet, srv.URL+"/api/settings", created.Token, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	res = authRequest(t, http.MethodGet, srv.URL+"/api/tokens", created.Token, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	threads, err := store.ListThreads()
+	require.NoError(t, err)
+	require.Len(t, threads, 1)
+
+	// threads:read allows reads, but not streaming a reply, which writes.
+	res = authRequest(t, http.MethodPost, srv.URL+"/api/tokens", session,
+		map[string]interface{}{"name": "reader", "scopes": []string{"threads:read"}})
+	var reader struct {
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&reader))
+	res.Body.Close()
+	res = authRequest(t, http.MethodGet, srv.URL+"/api/threads", reader.Token, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res = authRequest(t, http.MethodGet, srv.URL+"/api/messages/m1/stream?content=hi", reader.Token, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res = authRequest(t, http.MethodGet, srv.URL+"/api/tokens", session, nil)
+	var listed []models.AccessToken
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&listed))
+	res.Body.Close()
+	require.Len(t, listed, 2)
+	require.ElementsMatch(t, []string{"ci", "reader"}, []string{listed[0].Name, listed[1].Name})
+	require.Empty(t, listed[0].TokenHash)
+
+	// Another user can neither see nor revoke the token.
+	bob := register(t, srv, "bob")
+	res = authRequest(t, http.MethodDelete, srv.URL+"/api/tokens/"+created.ID, bob, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res = authRequest(t, http.MethodDelete, srv.URL+"/api/tokens/"+created.ID, session, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	res = authRequest(t, http.MethodPost, srv.URL+"/api/threads", created.Token, map[string]string{"title": "revoked"})
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/krackenservices/threadwell/backup"
+)
+
+// backupHandler streams a backup archive of the caller's workspace
+// @Summary Download a workspace backup
+// @Description Gzip-compressed JSON Lines holding every thread and message
+// @Description of the caller, and their settings. In local mode it covers the
+// @Description whole store. The LLM API key is left out unless secrets=true.
+// @Description Restore it with `threadwell restore`.
+// @Tags admin
+// @Produce application/gzip
+// @Param secrets query bool false "Include the LLM API key"
+// @Success 200 {file} file
+// @Failure 405 {object} map[string]string
+// @Router /api/admin/backup [get]
+func (h *Handler) backupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	opts := backup.Options{IncludeSecrets: r.URL.Query().Get("secrets") == "true"}
+	if u := CurrentUser(r); u != nil {
+		opts.Owner = u.ID
+	}
+
+	name := fmt.Sprintf("threadwell-backup-%s.jsonl.gz", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	// The archive is streamed, so a failure part way through can only be
+	// logged; the client sees a truncated gzip stream.
+	if _, err := backup.Write(w, h.backend, opts); err != nil {
+		log.Printf("backup: %v", err)
+	}
+}
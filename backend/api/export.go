@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/krackenservices/threadwell/export"
+	"github.com/krackenservices/threadwell/models"
+)
+
+// exportHandler downloads a thread, or one branch of it
+// @Summary Export a thread as JSON, Markdown or JSONL
+// @Description Without leaf the whole tree is exported. With leaf only the
+// @Description root-to-leaf path ending at that message is. JSONL writes one
+// @Description {"messages":[{"role","content"}]} line per root-to-leaf path.
+// @Tags threads
+// @Produce json
+// @Produce text/markdown
+// @Produce application/x-ndjson
+// @Param id path string true "Thread ID"
+// @Param format query string false "Export format (default json)" Enums(json, markdown, jsonl)
+// @Param leaf query string false "Export only the branch ending at this message"
+// @Success 200 {object} export.Document
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/threads/{id}/export [get]
+func (h *Handler) exportHandler(w http.ResponseWriter, r *http.Request, id string) {
+	store := h.store(r)
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.FormatJSON
+	}
+	mime, ext, err := export.ContentType(format)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "format must be json, markdown or jsonl")
+		return
+	}
+
+	thread, err := store.GetThread(id)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to fetch thread")
+		return
+	}
+	if thread == nil {
+		WriteError(w, http.StatusNotFound, "thread not found")
+		return
+	}
+
+	var msgs []models.Message
+	if leaf := r.URL.Query().Get("leaf"); leaf != "" {
+		msgs, err = h.ancestorChain(store, leaf)
+		if errors.Is(err, errMessageNotFound) || (err == nil && msgs[0].ThreadID != id) {
+			WriteError(w, http.StatusNotFound, "message not found in thread")
+			return
+		}
+	} else {
+		msgs, err = store.ListMessages(id)
+	}
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to fetch messages")
+		return
+	}
+
+	// Render fully before writing so a failure can still become an error response.
+	var buf bytes.Buffer
+	if err := export.Write(&buf, format, *thread, msgs); err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to export thread")
+		return
+	}
+	w.Header().Set("Content-Type", mime)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+"."+ext))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
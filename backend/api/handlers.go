@@ -2,34 +2,70 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/krackenservices/threadwell/llm"
 	"github.com/krackenservices/threadwell/models"
 	"github.com/krackenservices/threadwell/storage"
 )
 
 // Handler bundles the HTTP mux and storage implementation.
 type Handler struct {
-	mux     *http.ServeMux
-	backend storage.Storage
+	mux         *http.ServeMux
+	serve       http.Handler // mux behind the auth middleware
+	backend     storage.Storage
+	newProvider func(models.Settings) (llm.Provider, error)
+	local       bool
+	signup      bool
+	sessionTTL  time.Duration
+}
+
+// Option customises a Handler built by RegisterRoutes.
+type Option func(*Handler)
+
+// WithProviderFactory overrides how LLM providers are built from settings.
+func WithProviderFactory(f func(models.Settings) (llm.Provider, error)) Option {
+	return func(h *Handler) {
+		h.newProvider = f
+	}
 }
 
 // ServeHTTP satisfies http.Handler by delegating to the internal mux.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.mux.ServeHTTP(w, r)
+	h.serve.ServeHTTP(w, r)
 }
 
-// RegisterRoutes builds and returns an http.Handler for the API.
-func RegisterRoutes(s storage.Storage) http.Handler {
-	h := &Handler{backend: s, mux: http.NewServeMux()}
+// RegisterRoutes builds and returns an http.Handler for the API. Requests
+// need a bearer token unless WithLocalMode is given.
+func RegisterRoutes(s storage.Storage, opts ...Option) http.Handler {
+	h := &Handler{backend: s, mux: http.NewServeMux(), newProvider: llm.NewProvider, sessionTTL: DefaultSessionTTL}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.serve = h.requireAuth(h.mux)
 	h.mux.HandleFunc("/api/threads", h.threadsHandler)
 	h.mux.HandleFunc("/api/threads/", h.threadIDHandler)
 	h.mux.HandleFunc("/api/messages", h.messagesHandler)
 	h.mux.HandleFunc("/api/messages/", h.messageIDHandler)
 	h.mux.HandleFunc("/api/move/", h.moveHandler)
+	h.mux.HandleFunc("/api/search", h.searchHandler)
+	h.mux.HandleFunc("/api/import", h.importHandler)
+	h.mux.HandleFunc("/api/admin/backup", h.backupHandler)
 	h.mux.HandleFunc("/health", healthHandler)
 	h.mux.HandleFunc("/version", versionHandler)
 	h.mux.HandleFunc("/api/settings", h.settingsHandler)
+	h.mux.HandleFunc("/api/providers", h.providersHandler)
+	h.mux.HandleFunc("/api/providers/", h.providerIDHandler)
+	h.mux.HandleFunc("/api/auth/register", h.registerHandler)
+	h.mux.HandleFunc("/api/auth/login", h.loginHandler)
+	h.mux.HandleFunc("/api/auth/logout", h.logoutHandler)
+	h.mux.HandleFunc("/api/auth/me", h.meHandler)
+	h.mux.HandleFunc("/api/tokens", h.tokensHandler)
+	h.mux.HandleFunc("/api/tokens/", h.tokenIDHandler)
 	return h
 }
 
@@ -64,21 +100,45 @@ func versionHandler(w http.ResponseWriter, r *http.Request) {
 
 // threadsHandler handles GET/POST threads
 // @Summary List or create threads
+// @Description When more threads follow, the X-Next-Cursor response header
+// @Description holds the cursor for the next page.
 // @Tags threads
 // @Accept json
 // @Produce json
+// @Param limit query int false "Page size (default: all)"
+// @Param cursor query string false "Cursor from X-Next-Cursor"
+// @Param sort query string false "Sort field" Enums(created_at, updated_at)
+// @Param order query string false "Sort order" Enums(asc, desc)
 // @Success 200 {array} models.Thread
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/threads [get]
 func (h *Handler) threadsHandler(w http.ResponseWriter, r *http.Request) {
+	store := h.store(r)
 	if r.Method == http.MethodGet {
-		threads, err := h.backend.ListThreads()
+		p, err := parsePageParams(r)
 		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		page, err := store.QueryThreads(storage.ThreadQuery{
+			Limit:  p.Limit,
+			Cursor: p.Cursor,
+			SortBy: p.SortBy,
+			Order:  p.Order,
+		})
+		if err != nil {
+			if errors.Is(err, storage.ErrInvalidQuery) {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
 			http.Error(w, `{"error":"failed to fetch threads"}`, http.StatusInternalServerError)
 			return
 		}
+		setNextCursor(w, page.NextCursor)
 		w.Header().Set("Content-Type", "application/json")
-		err = json.NewEncoder(w).Encode(threads)
+		err = json.NewEncoder(w).Encode(page.Items)
 		if err != nil {
 			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 			return
@@ -98,7 +158,22 @@ func (h *Handler) threadsHandler(w http.ResponseWriter, r *http.Request) {
 		if t.CreatedAt == 0 {
 			t.CreatedAt = UnixNow()
 		}
-		if err := h.backend.CreateThread(t); err != nil {
+		if t.UpdatedAt == 0 {
+			t.UpdatedAt = t.CreatedAt
+		}
+		if msg := checkReplySettings(t); msg != "" {
+			WriteError(w, http.StatusBadRequest, msg)
+			return
+		}
+		if ok, err := checkProviderRef(store, t.ProviderID); err != nil || !ok {
+			WriteError(w, http.StatusBadRequest, "provider profile not found")
+			return
+		}
+		if err := store.CreateThread(t); err != nil {
+			if errors.Is(err, storage.ErrConflict) {
+				WriteError(w, http.StatusConflict, "thread id already exists")
+				return
+			}
 			http.Error(w, `{"error":"failed to save thread"}`, http.StatusInternalServerError)
 			return
 		}
@@ -119,24 +194,65 @@ func (h *Handler) threadsHandler(w http.ResponseWriter, r *http.Request) {
 // @Tags messages
 // @Accept json
 // @Produce json
+// @Description Messages are ordered by timestamp, then ID. When more follow,
+// @Description the X-Next-Cursor response header holds the next page's cursor.
 // @Param threadId query string true "Thread ID to filter messages"
+// @Param role query string false "Only messages with this role"
+// @Param since query int false "Only messages at or after this unix time"
+// @Param until query int false "Only messages at or before this unix time"
+// @Param limit query int false "Page size (default: all)"
+// @Param cursor query string false "Cursor from X-Next-Cursor"
+// @Param sort query string false "Sort field" Enums(created_at)
+// @Param order query string false "Sort order" Enums(asc, desc)
 // @Success 200 {array} models.Message
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/messages [get]
 func (h *Handler) messagesHandler(w http.ResponseWriter, r *http.Request) {
+	store := h.store(r)
 	if r.Method == http.MethodGet {
 		threadID := r.URL.Query().Get("threadId")
 		if threadID == "" {
 			http.Error(w, `{"error":"threadId is required"}`, http.StatusBadRequest)
 			return
 		}
-		msgs, err := h.backend.ListMessages(threadID)
+		p, err := parsePageParams(r)
 		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		since, err := parseUnix(r, "since")
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		until, err := parseUnix(r, "until")
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		page, err := store.QueryMessages(storage.MessageQuery{
+			ThreadID: threadID,
+			Role:     r.URL.Query().Get("role"),
+			Since:    since,
+			Until:    until,
+			Limit:    p.Limit,
+			Cursor:   p.Cursor,
+			SortBy:   p.SortBy,
+			Order:    p.Order,
+		})
+		if err != nil {
+			if errors.Is(err, storage.ErrInvalidQuery) {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
 			http.Error(w, `{"error":"failed to fetch messages"}`, http.StatusInternalServerError)
 			return
 		}
+		setNextCursor(w, page.NextCursor)
 		w.Header().Set("Content-Type", "application/json")
-		err = json.NewEncoder(w).Encode(msgs)
+		err = json.NewEncoder(w).Encode(page.Items)
 		if err != nil {
 			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 			return
@@ -156,7 +272,15 @@ func (h *Handler) messagesHandler(w http.ResponseWriter, r *http.Request) {
 		if m.Timestamp == 0 {
 			m.Timestamp = UnixNow()
 		}
-		if err := h.backend.CreateMessage(m); err != nil {
+		if err := store.CreateMessage(m); err != nil {
+			if errors.Is(err, storage.ErrInvalidReference) {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if errors.Is(err, storage.ErrConflict) {
+				WriteError(w, http.StatusConflict, "message id already exists")
+				return
+			}
 			http.Error(w, `{"error":"failed to save message"}`, http.StatusInternalServerError)
 			return
 		}
@@ -172,17 +296,31 @@ func (h *Handler) messagesHandler(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 }
 
+// moveRequest grafts the subtree into an existing thread instead of a new one.
+type moveRequest struct {
+	TargetThreadID string  `json:"target_thread_id"`
+	TargetParentID *string `json:"target_parent_id"`
+}
+
 // moveHandler handles subtree move
-// @Summary Move a message and its descendants to a new thread
+// @Summary Move or copy a message and its descendants to a new thread
+// @Description mode=move (default) removes the subtree from the original thread.
+// @Description mode=copy leaves it in place and also returns the old→new ID map.
+// @Description With a target_thread_id body the subtree is moved under
+// @Description target_parent_id (or as a new root) of that existing thread instead.
 // @Tags messages
 // @Accept json
 // @Produce json
 // @Param id path string true "Message ID to move"
-// @Success 200 {object} map[string]string
+// @Param mode query string false "move or copy" Enums(move, copy)
+// @Param body body moveRequest false "Existing thread and parent to graft into"
+// @Success 200 {object} storage.BranchResult
 // @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/move/{id} [post]
 func (h *Handler) moveHandler(w http.ResponseWriter, r *http.Request) {
+	store := h.store(r)
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -195,8 +333,56 @@ func (h *Handler) moveHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newThreadID, err := h.backend.MoveSubtree(id)
+	var req moveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	switch {
+	case req.TargetThreadID != "":
+		if mode == "copy" {
+			WriteError(w, http.StatusBadRequest, "copy mode cannot target an existing thread")
+			return
+		}
+		res, err := store.GraftSubtree(id, req.TargetThreadID, req.TargetParentID)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				WriteError(w, http.StatusNotFound, "message not found")
+			case errors.Is(err, storage.ErrCycle), errors.Is(err, storage.ErrInvalidReference):
+				WriteError(w, http.StatusBadRequest, err.Error())
+			default:
+				WriteError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		WriteJSON(w, http.StatusOK, res)
+		return
+	case mode == "copy":
+		res, err := store.CopySubtree(id)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				WriteError(w, http.StatusNotFound, "message not found")
+				return
+			}
+			WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		WriteJSON(w, http.StatusOK, res)
+		return
+	case mode != "" && mode != "move":
+		WriteError(w, http.StatusBadRequest, "mode must be move or copy")
+		return
+	}
+
+	newThreadID, err := store.MoveSubtree(id)
 	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			WriteError(w, http.StatusNotFound, "message not found")
+			return
+		}
 		http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusInternalServerError)
 		return
 	}
@@ -209,8 +395,55 @@ func (h *Handler) moveHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// updateThreadPayload is the PATCH body for a thread. Reply settings left
+// out keep their value; an empty provider_id, model or system_prompt, a
+// null temperature or a zero max_tokens resets them to the default.
 type updateThreadPayload struct {
-	Title string `json:"title"`
+	Title        string          `json:"title"`
+	ProviderID   *string         `json:"provider_id"`
+	Model        *string         `json:"model"`
+	SystemPrompt *string         `json:"system_prompt"`
+	Temperature  json.RawMessage `json:"temperature"`
+	MaxTokens    *int            `json:"max_tokens"`
+}
+
+// apply copies the payload's title and the reply settings it names onto t.
+func (p updateThreadPayload) apply(t *models.Thread) error {
+	t.Title = p.Title
+	if p.ProviderID != nil {
+		t.ProviderID = *p.ProviderID
+	}
+	if p.Model != nil {
+		t.Model = *p.Model
+	}
+	if p.SystemPrompt != nil {
+		t.SystemPrompt = *p.SystemPrompt
+	}
+	if len(p.Temperature) > 0 {
+		t.Temperature = nil
+		if err := json.Unmarshal(p.Temperature, &t.Temperature); err != nil {
+			return errors.New("temperature must be a number or null")
+		}
+	}
+	if p.MaxTokens != nil {
+		t.MaxTokens = *p.MaxTokens
+	}
+	return nil
+}
+
+// maxTemperature is the highest sampling temperature a thread may ask for.
+const maxTemperature = 2
+
+// checkReplySettings reports what is wrong with a thread's reply settings,
+// if anything.
+func checkReplySettings(t models.Thread) string {
+	if t.Temperature != nil && (*t.Temperature < 0 || *t.Temperature > maxTemperature) {
+		return "temperature must be between 0 and 2"
+	}
+	if t.MaxTokens < 0 {
+		return "max_tokens must not be negative"
+	}
+	return ""
 }
 
 // threadIDHandler handles PATCH and DELETE for /api/threads/{id}
@@ -219,17 +452,32 @@ type updateThreadPayload struct {
 // @Accept json
 // @Produce json
 // @Param id path string true "Thread ID"
+// @Param body body updateThreadPayload false "Title and reply settings (PATCH)"
 // @Success 200 {object} models.Thread
 // @Failure 400 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/threads/{id} [patch]
 // @Router /api/threads/{id} [delete]
 func (h *Handler) threadIDHandler(w http.ResponseWriter, r *http.Request) {
+	store := h.store(r)
 	id := r.URL.Path[len("/api/threads/"):]
 	if id == "" {
 		WriteError(w, http.StatusBadRequest, "thread ID is required")
 		return
 	}
+	if id, action, ok := strings.Cut(id, "/"); ok {
+		switch action {
+		case "merge":
+			h.mergeHandler(w, r, id)
+		case "tree":
+			h.treeHandler(w, r, id)
+		case "export":
+			h.exportHandler(w, r, id)
+		default:
+			WriteError(w, http.StatusNotFound, "not found")
+		}
+		return
+	}
 
 	switch r.Method {
 	case http.MethodPatch:
@@ -239,15 +487,27 @@ func (h *Handler) threadIDHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		thread, err := h.backend.GetThread(id)
-		if err != nil {
+		thread, err := store.GetThread(id)
+		if err != nil || thread == nil {
 			WriteError(w, http.StatusNotFound, "thread not found")
 			return
 		}
 
-		thread.Title = payload.Title
+		if err := payload.apply(thread); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if msg := checkReplySettings(*thread); msg != "" {
+			WriteError(w, http.StatusBadRequest, msg)
+			return
+		}
+		if ok, err := checkProviderRef(store, thread.ProviderID); err != nil || !ok {
+			WriteError(w, http.StatusBadRequest, "provider profile not found")
+			return
+		}
+		thread.UpdatedAt = UnixNow()
 
-		if err := h.backend.UpdateThread(*thread); err != nil {
+		if err := store.UpdateThread(*thread); err != nil {
 			WriteError(w, http.StatusInternalServerError, "failed to update thread")
 			return
 		}
@@ -255,7 +515,7 @@ func (h *Handler) threadIDHandler(w http.ResponseWriter, r *http.Request) {
 		WriteJSON(w, http.StatusOK, thread)
 
 	case http.MethodDelete:
-		if err := h.backend.DeleteThread(id); err != nil {
+		if err := store.DeleteThread(id); err != nil {
 			WriteError(w, http.StatusInternalServerError, "failed to delete thread")
 			return
 		}
@@ -266,28 +526,75 @@ func (h *Handler) threadIDHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// updateMessagePayload is the PUT body for a message. Only role and content
+// may change; thread_id and parent_id are accepted only if they are unchanged.
+type updateMessagePayload struct {
+	Role     *string         `json:"role"`
+	Content  *string         `json:"content"`
+	ThreadID *string         `json:"thread_id"`
+	ParentID json.RawMessage `json:"parent_id"`
+}
+
+// immutableChange reports why the payload may not be applied to msg, if at all.
+func (p updateMessagePayload) immutableChange(msg *models.Message) string {
+	if p.ThreadID != nil && *p.ThreadID != msg.ThreadID {
+		return "thread_id cannot be changed"
+	}
+	if len(p.ParentID) > 0 {
+		var parentID *string
+		if err := json.Unmarshal(p.ParentID, &parentID); err != nil {
+			return "invalid parent_id"
+		}
+		same := (parentID == nil && msg.ParentID == nil) ||
+			(parentID != nil && msg.ParentID != nil && *parentID == *msg.ParentID)
+		if !same {
+			return "parent_id cannot be changed"
+		}
+	}
+	return ""
+}
+
 // messageIDHandler handles GET, PUT, DELETE for /api/messages/{id}
 // @Summary Get, update or delete a message by ID
 // @Tags messages
 // @Accept json
 // @Produce json
 // @Param id path string true "Message ID"
+// @Param cascade query bool false "DELETE only: also remove all replies"
 // @Success 200 {object} models.Message
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Router /api/messages/{id} [get]
 // @Router /api/messages/{id} [put]
 // @Router /api/messages/{id} [delete]
 func (h *Handler) messageIDHandler(w http.ResponseWriter, r *http.Request) {
+	store := h.store(r)
 	id := r.URL.Path[len("/api/messages/"):]
 	if id == "" {
 		http.Error(w, `{"error":"id required"}`, http.StatusBadRequest)
 		return
 	}
+	if id, action, ok := strings.Cut(id, "/"); ok {
+		action, rest, _ := strings.Cut(action, "/")
+		switch {
+		case action == "reply" && rest == "":
+			h.replyHandler(w, r, id)
+		case action == "stream" && rest == "":
+			h.streamHandler(w, r, id)
+		case action == "path" && rest == "":
+			h.pathHandler(w, r, id)
+		case action == "revisions":
+			h.revisionsHandler(w, r, id, rest)
+		default:
+			WriteError(w, http.StatusNotFound, "not found")
+		}
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
-		msg, err := h.backend.GetMessage(id)
+		msg, err := store.GetMessage(id)
 		if err != nil {
 			http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusInternalServerError)
 			return
@@ -304,77 +611,158 @@ func (h *Handler) messageIDHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 	case http.MethodPut:
-		var m models.Message
-		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
-			http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+		var payload updateMessagePayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+
+		msg, err := store.GetMessage(id)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, "failed to load message")
 			return
 		}
-		m.ID = id
-		if err := h.backend.DeleteMessage(id); err != nil {
-			http.Error(w, `{"error":"could not delete old message"}`, http.StatusInternalServerError)
+		if msg == nil {
+			WriteError(w, http.StatusNotFound, "message not found")
 			return
 		}
-		if err := h.backend.CreateMessage(m); err != nil {
-			http.Error(w, `{"error":"failed to update message"}`, http.StatusInternalServerError)
+		if reason := payload.immutableChange(msg); reason != "" {
+			WriteError(w, http.StatusBadRequest, reason)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		err := json.NewEncoder(w).Encode(m)
-		if err != nil {
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+
+		if payload.Role != nil {
+			msg.Role = *payload.Role
+		}
+		if payload.Content != nil {
+			msg.Content = *payload.Content
+		}
+		if err := store.UpdateMessage(*msg); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				WriteError(w, http.StatusNotFound, "message not found")
+				return
+			}
+			WriteError(w, http.StatusInternalServerError, "failed to update message")
 			return
 		}
+		WriteJSON(w, http.StatusOK, msg)
 
 	case http.MethodDelete:
-		if err := h.backend.DeleteMessage(id); err != nil {
-			http.Error(w, `{"error":"failed to delete"}`, http.StatusInternalServerError)
+		if r.URL.Query().Get("cascade") == "true" {
+			deleted, err := store.DeleteSubtree(id)
+			if err != nil {
+				if errors.Is(err, storage.ErrNotFound) {
+					WriteError(w, http.StatusNotFound, "message not found")
+					return
+				}
+				WriteError(w, http.StatusInternalServerError, "failed to delete")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"deleted": id, "deleted_ids": deleted})
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		err := json.NewEncoder(w).Encode(map[string]string{"deleted": id})
+
+		msg, err := store.GetMessage(id)
 		if err != nil {
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			WriteError(w, http.StatusInternalServerError, "failed to load message")
+			return
+		}
+		if msg == nil {
+			WriteError(w, http.StatusNotFound, "message not found")
 			return
 		}
+		if err := store.DeleteMessage(id); err != nil {
+			if errors.Is(err, storage.ErrHasChildren) {
+				WriteError(w, http.StatusConflict, "message has replies; use cascade=true to delete them too")
+				return
+			}
+			WriteError(w, http.StatusInternalServerError, "failed to delete")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"deleted": id, "deleted_ids": []string{id}})
 
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+// settingsResponse is what GET and PUT /api/settings return. The API key
+// itself is never sent back; HasAPIKey and APIKeyHint describe it.
+type settingsResponse struct {
+	models.Settings
+	HasAPIKey  bool   `json:"has_api_key"`
+	APIKeyHint string `json:"llm_api_key_hint,omitempty"`
+}
+
+// settingsPayload is the PUT body for /api/settings. An empty or missing
+// llm_api_key keeps the stored key; clear_llm_api_key removes it.
+type settingsPayload struct {
+	models.Settings
+	ClearAPIKey bool `json:"clear_llm_api_key"`
+}
+
+func newSettingsResponse(cfg models.Settings) settingsResponse {
+	out := settingsResponse{Settings: cfg, HasAPIKey: cfg.LLMApiKey != ""}
+	if out.HasAPIKey {
+		out.APIKeyHint = maskKey(cfg.LLMApiKey)
+	}
+	out.LLMApiKey = ""
+	return out
+}
+
+// maskKey keeps the last four characters of a long key so users can tell
+// which one is stored.
+func maskKey(key string) string {
+	if len(key) < 12 {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
+
 // SettingsHandler handles GET, PUT for /api/settings.
 // @Summary Get or Update settings
+// @Description The API key is write-only: responses carry has_api_key and a
+// @Description masked llm_api_key_hint instead. A PUT without llm_api_key
+// @Description keeps the stored key; clear_llm_api_key removes it.
 // @Accept json
 // @Produce json
-// @Param body body models.Settings true "Updated settings"
-// @Success 200 {object} models.Settings
+// @Param body body settingsPayload true "Updated settings"
+// @Success 200 {object} settingsResponse
 // @Router /api/settings [get]
 // @Router /api/settings [put]
 func (h *Handler) settingsHandler(w http.ResponseWriter, r *http.Request) {
+	store := h.store(r)
 	switch r.Method {
 	case http.MethodGet:
-		cfg, err := h.backend.GetSettings()
+		cfg, err := store.GetSettings(storage.DefaultSettingsID)
 		if err != nil {
 			WriteError(w, http.StatusInternalServerError, "Failed to load settings")
 			return
 		}
-		//cfg.LLMApiKey = "" // TODO: scrub sensitive field - this is annoying as if we dont leave it then user would have to re-enter
-		WriteJSON(w, http.StatusOK, cfg)
+		WriteJSON(w, http.StatusOK, newSettingsResponse(*cfg))
 		return
 
 	case http.MethodPut:
-		var cfg models.Settings
-		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		var payload settingsPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 			WriteError(w, http.StatusBadRequest, "Invalid JSON body")
 			return
 		}
-		cfg.ID = "default" // force ID for upsert
-		if err := h.backend.UpdateSettings(cfg); err != nil {
+		cfg := payload.Settings
+		cfg.ID = storage.DefaultSettingsID // a user's view stores it under their ID
+		if cfg.LLMApiKey == "" && !payload.ClearAPIKey {
+			current, err := store.GetSettings(storage.DefaultSettingsID)
+			if err != nil {
+				WriteError(w, http.StatusInternalServerError, "Failed to load settings")
+				return
+			}
+			cfg.LLMApiKey = current.LLMApiKey
+		}
+		if err := store.UpdateSettings(cfg); err != nil {
 			WriteError(w, http.StatusInternalServerError, "Failed to update settings")
 			return
 		}
-		cfg.LLMApiKey = ""
-		WriteJSON(w, http.StatusOK, cfg)
+		WriteJSON(w, http.StatusOK, newSettingsResponse(cfg))
 		return
 
 	default:
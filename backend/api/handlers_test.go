@@ -2,22 +2,31 @@ package api_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/stretchr/testify/require"
 	"io"
+	"mime"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 
 	"github.com/krackenservices/threadwell/api"
+	"github.com/krackenservices/threadwell/backup"
+	"github.com/krackenservices/threadwell/export"
+	"github.com/krackenservices/threadwell/importer"
+	"github.com/krackenservices/threadwell/llm"
 	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
 	"github.com/krackenservices/threadwell/storage/memory"
 )
 
 func newTestServer() *httptest.Server {
 	store := memory.New()
-	handler := api.RegisterRoutes(store)
+	handler := api.RegisterRoutes(store, api.WithLocalMode())
 	return httptest.NewServer(handler)
 }
 
@@ -138,6 +147,27 @@ func TestMessageCRUD(t *testing.T) {
 	}
 }
 
+func TestCreateWithTakenIDConflicts(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	res := postJSON(t, srv.URL+"/api/threads", models.Thread{ID: "t1", Title: "first"})
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	res = postJSON(t, srv.URL+"/api/threads", models.Thread{ID: "t1", Title: "second"})
+	res.Body.Close()
+	require.Equal(t, http.StatusConflict, res.StatusCode)
+
+	msg := models.Message{ID: "m1", ThreadID: "t1", Role: "user", Content: "first"}
+	res = postJSON(t, srv.URL+"/api/messages", msg)
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	msg.Content = "second"
+	res = postJSON(t, srv.URL+"/api/messages", msg)
+	res.Body.Close()
+	require.Equal(t, http.StatusConflict, res.StatusCode)
+}
+
 func TestMessagePUTAndDELETE(t *testing.T) {
 	srv := newTestServer()
 	defer srv.Close()
@@ -200,6 +230,148 @@ func TestMessagePUTAndDELETE(t *testing.T) {
 	}
 }
 
+func TestMessagePUTRejectsStructuralChanges(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	res := postJSON(t, srv.URL+"/api/threads", models.Thread{Title: "edit"})
+	var thread models.Thread
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&thread))
+	res.Body.Close()
+
+	res = postJSON(t, srv.URL+"/api/messages", models.Message{ThreadID: thread.ID, Role: "user", Content: "root"})
+	var root models.Message
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&root))
+	res.Body.Close()
+
+	put := func(id, body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/api/messages/"+id, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	require.Equal(t, http.StatusNotFound, put("missing", `{"content":"x"}`).StatusCode)
+	require.Equal(t, http.StatusBadRequest, put(root.ID, `{"content":"x","thread_id":"other"}`).StatusCode)
+	require.Equal(t, http.StatusBadRequest, put(root.ID, `{"content":"x","parent_id":"other"}`).StatusCode)
+	require.Equal(t, http.StatusOK, put(root.ID, `{"content":"only content"}`).StatusCode)
+
+	res, err := http.Get(srv.URL + "/api/messages/" + root.ID)
+	require.NoError(t, err)
+	var got models.Message
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+	res.Body.Close()
+	require.Equal(t, "only content", got.Content)
+	require.Equal(t, "user", got.Role)
+	require.Equal(t, thread.ID, got.ThreadID)
+}
+
+func TestMessageRevisionsAndRestore(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	res := postJSON(t, srv.URL+"/api/threads", models.Thread{Title: "history"})
+	var thread models.Thread
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&thread))
+	res.Body.Close()
+
+	res = postJSON(t, srv.URL+"/api/messages", models.Message{ThreadID: thread.ID, Role: "user", Content: "original"})
+	var msg models.Message
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&msg))
+	res.Body.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/api/messages/"+msg.ID, strings.NewReader(`{"content":"edited"}`))
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, err = http.Get(srv.URL + "/api/messages/" + msg.ID + "/revisions")
+	require.NoError(t, err)
+	var revs []models.MessageRevision
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&revs))
+	res.Body.Close()
+	require.Len(t, revs, 1)
+	require.Equal(t, "original", revs[0].Content)
+
+	res, err = http.Post(srv.URL+"/api/messages/"+msg.ID+"/revisions/"+revs[0].ID+"/restore", "application/json", nil)
+	require.NoError(t, err)
+	var restored models.Message
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&restored))
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "original", restored.Content)
+
+	res, err = http.Get(srv.URL + "/api/messages/" + msg.ID + "/revisions")
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&revs))
+	res.Body.Close()
+	require.Len(t, revs, 2)
+	require.Equal(t, "edited", revs[1].Content)
+
+	res, err = http.Post(srv.URL+"/api/messages/"+msg.ID+"/revisions/missing/restore", "application/json", nil)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res, err = http.Get(srv.URL + "/api/messages/missing/revisions")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestMessageDELETECascade(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	res := postJSON(t, srv.URL+"/api/threads", models.Thread{Title: "prune"})
+	var thread models.Thread
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&thread))
+	res.Body.Close()
+
+	res = postJSON(t, srv.URL+"/api/messages", models.Message{ThreadID: thread.ID, Role: "user", Content: "root"})
+	var root models.Message
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&root))
+	res.Body.Close()
+
+	res = postJSON(t, srv.URL+"/api/messages", models.Message{ThreadID: thread.ID, ParentID: &root.ID, RootID: &root.ID, Role: "assistant", Content: "child"})
+	var child models.Message
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&child))
+	res.Body.Close()
+
+	del := func(path string) *http.Response {
+		req, _ := http.NewRequest(http.MethodDelete, srv.URL+path, nil)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return res
+	}
+
+	res = del("/api/messages/" + root.ID)
+	res.Body.Close()
+	require.Equal(t, http.StatusConflict, res.StatusCode)
+
+	res = del("/api/messages/missing")
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res = del("/api/messages/" + root.ID + "?cascade=true")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var out struct {
+		DeletedIDs []string `json:"deleted_ids"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&out))
+	res.Body.Close()
+	require.ElementsMatch(t, []string{root.ID, child.ID}, out.DeletedIDs)
+
+	res, err := http.Get(srv.URL + "/api/messages?threadId=" + thread.ID)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.Equal(t, "[]", strings.TrimSpace(string(body)))
+}
+
 func TestMoveSubtree(t *testing.T) {
 	srv := newTestServer()
 	defer srv.Close()
@@ -266,6 +438,294 @@ func TestMoveSubtree(t *testing.T) {
 	}
 }
 
+func TestMoveSubtreeCopyMode(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	res := postJSON(t, srv.URL+"/api/threads", models.Thread{Title: "copy-test"})
+	var thread models.Thread
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&thread))
+	res.Body.Close()
+
+	res = postJSON(t, srv.URL+"/api/messages", models.Message{ThreadID: thread.ID, Role: "user", Content: "root"})
+	var root models.Message
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&root))
+	res.Body.Close()
+
+	res, err := http.Post(srv.URL+"/api/move/"+root.ID+"?mode=copy", "application/json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var out struct {
+		ThreadID string            `json:"thread_id"`
+		IDMap    map[string]string `json:"id_map"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&out))
+	res.Body.Close()
+	require.NotEmpty(t, out.ThreadID)
+	require.Contains(t, out.IDMap, root.ID)
+
+	// Source message still exists
+	res, err = http.Get(srv.URL + "/api/messages/" + root.ID)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, err = http.Post(srv.URL+"/api/move/"+root.ID+"?mode=teleport", "application/json", nil)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestMoveSubtreeIntoExistingThread(t *testing.T) {
+	store := memory.New()
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithLocalMode()))
+	defer srv.Close()
+
+	root := "root"
+	require.NoError(t, store.CreateThread(models.Thread{ID: "src", Title: "source"}))
+	require.NoError(t, store.CreateThread(models.Thread{ID: "dst", Title: "target"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: root, ThreadID: "src", Role: "user", Content: "root"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "child", ThreadID: "src", ParentID: &root, RootID: &root, Role: "assistant", Content: "child"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "anchor", ThreadID: "dst", Role: "user", Content: "anchor"}))
+
+	res := postJSON(t, srv.URL+"/api/move/child", map[string]string{"target_thread_id": "dst", "target_parent_id": "anchor"})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var out struct {
+		ThreadID string            `json:"thread_id"`
+		IDMap    map[string]string `json:"id_map"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&out))
+	res.Body.Close()
+	require.Equal(t, "dst", out.ThreadID)
+
+	moved, err := store.GetMessage(out.IDMap["child"])
+	require.NoError(t, err)
+	require.Equal(t, "anchor", *moved.ParentID)
+
+	// Grafting a message under its own descendant is rejected
+	res = postJSON(t, srv.URL+"/api/move/anchor", map[string]string{"target_thread_id": "dst", "target_parent_id": moved.ID})
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestMergeThreads(t *testing.T) {
+	store := memory.New()
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithLocalMode()))
+	defer srv.Close()
+
+	require.NoError(t, store.CreateThread(models.Thread{ID: "src", Title: "source"}))
+	require.NoError(t, store.CreateThread(models.Thread{ID: "dst", Title: "target"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "anchor", ThreadID: "dst", Role: "user", Content: "anchor"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "other", ThreadID: "src", Role: "user", Content: "other"}))
+
+	res := postJSON(t, srv.URL+"/api/threads/dst/merge", map[string]interface{}{
+		"source_thread_id": "src",
+		"target_parent_id": "anchor",
+		"delete_source":    true,
+	})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var out storage.MergeResult
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&out))
+	res.Body.Close()
+	require.Equal(t, "dst", out.ThreadID)
+	require.True(t, out.SourceDeleted)
+
+	merged, err := store.GetMessage(out.IDMap["other"])
+	require.NoError(t, err)
+	require.Equal(t, "anchor", *merged.ParentID)
+
+	res = postJSON(t, srv.URL+"/api/threads/missing/merge", map[string]string{"source_thread_id": "dst"})
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res = postJSON(t, srv.URL+"/api/threads/dst/merge", map[string]string{"source_thread_id": "src"})
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestThreadTreeAndMessagePath(t *testing.T) {
+	store := memory.New()
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithLocalMode()))
+	defer srv.Close()
+
+	root, mid := "root", "mid"
+	require.NoError(t, store.CreateThread(models.Thread{ID: "t1", Title: "tree"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: root, ThreadID: "t1", Role: "user", Content: "root", Timestamp: 1}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: mid, ThreadID: "t1", ParentID: &root, RootID: &root, Role: "assistant", Content: "mid", Timestamp: 2}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "leaf", ThreadID: "t1", ParentID: &mid, RootID: &root, Role: "user", Content: "leaf", Timestamp: 3}))
+
+	res, err := http.Get(srv.URL + "/api/threads/t1/tree")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var tree []storage.TreeNode
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&tree))
+	res.Body.Close()
+	require.Len(t, tree, 1)
+	require.Equal(t, "root", tree[0].ID)
+	require.Equal(t, 2, tree[0].DescendantCount)
+	require.Equal(t, "leaf", tree[0].Children[0].Children[0].ID)
+	require.Equal(t, 2, tree[0].Children[0].Children[0].Depth)
+
+	res, err = http.Get(srv.URL + "/api/messages/leaf/path")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var path []models.Message
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&path))
+	res.Body.Close()
+	require.Len(t, path, 3)
+	require.Equal(t, []string{"root", "mid", "leaf"}, []string{path[0].ID, path[1].ID, path[2].ID})
+
+	res, err = http.Get(srv.URL + "/api/threads/missing/tree")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res, err = http.Get(srv.URL + "/api/messages/missing/path")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestListPagination(t *testing.T) {
+	store := memory.New()
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithLocalMode()))
+	defer srv.Close()
+
+	for i, id := range []string{"t1", "t2", "t3"} {
+		require.NoError(t, store.CreateThread(models.Thread{ID: id, Title: id, CreatedAt: int64(i + 1)}))
+	}
+	for i, role := range []string{"user", "assistant", "user"} {
+		require.NoError(t, store.CreateMessage(models.Message{ID: fmt.Sprintf("m%d", i), ThreadID: "t1", Role: role, Timestamp: int64(100 + i)}))
+	}
+
+	res, err := http.Get(srv.URL + "/api/threads?limit=2&order=desc")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var threads []models.Thread
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&threads))
+	res.Body.Close()
+	require.Len(t, threads, 2)
+	require.Equal(t, "t3", threads[0].ID)
+	cursor := res.Header.Get(api.NextCursorHeader)
+	require.NotEmpty(t, cursor)
+
+	res, err = http.Get(srv.URL + "/api/threads?limit=2&order=desc&cursor=" + cursor)
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&threads))
+	res.Body.Close()
+	require.Len(t, threads, 1)
+	require.Equal(t, "t1", threads[0].ID)
+	require.Empty(t, res.Header.Get(api.NextCursorHeader))
+
+	res, err = http.Get(srv.URL + "/api/messages?threadId=t1&role=user&since=101")
+	require.NoError(t, err)
+	var msgs []models.Message
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&msgs))
+	res.Body.Close()
+	require.Len(t, msgs, 1)
+	require.Equal(t, "m2", msgs[0].ID)
+
+	for _, q := range []string{"/api/threads?limit=0", "/api/threads?sort=title", "/api/messages?threadId=t1&cursor=bogus", "/api/messages?threadId=t1&since=yesterday"} {
+		res, err = http.Get(srv.URL + q)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusBadRequest, res.StatusCode, q)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	store := memory.New()
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithLocalMode()))
+	defer srv.Close()
+
+	require.NoError(t, store.CreateThread(models.Thread{ID: "t1", Title: "Gardening"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "m1", ThreadID: "t1", Role: "user", Content: "When should I plant tomato seedlings?"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "m2", ThreadID: "t1", Role: "assistant", Content: "Plant seedlings after the last frost."}))
+
+	res, err := http.Get(srv.URL + "/api/search?q=" + url.QueryEscape(`"plant tomato"`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var results []storage.SearchResult
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&results))
+	res.Body.Close()
+	require.Len(t, results, 1)
+	require.Equal(t, "m1", results[0].MessageID)
+	require.Equal(t, "t1", results[0].ThreadID)
+	require.Contains(t, results[0].Snippet, "**plant tomato**")
+
+	res, err = http.Get(srv.URL + "/api/search?q=seedlings&role=assistant")
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&results))
+	res.Body.Close()
+	require.Len(t, results, 1)
+	require.Equal(t, "m2", results[0].MessageID)
+
+	for _, q := range []string{"/api/search", "/api/search?q=x&limit=500"} {
+		res, err = http.Get(srv.URL + q)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusBadRequest, res.StatusCode, q)
+	}
+}
+
+func TestThreadExport(t *testing.T) {
+	store := memory.New()
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithLocalMode()))
+	defer srv.Close()
+
+	root, mid := "root", "mid"
+	require.NoError(t, store.CreateThread(models.Thread{ID: "t1", Title: "export"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: root, ThreadID: "t1", Role: "user", Content: "hi", Timestamp: 1}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: mid, ThreadID: "t1", ParentID: &root, RootID: &root, Role: "assistant", Content: "hello", Timestamp: 2}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "other", ThreadID: "t1", ParentID: &root, RootID: &root, Role: "assistant", Content: "hey", Timestamp: 3}))
+
+	res, err := http.Get(srv.URL + "/api/threads/t1/export?format=jsonl")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/x-ndjson", res.Header.Get("Content-Type"))
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.TrimSpace(string(body)), "\n"), 2)
+
+	res, err = http.Get(srv.URL + "/api/threads/t1/export?format=markdown&leaf=mid")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	body, err = io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Contains(t, string(body), "  - **assistant**: hello")
+	require.NotContains(t, string(body), "hey")
+
+	res, err = http.Get(srv.URL + "/api/threads/t1/export")
+	require.NoError(t, err)
+	var doc export.Document
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&doc))
+	res.Body.Close()
+	require.Len(t, doc.Messages, 3)
+
+	for q, status := range map[string]int{
+		"/api/threads/t1/export?format=pdf":     http.StatusBadRequest,
+		"/api/threads/t1/export?leaf=missing":   http.StatusNotFound,
+		"/api/threads/nope/export?format=jsonl": http.StatusNotFound,
+	} {
+		res, err = http.Get(srv.URL + q)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, status, res.StatusCode, q)
+	}
+
+	// The file name stays a single quoted parameter whatever the ID holds.
+	require.NoError(t, store.CreateThread(models.Thread{ID: `we"ird`, Title: "quotes"}))
+	res, err = http.Get(srv.URL + "/api/threads/we%22ird/export")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	_, params, err := mime.ParseMediaType(res.Header.Get("Content-Disposition"))
+	require.NoError(t, err)
+	require.Equal(t, `we"ird.json`, params["filename"])
+}
+
 func TestThreadPatch(t *testing.T) {
 	srv := newTestServer()
 	defer srv.Close()
@@ -291,3 +751,419 @@ func TestThreadPatch(t *testing.T) {
 	res.Body.Close()
 	require.Equal(t, "after", updated.Title)
 }
+
+type recordingProvider struct {
+	reply string
+	got   []llm.Message
+}
+
+func (p *recordingProvider) Complete(ctx context.Context, req llm.Request) (llm.Response, error) {
+	p.got = req.Messages
+	return llm.Response{Content: p.reply}, nil
+}
+
+func postJSON(t *testing.T, url string, v interface{}) *http.Response {
+	buf := new(bytes.Buffer)
+	require.NoError(t, json.NewEncoder(buf).Encode(v))
+	res, err := http.Post(url, "application/json", buf)
+	require.NoError(t, err)
+	return res
+}
+
+func TestReplyUsesOnlyAncestorChain(t *testing.T) {
+	store := memory.New()
+	fake := &recordingProvider{reply: "branch answer"}
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithLocalMode(), api.WithProviderFactory(
+		func(models.Settings) (llm.Provider, error) { return fake, nil },
+	)))
+	defer srv.Close()
+
+	root := "root"
+	require.NoError(t, store.CreateThread(models.Thread{ID: "t1", Title: "reply"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: root, ThreadID: "t1", Role: "user", Content: "question"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "a1", ThreadID: "t1", ParentID: &root, RootID: &root, Role: "assistant", Content: "answer"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "sibling", ThreadID: "t1", ParentID: &root, RootID: &root, Role: "user", Content: "other branch"}))
+
+	res, err := http.Post(srv.URL+"/api/messages/a1/reply", "application/json", strings.NewReader(`{"content":"follow up"}`))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	var out struct {
+		User      models.Message `json:"user"`
+		Assistant models.Message `json:"assistant"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&out))
+
+	require.Equal(t, []llm.Message{
+		{Role: "user", Content: "question"},
+		{Role: "assistant", Content: "answer"},
+		{Role: "user", Content: "follow up"},
+	}, fake.got)
+
+	require.Equal(t, "follow up", out.User.Content)
+	require.Equal(t, "a1", *out.User.ParentID)
+	require.Equal(t, "branch answer", out.Assistant.Content)
+	require.Equal(t, "assistant", out.Assistant.Role)
+	require.Equal(t, out.User.ID, *out.Assistant.ParentID)
+	require.Equal(t, root, *out.Assistant.RootID)
+
+	stored, err := store.GetMessage(out.Assistant.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	require.Equal(t, "branch answer", stored.Content)
+}
+
+func TestReplySimulatedWithoutBody(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	res := postJSON(t, srv.URL+"/api/threads", models.Thread{Title: "sim"})
+	var thread models.Thread
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&thread))
+	res.Body.Close()
+
+	res = postJSON(t, srv.URL+"/api/messages", models.Message{ThreadID: thread.ID, Role: "user", Content: "ping"})
+	var msg models.Message
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&msg))
+	res.Body.Close()
+
+	res, err := http.Post(srv.URL+"/api/messages/"+msg.ID+"/reply", "application/json", nil)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	var out map[string]models.Message
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&out))
+	require.Equal(t, msg.ID, out["user"].ID)
+	require.Equal(t, "**(Simulated)** You said: ping", out["assistant"].Content)
+}
+
+// failingProvider always errors, like an unreachable model.
+type failingProvider struct{}
+
+func (failingProvider) Complete(context.Context, llm.Request) (llm.Response, error) {
+	return llm.Response{}, fmt.Errorf("upstream down")
+}
+
+func TestReplyProviderFailureKeepsThreadUnchanged(t *testing.T) {
+	store := memory.New()
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithLocalMode(), api.WithProviderFactory(
+		func(models.Settings) (llm.Provider, error) { return failingProvider{}, nil },
+	)))
+	defer srv.Close()
+
+	require.NoError(t, store.CreateThread(models.Thread{ID: "t1", Title: "fails"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "m1", ThreadID: "t1", Role: "user", Content: "hi"}))
+
+	// Retrying must not pile up copies of the posted message.
+	for i := 0; i < 2; i++ {
+		res, err := http.Post(srv.URL+"/api/messages/m1/reply", "application/json", strings.NewReader(`{"content":"again"}`))
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusBadGateway, res.StatusCode)
+	}
+	msgs, err := store.ListMessages("t1")
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+}
+
+func TestReplyUnknownMessage(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/api/messages/missing/reply", "application/json", nil)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestImport(t *testing.T) {
+	store := memory.New()
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithLocalMode()))
+	defer srv.Close()
+
+	body := `{"title": "ok", "messages": [{"role": "user", "content": "hi"}, {"role": "assistant", "content": "hello"}]}
+{"messages": []}`
+	res, err := http.Post(srv.URL+"/api/import", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var report importer.Report
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&report))
+	res.Body.Close()
+	require.Equal(t, 1, report.Imported)
+	require.Equal(t, 1, report.Failed)
+	require.Equal(t, 2, report.Results[0].Messages)
+	require.NotEmpty(t, report.Results[1].Error)
+
+	msgs, err := store.ListMessages(report.Results[0].ThreadID)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+
+	res, err = http.Post(srv.URL+"/api/import", "application/json", strings.NewReader(`{"nope": true}`))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res, err = http.Get(srv.URL + "/api/import")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+}
+
+func TestAdminBackup(t *testing.T) {
+	store := memory.New()
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithLocalMode()))
+	defer srv.Close()
+
+	require.NoError(t, store.CreateThread(models.Thread{ID: "t1", Title: "keep me"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "m1", ThreadID: "t1", Role: "user", Content: "hi"}))
+	require.NoError(t, store.UpdateSettings(models.Settings{ID: "default", LLMApiKey: "sk-secret"}))
+
+	res, err := http.Get(srv.URL + "/api/admin/backup")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/gzip", res.Header.Get("Content-Type"))
+	require.Contains(t, res.Header.Get("Content-Disposition"), "threadwell-backup-")
+
+	restored := memory.New()
+	sum, err := backup.Restore(restored, res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, 1, sum.Threads)
+	require.Equal(t, 1, sum.Messages)
+	settings, err := restored.GetSettings(storage.DefaultSettingsID)
+	require.NoError(t, err)
+	require.Empty(t, settings.LLMApiKey)
+
+	res, err = http.Post(srv.URL+"/api/admin/backup", "application/json", nil)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+}
+
+func TestSettingsAPIKeyIsWriteOnly(t *testing.T) {
+	store := memory.New()
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithLocalMode()))
+	defer srv.Close()
+
+	put := func(body string) map[string]interface{} {
+		req, err := http.NewRequest(http.MethodPut, srv.URL+"/api/settings", strings.NewReader(body))
+		require.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var out map[string]interface{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&out))
+		return out
+	}
+	get := func() map[string]interface{} {
+		res, err := http.Get(srv.URL + "/api/settings")
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var out map[string]interface{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&out))
+		return out
+	}
+
+	out := put(`{"llm_provider":"openai","llm_api_key":"sk-test-abcdefgh1234"}`)
+	require.NotContains(t, out, "llm_api_key")
+	require.Equal(t, true, out["has_api_key"])
+
+	out = get()
+	require.NotContains(t, out, "llm_api_key")
+	require.Equal(t, true, out["has_api_key"])
+	require.Equal(t, "****1234", out["llm_api_key_hint"])
+
+	// Saving without a key keeps the stored one.
+	put(`{"llm_provider":"openai","llm_model":"gpt-4o"}`)
+	cfg, err := store.GetSettings(storage.DefaultSettingsID)
+	require.NoError(t, err)
+	require.Equal(t, "sk-test-abcdefgh1234", cfg.LLMApiKey)
+	require.Equal(t, "gpt-4o", cfg.LLMName)
+
+	out = put(`{"llm_provider":"openai","clear_llm_api_key":true}`)
+	require.Equal(t, false, out["has_api_key"])
+	cfg, err = store.GetSettings(storage.DefaultSettingsID)
+	require.NoError(t, err)
+	require.Empty(t, cfg.LLMApiKey)
+}
+
+func TestProviderProfiles(t *testing.T) {
+	store := memory.New()
+	var used models.Settings
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithLocalMode(), api.WithProviderFactory(
+		func(cfg models.Settings) (llm.Provider, error) {
+			used = cfg
+			return &recordingProvider{reply: "ok"}, nil
+		},
+	)))
+	defer srv.Close()
+
+	send := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return res
+	}
+	create := func(body string) map[string]interface{} {
+		res := send(http.MethodPost, "/api/providers", body)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		var out map[string]interface{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&out))
+		return out
+	}
+	reply := func(body string) int {
+		res := send(http.MethodPost, "/api/messages/m1/reply", body)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// The first profile becomes the default; keys are never returned.
+	local := create(`{"name":"local","llm_provider":"ollama","llm_model":"llama3"}`)
+	require.Equal(t, true, local["is_default"])
+	hosted := create(`{"name":"hosted","llm_provider":"openai","llm_model":"gpt-4o","llm_api_key":"sk-test-abcdefgh1234"}`)
+	require.Equal(t, false, hosted["is_default"])
+	require.NotContains(t, hosted, "llm_api_key")
+	require.Equal(t, "****1234", hosted["llm_api_key_hint"])
+	localID, hostedID := local["id"].(string), hosted["id"].(string)
+
+	res := send(http.MethodPost, "/api/providers", `{"name":"bad","llm_provider":"nope"}`)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	require.NoError(t, store.CreateThread(models.Thread{ID: "t1", Title: "profiles"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "m1", ThreadID: "t1", Role: "user", Content: "hi"}))
+
+	// Replies use the default profile, then the thread's, then the request's.
+	require.Equal(t, http.StatusCreated, reply(""))
+	require.Equal(t, "llama3", used.LLMName)
+
+	res = send(http.MethodPatch, "/api/threads/t1", `{"title":"profiles","provider_id":"missing"}`)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res = send(http.MethodPatch, "/api/threads/t1", `{"title":"profiles","provider_id":"`+hostedID+`"}`)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, http.StatusCreated, reply(""))
+	require.Equal(t, "gpt-4o", used.LLMName)
+	require.Equal(t, "sk-test-abcdefgh1234", used.LLMApiKey)
+
+	require.Equal(t, http.StatusCreated, reply(`{"provider_id":"`+localID+`"}`))
+	require.Equal(t, "llama3", used.LLMName)
+	require.Equal(t, http.StatusBadRequest, reply(`{"provider_id":"missing"}`))
+
+	// Updating without a key keeps it, and moving the default clears the old one.
+	res = send(http.MethodPut, "/api/providers/"+hostedID, `{"name":"hosted","llm_provider":"openai","llm_model":"gpt-4.1","is_default":true}`)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	old, err := store.GetProviderProfile(localID)
+	require.NoError(t, err)
+	require.False(t, old.IsDefault)
+	updated, err := store.GetProviderProfile(hostedID)
+	require.NoError(t, err)
+	require.True(t, updated.IsDefault)
+	require.Equal(t, "sk-test-abcdefgh1234", updated.LLMApiKey)
+
+	// Deleting the thread's profile falls back to the settings.
+	res = send(http.MethodDelete, "/api/providers/"+hostedID, "")
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res = send(http.MethodGet, "/api/providers/"+hostedID, "")
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	thread, err := store.GetThread("t1")
+	require.NoError(t, err)
+	require.Empty(t, thread.ProviderID)
+	settings, err := store.GetSettings(storage.DefaultSettingsID)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, reply(""))
+	require.Equal(t, settings.LLMName, used.LLMName)
+}
+
+// requestRecorder keeps the last request it was asked to complete.
+type requestRecorder struct {
+	got llm.Request
+}
+
+func (p *requestRecorder) Complete(ctx context.Context, req llm.Request) (llm.Response, error) {
+	p.got = req
+	return llm.Response{Content: "ok"}, nil
+}
+
+func TestThreadReplySettings(t *testing.T) {
+	store := memory.New()
+	fake := &requestRecorder{}
+	var used models.Settings
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithLocalMode(), api.WithProviderFactory(
+		func(cfg models.Settings) (llm.Provider, error) {
+			used = cfg
+			return fake, nil
+		},
+	)))
+	defer srv.Close()
+
+	res := postJSON(t, srv.URL+"/api/threads", map[string]interface{}{"title": "hot", "temperature": 3})
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = postJSON(t, srv.URL+"/api/threads", map[string]interface{}{
+		"title": "planning", "model": "planner-1", "system_prompt": "Answer as a checklist.", "temperature": 0, "max_tokens": 256,
+	})
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var thread models.Thread
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&thread))
+	res.Body.Close()
+	require.NotNil(t, thread.Temperature)
+
+	root := "root"
+	require.NoError(t, store.CreateMessage(models.Message{ID: root, ThreadID: thread.ID, Role: "user", Content: "plan"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "a1", ThreadID: thread.ID, ParentID: &root, RootID: &root, Role: "assistant", Content: "steps"}))
+
+	// Replies carry the system prompt, tuning and model.
+	res = postJSON(t, srv.URL+"/api/messages/a1/reply", map[string]string{"content": "more"})
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	require.Equal(t, "planner-1", used.LLMName)
+	require.Equal(t, llm.Message{Role: "system", Content: "Answer as a checklist."}, fake.got.Messages[0])
+	require.Len(t, fake.got.Messages, 4)
+	require.Equal(t, 0.0, *fake.got.Temperature)
+	require.Equal(t, 256, fake.got.MaxTokens)
+
+	// A branch keeps the settings.
+	res, err := http.Post(srv.URL+"/api/move/a1", "application/json", nil)
+	require.NoError(t, err)
+	var moved map[string]string
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&moved))
+	res.Body.Close()
+	branch, err := store.GetThread(moved["thread_id"])
+	require.NoError(t, err)
+	require.Equal(t, "planner-1", branch.Model)
+	require.Equal(t, "Answer as a checklist.", branch.SystemPrompt)
+	require.Equal(t, 0.0, *branch.Temperature)
+	require.Equal(t, 256, branch.MaxTokens)
+
+	// PATCH changes only the settings it names; null clears the temperature.
+	req, err := http.NewRequest(http.MethodPatch, srv.URL+"/api/threads/"+thread.ID,
+		strings.NewReader(`{"title":"planning","system_prompt":"","temperature":null}`))
+	require.NoError(t, err)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	var updated models.Thread
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&updated))
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "planner-1", updated.Model)
+	require.Empty(t, updated.SystemPrompt)
+	require.Nil(t, updated.Temperature)
+	require.Equal(t, 256, updated.MaxTokens)
+
+	res = postJSON(t, srv.URL+"/api/messages/"+root+"/reply", nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	require.Equal(t, []llm.Message{{Role: "user", Content: "plan"}}, fake.got.Messages)
+	require.Nil(t, fake.got.Temperature)
+}
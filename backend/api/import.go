@@ -0,0 +1,49 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/krackenservices/threadwell/importer"
+)
+
+// maxImportBytes bounds the request body of /api/import. ChatGPT exports of
+// long-lived accounts run to tens of megabytes.
+const maxImportBytes = 256 << 20
+
+// importHandler creates threads from exported conversations
+// @Summary Import conversations
+// @Description Accepts a ChatGPT conversations.json, an OpenAI messages array
+// @Description (or {"messages": [...]}), or a ThreadWell JSON export. Several
+// @Description JSON values may be concatenated, one per line. Each conversation
+// @Description becomes a new thread; one that fails is reported in results and
+// @Description the rest are still imported.
+// @Tags import
+// @Accept json
+// @Produce json
+// @Success 200 {object} importer.Report
+// @Failure 400 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Router /api/import [post]
+func (h *Handler) importHandler(w http.ResponseWriter, r *http.Request) {
+	store := h.store(r)
+	if r.Method != http.MethodPost {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	report, err := importer.Import(store, http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			WriteError(w, http.StatusRequestEntityTooLarge, "import is too large")
+		case errors.Is(err, importer.ErrInvalidInput):
+			WriteError(w, http.StatusBadRequest, err.Error())
+		default:
+			WriteError(w, http.StatusInternalServerError, "import failed")
+		}
+		return
+	}
+	WriteJSON(w, http.StatusOK, report)
+}
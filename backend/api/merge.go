@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/krackenservices/threadwell/storage"
+)
+
+type mergeRequest struct {
+	SourceThreadID string  `json:"source_thread_id"`
+	TargetParentID *string `json:"target_parent_id,omitempty"`
+	DeleteSource   bool    `json:"delete_source"`
+}
+
+// mergeHandler merges another thread's messages into this one
+// @Summary Merge a thread into this thread
+// @Description Source messages attach under target_parent_id, or as new roots.
+// @Description Messages that repeat the target at the same position (such as
+// @Description the ancestor copies left by a move) are mapped onto the existing
+// @Description ones instead of being duplicated.
+// @Tags threads
+// @Accept json
+// @Produce json
+// @Param id path string true "Target thread ID"
+// @Param body body mergeRequest true "Source thread and attach point"
+// @Success 200 {object} storage.MergeResult
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/threads/{id}/merge [post]
+func (h *Handler) mergeHandler(w http.ResponseWriter, r *http.Request, id string) {
+	store := h.store(r)
+	if r.Method != http.MethodPost {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req mergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.SourceThreadID == "" {
+		WriteError(w, http.StatusBadRequest, "source_thread_id is required")
+		return
+	}
+
+	res, err := store.MergeThreads(id, req.SourceThreadID, req.TargetParentID, req.DeleteSource)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			WriteError(w, http.StatusNotFound, "thread not found")
+		case errors.Is(err, storage.ErrInvalidReference):
+			WriteError(w, http.StatusBadRequest, err.Error())
+		default:
+			WriteError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	WriteJSON(w, http.StatusOK, res)
+}
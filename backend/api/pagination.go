@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// NextCursorHeader carries the cursor for the following page. List bodies
+// stay plain JSON arrays so unpaginated clients keep working.
+const NextCursorHeader = "X-Next-Cursor"
+
+// pageParams reads the limit, cursor, sort and order query parameters
+// shared by the list endpoints.
+type pageParams struct {
+	Limit  int
+	Cursor string
+	SortBy string
+	Order  string
+}
+
+func parsePageParams(r *http.Request) (pageParams, error) {
+	q := r.URL.Query()
+	p := pageParams{
+		Cursor: q.Get("cursor"),
+		SortBy: q.Get("sort"),
+		Order:  q.Get("order"),
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("limit must be a positive integer")
+		}
+		p.Limit = n
+	}
+	return p, nil
+}
+
+// parseUnix reads an optional unix-seconds query parameter.
+func parseUnix(r *http.Request, name string) (int64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a unix timestamp", name)
+	}
+	return n, nil
+}
+
+func setNextCursor(w http.ResponseWriter, cursor string) {
+	if cursor != "" {
+		w.Header().Set(NextCursorHeader, cursor)
+	}
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/krackenservices/threadwell/llm"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+// maxProviderName bounds the label given to a provider profile.
+const maxProviderName = 100
+
+// providerResponse is a provider profile as the API returns it. Like
+// settings, the API key is replaced by HasAPIKey and APIKeyHint.
+type providerResponse struct {
+	models.ProviderProfile
+	HasAPIKey  bool   `json:"has_api_key"`
+	APIKeyHint string `json:"llm_api_key_hint,omitempty"`
+}
+
+// providerPayload is the POST and PUT body for provider profiles. On PUT an
+// empty llm_api_key keeps the stored key; clear_llm_api_key removes it.
+type providerPayload struct {
+	models.ProviderProfile
+	ClearAPIKey bool `json:"clear_llm_api_key"`
+}
+
+func newProviderResponse(p models.ProviderProfile) providerResponse {
+	out := providerResponse{ProviderProfile: p, HasAPIKey: p.LLMApiKey != ""}
+	if out.HasAPIKey {
+		out.APIKeyHint = maskKey(p.LLMApiKey)
+	}
+	out.LLMApiKey = ""
+	return out
+}
+
+// validate trims the payload and reports what is wrong with it, if anything.
+func (p *providerPayload) validate() string {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" || len(p.Name) > maxProviderName {
+		return "name must be 1 to 100 characters"
+	}
+	if !llm.Supported(p.LLMProvider) {
+		return "unsupported llm_provider: " + p.LLMProvider
+	}
+	return ""
+}
+
+// providersHandler lists and creates provider profiles
+// @Summary List or create LLM provider profiles
+// @Description Profiles are listed oldest first. The first profile created
+// @Description becomes the default; marking another as default moves it.
+// @Description Replies use the message's thread profile, else the default
+// @Description profile, else /api/settings.
+// @Tags providers
+// @Accept json
+// @Produce json
+// @Param body body providerPayload false "New profile (POST)"
+// @Success 200 {array} providerResponse
+// @Success 201 {object} providerResponse
+// @Failure 400 {object} map[string]string
+// @Router /api/providers [get]
+// @Router /api/providers [post]
+func (h *Handler) providersHandler(w http.ResponseWriter, r *http.Request) {
+	store := h.store(r)
+	owner := ""
+	if u := CurrentUser(r); u != nil {
+		owner = u.ID
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		profiles, err := store.ListProviderProfiles(owner)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, "failed to list provider profiles")
+			return
+		}
+		out := make([]providerResponse, len(profiles))
+		for i, p := range profiles {
+			out[i] = newProviderResponse(p)
+		}
+		WriteJSON(w, http.StatusOK, out)
+
+	case http.MethodPost:
+		var payload providerPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if msg := payload.validate(); msg != "" {
+			WriteError(w, http.StatusBadRequest, msg)
+			return
+		}
+		existing, err := store.ListProviderProfiles(owner)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, "failed to list provider profiles")
+			return
+		}
+		p := payload.ProviderProfile
+		p.ID = uuid.NewString()
+		p.OwnerID = owner
+		p.IsDefault = p.IsDefault || len(existing) == 0
+		p.CreatedAt = UnixNow()
+		p.UpdatedAt = p.CreatedAt
+		if err := store.CreateProviderProfile(p); err != nil {
+			WriteError(w, http.StatusInternalServerError, "failed to save provider profile")
+			return
+		}
+		WriteJSON(w, http.StatusCreated, newProviderResponse(p))
+
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// providerIDHandler reads, replaces or deletes one provider profile
+// @Summary Get, update or delete an LLM provider profile
+// @Description Deleting a profile resets threads that use it to the default.
+// @Tags providers
+// @Accept json
+// @Produce json
+// @Param id path string true "Profile ID"
+// @Param body body providerPayload false "Updated profile (PUT)"
+// @Success 200 {object} providerResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/providers/{id} [get]
+// @Router /api/providers/{id} [put]
+// @Router /api/providers/{id} [delete]
+func (h *Handler) providerIDHandler(w http.ResponseWriter, r *http.Request) {
+	store := h.store(r)
+	id := strings.TrimPrefix(r.URL.Path, "/api/providers/")
+
+	current, err := store.GetProviderProfile(id)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to load provider profile")
+		return
+	}
+	if current == nil {
+		WriteError(w, http.StatusNotFound, "provider profile not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		WriteJSON(w, http.StatusOK, newProviderResponse(*current))
+
+	case http.MethodPut:
+		var payload providerPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if msg := payload.validate(); msg != "" {
+			WriteError(w, http.StatusBadRequest, msg)
+			return
+		}
+		p := payload.ProviderProfile
+		p.ID = current.ID
+		p.OwnerID = current.OwnerID
+		p.CreatedAt = current.CreatedAt
+		p.UpdatedAt = UnixNow()
+		if p.LLMApiKey == "" && !payload.ClearAPIKey {
+			p.LLMApiKey = current.LLMApiKey
+		}
+		if err := store.UpdateProviderProfile(p); err != nil {
+			WriteError(w, http.StatusInternalServerError, "failed to update provider profile")
+			return
+		}
+		WriteJSON(w, http.StatusOK, newProviderResponse(p))
+
+	case http.MethodDelete:
+		err := store.DeleteProviderProfile(id)
+		if errors.Is(err, storage.ErrNotFound) {
+			WriteError(w, http.StatusNotFound, "provider profile not found")
+			return
+		}
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, "failed to delete provider profile")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]string{"deleted": id})
+
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// checkProviderRef reports whether id, if set, names a profile the caller
+// can use.
+func checkProviderRef(store storage.Storage, id string) (bool, error) {
+	if id == "" {
+		return true, nil
+	}
+	p, err := store.GetProviderProfile(id)
+	return p != nil, err
+}
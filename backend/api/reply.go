@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/krackenservices/threadwell/llm"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+var (
+	errMessageNotFound  = errors.New("message not found")
+	errProviderNotFound = errors.New("provider profile not found")
+)
+
+// replyRequest optionally carries a new user message to post under {id}
+// before replying. With no content the reply answers {id} itself.
+// ProviderID picks a provider profile for this reply only.
+type replyRequest struct {
+	Content    string `json:"content"`
+	Role       string `json:"role"`
+	ProviderID string `json:"provider_id"`
+}
+
+type replyResponse struct {
+	User      models.Message `json:"user"`
+	Assistant models.Message `json:"assistant"`
+}
+
+// ancestorChain returns the root-to-node path ending at id.
+func (h *Handler) ancestorChain(store storage.Storage, id string) ([]models.Message, error) {
+	msg, err := store.GetMessage(id)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, errMessageNotFound
+	}
+	chain, err := store.Ancestors(id)
+	if err != nil {
+		return nil, err
+	}
+	return append(chain, *msg), nil
+}
+
+// newChild builds a message that replies to parent.
+func newChild(parent models.Message, role, content string) models.Message {
+	rootID := parent.RootID
+	if rootID == nil {
+		rootID = &parent.ID
+	}
+	return models.Message{
+		ID:        "gen-" + RandID(),
+		ThreadID:  parent.ThreadID,
+		ParentID:  &parent.ID,
+		RootID:    rootID,
+		Role:      role,
+		Content:   content,
+		Timestamp: UnixNow(),
+	}
+}
+
+// provider resolves the LLM provider for a reply to message id, and a
+// request carrying the thread's system prompt and tuning for the chain to be
+// appended to. The first of these wins: the requested profile, the thread's
+// profile, the owner's default profile, the stored settings. The thread's
+// model applies unless the reply asks for another profile. SimulateOnly in
+// the settings still overrides every profile.
+func (h *Handler) provider(store storage.Storage, id, profileID string) (llm.Provider, llm.Request, error) {
+	var req llm.Request
+	cfg, err := store.GetSettings(storage.DefaultSettingsID)
+	if err != nil {
+		return nil, req, err
+	}
+	msg, err := store.GetMessage(id)
+	if err != nil {
+		return nil, req, err
+	}
+	if msg == nil {
+		return nil, req, errMessageNotFound
+	}
+	thread, err := store.GetThread(msg.ThreadID)
+	if err != nil {
+		return nil, req, err
+	}
+	if thread == nil {
+		thread = &models.Thread{ID: msg.ThreadID}
+	}
+
+	profile, err := h.profile(store, thread, profileID)
+	if err != nil {
+		return nil, req, err
+	}
+	if profile != nil {
+		cfg.LLMProvider = profile.LLMProvider
+		cfg.LLMEndpoint = profile.LLMEndpoint
+		cfg.LLMApiKey = profile.LLMApiKey
+		cfg.LLMName = profile.LLMName
+	}
+	if thread.Model != "" && profileID == "" {
+		cfg.LLMName = thread.Model
+	}
+	if thread.SystemPrompt != "" {
+		req.Messages = []llm.Message{{Role: "system", Content: thread.SystemPrompt}}
+	}
+	req.Temperature, req.MaxTokens = thread.Temperature, thread.MaxTokens
+
+	p, err := h.newProvider(*cfg)
+	return p, req, err
+}
+
+// profile returns the provider profile a reply in thread uses, or nil to
+// fall back to the settings.
+func (h *Handler) profile(store storage.Storage, thread *models.Thread, profileID string) (*models.ProviderProfile, error) {
+	if profileID != "" {
+		p, err := store.GetProviderProfile(profileID)
+		if err == nil && p == nil {
+			err = errProviderNotFound
+		}
+		return p, err
+	}
+	if thread.ProviderID != "" {
+		p, err := store.GetProviderProfile(thread.ProviderID)
+		if err != nil || p != nil {
+			return p, err
+		}
+	}
+	profiles, err := store.ListProviderProfiles(thread.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range profiles {
+		if profiles[i].IsDefault {
+			return &profiles[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// prepareReply resolves the prompt for a reply to id. When a user message
+// is supplied it ends the chain and is also returned as posted; it is not
+// stored yet, so a failed completion leaves the thread unchanged.
+func (h *Handler) prepareReply(store storage.Storage, id string, req replyRequest) ([]models.Message, *models.Message, error) {
+	chain, err := h.ancestorChain(store, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.Content == "" {
+		return chain, nil, nil
+	}
+
+	role := req.Role
+	if role == "" {
+		role = "user"
+	}
+	user := newChild(chain[len(chain)-1], role, req.Content)
+	return append(chain, user), &user, nil
+}
+
+// generateReply completes the ancestor chain and stores the answer as a child
+// of the last message in it. A posted user message is stored only once the
+// provider has answered.
+func (h *Handler) generateReply(ctx context.Context, store storage.Storage, id string, req replyRequest) (*replyResponse, error) {
+	p, prompt, err := h.provider(store, id, req.ProviderID)
+	if err != nil {
+		return nil, err
+	}
+	chain, posted, err := h.prepareReply(store, id, req)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt.Messages = append(prompt.Messages, llm.FromMessages(chain)...)
+	res, err := p.Complete(ctx, prompt)
+	if err != nil {
+		return nil, &providerError{err: err}
+	}
+
+	if posted != nil {
+		if err := store.CreateMessage(*posted); err != nil {
+			return nil, err
+		}
+	}
+	user := chain[len(chain)-1]
+	reply := newChild(user, "assistant", res.Content)
+	if err := store.CreateMessage(reply); err != nil {
+		return nil, err
+	}
+	return &replyResponse{User: user, Assistant: reply}, nil
+}
+
+// providerError marks failures that came from the upstream model.
+type providerError struct {
+	err error
+}
+
+func (e *providerError) Error() string { return e.err.Error() }
+func (e *providerError) Unwrap() error { return e.err }
+
+// replyHandler generates an assistant reply for a message
+// @Summary Generate an assistant reply from the message's ancestor chain
+// @Tags messages
+// @Accept json
+// @Produce json
+// @Param id path string true "Message ID to reply to"
+// @Param body body replyRequest false "Optional user message to post under id first, and provider profile"
+// @Success 201 {object} replyResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /api/messages/{id}/reply [post]
+func (h *Handler) replyHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req replyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	out, err := h.generateReply(r.Context(), h.store(r), id, req)
+	if err != nil {
+		var perr *providerError
+		switch {
+		case errors.Is(err, errMessageNotFound):
+			WriteError(w, http.StatusNotFound, "message not found")
+		case errors.Is(err, errProviderNotFound):
+			WriteError(w, http.StatusBadRequest, "provider profile not found")
+		case errors.As(err, &perr):
+			WriteError(w, http.StatusBadGateway, "llm request failed: "+perr.Error())
+		default:
+			WriteError(w, http.StatusInternalServerError, "failed to generate reply")
+		}
+		return
+	}
+	WriteJSON(w, http.StatusCreated, out)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/krackenservices/threadwell/storage"
+)
+
+// revisionsHandler lists a message's edit history
+// @Summary List previous versions of a message
+// @Tags messages
+// @Produce json
+// @Param id path string true "Message ID"
+// @Success 200 {array} models.MessageRevision
+// @Failure 404 {object} map[string]string
+// @Router /api/messages/{id}/revisions [get]
+func (h *Handler) revisionsHandler(w http.ResponseWriter, r *http.Request, id, rest string) {
+	store := h.store(r)
+	if rest != "" {
+		revID, action, _ := strings.Cut(rest, "/")
+		if action != "restore" || revID == "" {
+			WriteError(w, http.StatusNotFound, "not found")
+			return
+		}
+		h.restoreRevisionHandler(w, r, id, revID)
+		return
+	}
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	msg, err := store.GetMessage(id)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to load message")
+		return
+	}
+	if msg == nil {
+		WriteError(w, http.StatusNotFound, "message not found")
+		return
+	}
+	revs, err := store.ListMessageRevisions(id)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to fetch revisions")
+		return
+	}
+	WriteJSON(w, http.StatusOK, revs)
+}
+
+// restoreRevisionHandler brings back an earlier version of a message
+// @Summary Restore a previous version of a message
+// @Description The current text is kept as a new revision, so restores can be undone.
+// @Tags messages
+// @Produce json
+// @Param id path string true "Message ID"
+// @Param revisionId path string true "Revision ID"
+// @Success 200 {object} models.Message
+// @Failure 404 {object} map[string]string
+// @Router /api/messages/{id}/revisions/{revisionId}/restore [post]
+func (h *Handler) restoreRevisionHandler(w http.ResponseWriter, r *http.Request, id, revID string) {
+	store := h.store(r)
+	if r.Method != http.MethodPost {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	msg, err := store.GetMessage(id)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to load message")
+		return
+	}
+	if msg == nil {
+		WriteError(w, http.StatusNotFound, "message not found")
+		return
+	}
+	revs, err := store.ListMessageRevisions(id)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to fetch revisions")
+		return
+	}
+
+	found := false
+	for _, rev := range revs {
+		if rev.ID == revID {
+			msg.Role = rev.Role
+			msg.Content = rev.Content
+			found = true
+			break
+		}
+	}
+	if !found {
+		WriteError(w, http.StatusNotFound, "revision not found")
+		return
+	}
+
+	if err := store.UpdateMessage(*msg); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			WriteError(w, http.StatusNotFound, "message not found")
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, "failed to restore revision")
+		return
+	}
+	WriteJSON(w, http.StatusOK, msg)
+}
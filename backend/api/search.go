@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/krackenservices/threadwell/storage"
+)
+
+// maxSearchLimit bounds the limit query parameter of /api/search.
+const maxSearchLimit = 100
+
+// searchHandler runs a full-text search over messages and thread titles
+// @Summary Search messages and thread titles
+// @Description Every word and "quoted phrase" in q must match. Matches in the
+// @Description snippet are wrapped in **. Title matches have no message_id.
+// @Tags search
+// @Produce json
+// @Param q query string true "Search text"
+// @Param role query string false "Only messages with this role"
+// @Param thread_id query string false "Only this thread"
+// @Param limit query int false "Maximum results (default 20, max 100)"
+// @Success 200 {array} storage.SearchResult
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/search [get]
+func (h *Handler) searchHandler(w http.ResponseWriter, r *http.Request) {
+	store := h.store(r)
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	params := r.URL.Query()
+	q := storage.SearchQuery{
+		Text:     params.Get("q"),
+		Role:     params.Get("role"),
+		ThreadID: params.Get("thread_id"),
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			WriteError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+		q.Limit = n
+	}
+
+	results, err := store.Search(q)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidQuery) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, "search failed")
+		return
+	}
+	WriteJSON(w, http.StatusOK, results)
+}
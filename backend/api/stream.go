@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/krackenservices/threadwell/llm"
+)
+
+// writeEvent writes a single Server-Sent Event and flushes it to the client.
+func writeEvent(w http.ResponseWriter, f http.Flusher, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	f.Flush()
+	return nil
+}
+
+// streamHandler streams an assistant reply as Server-Sent Events
+// @Summary Stream an assistant reply from the message's ancestor chain
+// @Description Emits "user" with the message being answered, one "delta" per
+// @Description chunk, then "done" with the stored assistant message or "error".
+// @Description If the client disconnects, the partial reply is still stored.
+// @Description A posted user message is only stored along with a reply.
+// @Tags messages
+// @Produce text/event-stream
+// @Param id path string true "Message ID to reply to"
+// @Param content query string false "Optional user message to post under id first"
+// @Param provider_id query string false "Provider profile for this reply"
+// @Success 200 {string} string "event stream"
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/messages/{id}/stream [get]
+func (h *Handler) streamHandler(w http.ResponseWriter, r *http.Request, id string) {
+	store := h.store(r)
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	p, prompt, err := h.provider(store, id, r.URL.Query().Get("provider_id"))
+	switch {
+	case errors.Is(err, errMessageNotFound):
+		WriteError(w, http.StatusNotFound, "message not found")
+		return
+	case errors.Is(err, errProviderNotFound):
+		WriteError(w, http.StatusBadRequest, "provider profile not found")
+		return
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, "failed to load llm provider")
+		return
+	}
+	chain, posted, err := h.prepareReply(store, id, replyRequest{Content: r.URL.Query().Get("content")})
+	if err != nil {
+		if errors.Is(err, errMessageNotFound) {
+			WriteError(w, http.StatusNotFound, "message not found")
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, "failed to prepare reply")
+		return
+	}
+	user := chain[len(chain)-1]
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if err := writeEvent(w, flusher, "user", user); err != nil {
+		return
+	}
+
+	prompt.Messages = append(prompt.Messages, llm.FromMessages(chain)...)
+	res, streamErr := llm.Stream(r.Context(), p, prompt, func(delta string) error {
+		return writeEvent(w, flusher, "delta", map[string]string{"content": delta})
+	})
+
+	// Keep whatever was generated, even if the client went away mid-stream.
+	if streamErr == nil || res.Content != "" {
+		if posted != nil {
+			if err := store.CreateMessage(*posted); err != nil {
+				log.Printf("stream: failed to save message under %s: %v", id, err)
+				_ = writeEvent(w, flusher, "error", map[string]string{"error": "failed to save reply"})
+				return
+			}
+		}
+		reply := newChild(user, "assistant", res.Content)
+		if err := store.CreateMessage(reply); err != nil {
+			log.Printf("stream: failed to save reply to %s: %v", user.ID, err)
+			_ = writeEvent(w, flusher, "error", map[string]string{"error": "failed to save reply"})
+			return
+		}
+		if streamErr == nil {
+			_ = writeEvent(w, flusher, "done", reply)
+			return
+		}
+	}
+	if r.Context().Err() == nil {
+		_ = writeEvent(w, flusher, "error", map[string]string{"error": "llm request failed: " + streamErr.Error()})
+	}
+}
@@ -0,0 +1,166 @@
+package api_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krackenservices/threadwell/api"
+	"github.com/krackenservices/threadwell/llm"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+	"github.com/krackenservices/threadwell/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStreamer emits the given chunks, then blocks until the request is
+// cancelled when hang is set.
+type fakeStreamer struct {
+	chunks []string
+	hang   bool
+}
+
+func (f *fakeStreamer) Complete(ctx context.Context, req llm.Request) (llm.Response, error) {
+	return llm.Response{Content: strings.Join(f.chunks, "")}, nil
+}
+
+func (f *fakeStreamer) Stream(ctx context.Context, req llm.Request, onDelta llm.DeltaFunc) (llm.Response, error) {
+	var sb strings.Builder
+	for _, c := range f.chunks {
+		sb.WriteString(c)
+		if err := onDelta(c); err != nil {
+			return llm.Response{Content: sb.String()}, err
+		}
+	}
+	if f.hang {
+		<-ctx.Done()
+		return llm.Response{Content: sb.String()}, ctx.Err()
+	}
+	return llm.Response{Content: sb.String()}, nil
+}
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+func readEvents(t *testing.T, sc *bufio.Scanner, n int) []sseEvent {
+	var events []sseEvent
+	var cur sseEvent
+	for len(events) < n && sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			cur.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			cur.data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			events = append(events, cur)
+			cur = sseEvent{}
+		}
+	}
+	require.NoError(t, sc.Err())
+	return events
+}
+
+func newStreamServer(t *testing.T, p llm.Provider) (*httptest.Server, storage.Storage) {
+	store := memory.New()
+	srv := httptest.NewServer(api.RegisterRoutes(store, api.WithLocalMode(), api.WithProviderFactory(
+		func(models.Settings) (llm.Provider, error) { return p, nil },
+	)))
+	require.NoError(t, store.CreateThread(models.Thread{ID: "t1", Title: "stream"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "m1", ThreadID: "t1", Role: "user", Content: "hi"}))
+	return srv, store
+}
+
+func assistantChildren(t *testing.T, store storage.Storage, parentID string) []models.Message {
+	msgs, err := store.ListMessages("t1")
+	require.NoError(t, err)
+	var out []models.Message
+	for _, m := range msgs {
+		if m.ParentID != nil && *m.ParentID == parentID && m.Role == "assistant" {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func TestStreamEmitsDeltasAndPersists(t *testing.T) {
+	srv, store := newStreamServer(t, &fakeStreamer{chunks: []string{"Hel", "lo", "!"}})
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/api/messages/m1/stream")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	events := readEvents(t, bufio.NewScanner(res.Body), 5)
+	require.Len(t, events, 5)
+	require.Equal(t, "user", events[0].name)
+	require.Equal(t, []string{"delta", "delta", "delta"}, []string{events[1].name, events[2].name, events[3].name})
+	require.JSONEq(t, `{"content":"lo"}`, events[2].data)
+	require.Equal(t, "done", events[4].name)
+
+	var done models.Message
+	require.NoError(t, json.Unmarshal([]byte(events[4].data), &done))
+	require.Equal(t, "Hello!", done.Content)
+
+	saved := assistantChildren(t, store, "m1")
+	require.Len(t, saved, 1)
+	require.Equal(t, "Hello!", saved[0].Content)
+}
+
+func TestStreamPostsUserMessage(t *testing.T) {
+	srv, store := newStreamServer(t, &fakeStreamer{chunks: []string{"ok"}})
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/api/messages/m1/stream?content=again")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	events := readEvents(t, bufio.NewScanner(res.Body), 3)
+	require.Len(t, events, 3)
+
+	var user models.Message
+	require.NoError(t, json.Unmarshal([]byte(events[0].data), &user))
+	require.Equal(t, "again", user.Content)
+	require.Equal(t, "m1", *user.ParentID)
+	require.Len(t, assistantChildren(t, store, user.ID), 1)
+}
+
+func TestStreamSavesPartialOnDisconnect(t *testing.T) {
+	srv, store := newStreamServer(t, &fakeStreamer{chunks: []string{"partial ", "answer"}, hang: true})
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/messages/m1/stream", nil)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	events := readEvents(t, bufio.NewScanner(res.Body), 3)
+	require.Len(t, events, 3)
+	cancel()
+	_ = res.Body.Close()
+
+	require.Eventually(t, func() bool {
+		saved := assistantChildren(t, store, "m1")
+		return len(saved) == 1 && saved[0].Content == "partial answer"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestStreamUnknownMessage(t *testing.T) {
+	srv, _ := newStreamServer(t, &fakeStreamer{})
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/api/messages/missing/stream")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
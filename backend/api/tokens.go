@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/krackenservices/threadwell/auth"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+// maxTokenName bounds the label given to an access token.
+const maxTokenName = 100
+
+// createTokenRequest is the POST body for /api/tokens. ExpiresInDays 0
+// means the token never expires.
+type createTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// createdToken is an access token record plus the token itself, which is
+// only ever shown in this response.
+type createdToken struct {
+	models.AccessToken
+	Token string `json:"token"`
+}
+
+// publicToken strips the token hash.
+func publicToken(t models.AccessToken) models.AccessToken {
+	t.TokenHash = ""
+	return t
+}
+
+// tokensHandler lists and creates personal access tokens
+// @Summary List or create access tokens
+// @Description Tokens carry scopes (threads:read, messages:write,
+// @Description settings:admin) and are sent as `Authorization: Bearer <token>`.
+// @Description Managing tokens needs a login session.
+// @Tags tokens
+// @Accept json
+// @Produce json
+// @Param body body createTokenRequest false "Name, scopes and lifetime (POST)"
+// @Success 200 {array} models.AccessToken
+// @Success 201 {object} createdToken
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/tokens [get]
+// @Router /api/tokens [post]
+func (h *Handler) tokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+	if user == nil {
+		WriteError(w, http.StatusNotFound, "accounts are disabled in local mode")
+		return
+	}
+	store := h.store(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := store.ListAccessTokens(user.ID)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, "failed to list tokens")
+			return
+		}
+		for i := range tokens {
+			tokens[i] = publicToken(tokens[i])
+		}
+		WriteJSON(w, http.StatusOK, tokens)
+
+	case http.MethodPost:
+		var req createTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len(req.Name) > maxTokenName {
+			WriteError(w, http.StatusBadRequest, "name must be 1 to 100 characters")
+			return
+		}
+		if req.ExpiresInDays < 0 {
+			WriteError(w, http.StatusBadRequest, "expires_in_days must not be negative")
+			return
+		}
+		ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+		token, rec, err := auth.CreateAccessToken(store, user.ID, req.Name, req.Scopes, ttl)
+		if errors.Is(err, auth.ErrInvalidScope) {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, "failed to create token")
+			return
+		}
+		WriteJSON(w, http.StatusCreated, createdToken{AccessToken: publicToken(*rec), Token: token})
+
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// tokenIDHandler revokes an access token
+// @Summary Revoke an access token
+// @Tags tokens
+// @Param id path string true "Token ID"
+// @Success 204
+// @Failure 404 {object} map[string]string
+// @Router /api/tokens/{id} [delete]
+func (h *Handler) tokenIDHandler(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+	if user == nil {
+		WriteError(w, http.StatusNotFound, "accounts are disabled in local mode")
+		return
+	}
+	if r.Method != http.MethodDelete {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/tokens/")
+	err := h.store(r).DeleteAccessToken(user.ID, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		WriteError(w, http.StatusNotFound, "token not found")
+		return
+	}
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to revoke token")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/krackenservices/threadwell/storage"
+)
+
+// treeHandler returns a thread's messages nested under their parents
+// @Summary Get a thread as a message tree
+// @Description Roots and each node's children are ordered by timestamp, then ID.
+// @Tags threads
+// @Produce json
+// @Param id path string true "Thread ID"
+// @Success 200 {array} storage.TreeNode
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/threads/{id}/tree [get]
+func (h *Handler) treeHandler(w http.ResponseWriter, r *http.Request, id string) {
+	store := h.store(r)
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	thread, err := store.GetThread(id)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to fetch thread")
+		return
+	}
+	if thread == nil {
+		WriteError(w, http.StatusNotFound, "thread not found")
+		return
+	}
+	msgs, err := store.ListMessages(id)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to fetch messages")
+		return
+	}
+	WriteJSON(w, http.StatusOK, storage.BuildTree(msgs))
+}
+
+// pathHandler returns the ancestor chain of a message
+// @Summary Get the root-to-message path
+// @Tags messages
+// @Produce json
+// @Param id path string true "Message ID"
+// @Success 200 {array} models.Message
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/messages/{id}/path [get]
+func (h *Handler) pathHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	chain, err := h.ancestorChain(h.store(r), id)
+	if err != nil {
+		if errors.Is(err, errMessageNotFound) {
+			WriteError(w, http.StatusNotFound, "message not found")
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	WriteJSON(w, http.StatusOK, chain)
+}
@@ -0,0 +1,201 @@
+// Package auth creates user accounts, checks passwords and issues the
+// bearer tokens that identify a user to the API.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password length limits. bcrypt reads at most 72 bytes.
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+// ErrInvalidCredentials is returned by Login for an unknown user or a wrong
+// password; the two are not told apart.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// ErrInvalidAccount is returned by CreateUser for a malformed username or a
+// password that is too short or too long.
+var ErrInvalidAccount = errors.New("invalid account")
+
+// Access token scopes. A token may only call the endpoints its scopes cover;
+// login sessions may call all of them.
+const (
+	ScopeThreadsRead   = "threads:read"   // read threads, messages, search and exports
+	ScopeMessagesWrite = "messages:write" // create, change and delete threads and messages
+	ScopeSettingsAdmin = "settings:admin" // settings and workspace backups
+)
+
+// Scopes lists every scope a token can carry.
+var Scopes = []string{ScopeThreadsRead, ScopeMessagesWrite, ScopeSettingsAdmin}
+
+// AccessTokenPrefix starts every personal access token, which tells them
+// apart from session tokens.
+const AccessTokenPrefix = "twp_"
+
+// ErrInvalidScope is returned by CreateAccessToken for a token without
+// scopes or with an unknown one.
+var ErrInvalidScope = errors.New("invalid scope")
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{2,63}$`)
+
+// CreateUser validates the username and password and stores a new account.
+// Returns storage.ErrConflict if the username is taken.
+func CreateUser(store storage.Storage, username, password string) (*models.User, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, fmt.Errorf("%w: username must be 3-64 letters, digits, '.', '_' or '-'", ErrInvalidAccount)
+	}
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return nil, fmt.Errorf("%w: password must be %d to %d bytes", ErrInvalidAccount, MinPasswordLength, MaxPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	u := models.User{
+		ID:           uuid.NewString(),
+		Username:     username,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now().Unix(),
+	}
+	if err := store.CreateUser(u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// Login checks a username and password and starts a session lasting ttl
+// (forever when ttl is 0). It returns the bearer token, which is not stored
+// anywhere, and the session.
+func Login(store storage.Storage, username, password string, ttl time.Duration) (string, *models.Session, *models.User, error) {
+	u, err := store.GetUserByUsername(username)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if u == nil {
+		// Spend the same time as a real check so timing does not reveal
+		// which usernames exist.
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", nil, nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return "", nil, nil, ErrInvalidCredentials
+	}
+
+	token, err := NewToken()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	now := time.Now()
+	sess := models.Session{ID: HashToken(token), UserID: u.ID, CreatedAt: now.Unix()}
+	if ttl > 0 {
+		sess.ExpiresAt = now.Add(ttl).Unix()
+	}
+	if err := store.CreateSession(sess); err != nil {
+		return "", nil, nil, err
+	}
+	return token, &sess, u, nil
+}
+
+// Authenticate returns the user a bearer token belongs to, or nil for an
+// unknown or expired token. Expired sessions are removed.
+func Authenticate(store storage.Storage, token string) (*models.User, error) {
+	sess, err := store.GetSession(HashToken(token))
+	if err != nil || sess == nil {
+		return nil, err
+	}
+	if sess.ExpiresAt != 0 && time.Now().Unix() >= sess.ExpiresAt {
+		return nil, store.DeleteSession(sess.ID)
+	}
+	return store.GetUser(sess.UserID)
+}
+
+// CreateAccessToken issues a personal access token for userID limited to
+// scopes and lasting ttl (forever when ttl is 0). It returns the token,
+// which is shown once and not stored, and its record.
+func CreateAccessToken(store storage.Storage, userID, name string, scopes []string, ttl time.Duration) (string, *models.AccessToken, error) {
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	var clean []string
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return "", nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+		if !slices.Contains(clean, scope) {
+			clean = append(clean, scope)
+		}
+	}
+
+	secret, err := NewToken()
+	if err != nil {
+		return "", nil, err
+	}
+	token := AccessTokenPrefix + secret
+	now := time.Now()
+	t := models.AccessToken{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Name:      name,
+		TokenHash: HashToken(token),
+		Scopes:    clean,
+		CreatedAt: now.Unix(),
+	}
+	if ttl > 0 {
+		t.ExpiresAt = now.Add(ttl).Unix()
+	}
+	if err := store.CreateAccessToken(t); err != nil {
+		return "", nil, err
+	}
+	return token, &t, nil
+}
+
+// AuthenticateAccessToken returns the user a personal access token belongs
+// to and its record, or nils for an unknown or expired token.
+func AuthenticateAccessToken(store storage.Storage, token string) (*models.User, *models.AccessToken, error) {
+	t, err := store.GetAccessToken(HashToken(token))
+	if err != nil || t == nil {
+		return nil, nil, err
+	}
+	if t.ExpiresAt != 0 && time.Now().Unix() >= t.ExpiresAt {
+		return nil, nil, nil
+	}
+	u, err := store.GetUser(t.UserID)
+	if err != nil || u == nil {
+		return nil, nil, err
+	}
+	return u, t, nil
+}
+
+// NewToken returns a random bearer token.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken is the form a token is stored and looked up in. Tokens are long
+// and random, so a fast hash is enough.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("threadwell"), bcrypt.DefaultCost)
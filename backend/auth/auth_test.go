@@ -0,0 +1,95 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krackenservices/threadwell/auth"
+	"github.com/krackenservices/threadwell/storage"
+	"github.com/krackenservices/threadwell/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateUserAndLogin(t *testing.T) {
+	store := memory.New()
+	u, err := auth.CreateUser(store, "alice", "correct horse")
+	require.NoError(t, err)
+	require.NotEqual(t, "correct horse", u.PasswordHash)
+
+	_, err = auth.CreateUser(store, "alice", "another password")
+	require.ErrorIs(t, err, storage.ErrConflict)
+	_, err = auth.CreateUser(store, "a", "long enough")
+	require.ErrorIs(t, err, auth.ErrInvalidAccount)
+	_, err = auth.CreateUser(store, "bob", "short")
+	require.ErrorIs(t, err, auth.ErrInvalidAccount)
+
+	_, _, _, err = auth.Login(store, "alice", "wrong password", 0)
+	require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	_, _, _, err = auth.Login(store, "nobody", "correct horse", 0)
+	require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	token, sess, user, err := auth.Login(store, "alice", "correct horse", time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, u.ID, user.ID)
+	require.Equal(t, auth.HashToken(token), sess.ID, "only the hash is stored")
+
+	got, err := auth.Authenticate(store, token)
+	require.NoError(t, err)
+	require.Equal(t, u.ID, got.ID)
+
+	got, err = auth.Authenticate(store, token+"x")
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestAuthenticateDropsExpiredSessions(t *testing.T) {
+	store := memory.New()
+	_, err := auth.CreateUser(store, "alice", "correct horse")
+	require.NoError(t, err)
+	token, sess, _, err := auth.Login(store, "alice", "correct horse", time.Hour)
+	require.NoError(t, err)
+
+	sess.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	require.NoError(t, store.CreateSession(*sess))
+	got, err := auth.Authenticate(store, token)
+	require.NoError(t, err)
+	require.Nil(t, got)
+	stored, err := store.GetSession(sess.ID)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+}
+
+func TestAccessTokens(t *testing.T) {
+	store := memory.New()
+	u, err := auth.CreateUser(store, "alice", "correct horse")
+	require.NoError(t, err)
+
+	_, _, err = auth.CreateAccessToken(store, u.ID, "ci", nil, 0)
+	require.ErrorIs(t, err, auth.ErrInvalidScope)
+	_, _, err = auth.CreateAccessToken(store, u.ID, "ci", []string{"threads:delete"}, 0)
+	require.ErrorIs(t, err, auth.ErrInvalidScope)
+
+	token, rec, err := auth.CreateAccessToken(store, u.ID, "ci", []string{auth.ScopeThreadsRead, auth.ScopeThreadsRead}, 0)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(token, auth.AccessTokenPrefix))
+	require.Equal(t, auth.HashToken(token), rec.TokenHash, "only the hash is stored")
+	require.Equal(t, []string{auth.ScopeThreadsRead}, rec.Scopes)
+	require.Zero(t, rec.ExpiresAt)
+
+	user, got, err := auth.AuthenticateAccessToken(store, token)
+	require.NoError(t, err)
+	require.Equal(t, u.ID, user.ID)
+	require.Equal(t, rec.ID, got.ID)
+
+	user, _, err = auth.AuthenticateAccessToken(store, token+"x")
+	require.NoError(t, err)
+	require.Nil(t, user)
+
+	expired := *rec
+	expired.ID, expired.TokenHash, expired.ExpiresAt = "old", auth.HashToken("twp_old"), 1
+	require.NoError(t, store.CreateAccessToken(expired))
+	user, _, err = auth.AuthenticateAccessToken(store, "twp_old")
+	require.NoError(t, err)
+	require.Nil(t, user)
+}
@@ -0,0 +1,283 @@
+// Package backup writes every thread, message and setting of a store to a
+// single archive and restores it into any storage.Storage.
+//
+// An archive is gzip-compressed JSON Lines. The first line is a Header; each
+// following line is a Record holding one user, provider profile, thread,
+// message or settings value. Users come first, then provider profiles so
+// threads can name them, a thread's record precedes its messages, and
+// messages are written parents first, so a restore can insert them in file
+// order. Login sessions and access tokens are not archived; they are issued
+// again after a restore.
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/krackenservices/threadwell/export"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+// Format and Version identify a backup archive.
+const (
+	Format  = "threadwell-backup"
+	Version = 1
+)
+
+// Record kinds.
+const (
+	KindUser     = "user"
+	KindProvider = "provider"
+	KindThread   = "thread"
+	KindMessage  = "message"
+	KindSettings = "settings"
+)
+
+// ErrInvalidArchive is returned by Restore for input that is not a backup
+// archive, or one written by a newer version.
+var ErrInvalidArchive = errors.New("invalid backup archive")
+
+// ErrNotEmpty is returned by Restore when a thread, user or provider profile
+// in the archive already exists in the target store.
+var ErrNotEmpty = errors.New("record already exists in target store")
+
+// Header is the first line of an archive.
+type Header struct {
+	Format    string `json:"format"`
+	Version   int    `json:"version"`
+	CreatedAt int64  `json:"created_at"`
+	// Secrets reports whether settings and provider profiles include the
+	// LLM API key.
+	Secrets bool `json:"secrets"`
+}
+
+// Record is one line after the header. Exactly one of the value fields is
+// set, matching Kind.
+type Record struct {
+	Kind     string                  `json:"kind"`
+	User     *models.User            `json:"user,omitempty"`
+	Provider *models.ProviderProfile `json:"provider,omitempty"`
+	Thread   *models.Thread          `json:"thread,omitempty"`
+	Message  *models.Message         `json:"message,omitempty"`
+	Settings *models.Settings        `json:"settings,omitempty"`
+}
+
+// Options controls what a backup contains.
+type Options struct {
+	// IncludeSecrets keeps the LLM API keys in the archived settings and
+	// provider profiles. They are left out by default so archives can be
+	// shared safely.
+	IncludeSecrets bool
+	// Owner limits the archive to one user's threads, provider profiles and
+	// settings. Empty
+	// archives the whole store, including user accounts and their password
+	// hashes.
+	Owner string
+}
+
+// Summary counts what a backup or restore processed.
+type Summary struct {
+	Users     int  `json:"users,omitempty"`
+	Providers int  `json:"providers,omitempty"`
+	Threads   int  `json:"threads"`
+	Messages  int  `json:"messages"`
+	Settings  bool `json:"settings"`
+}
+
+// Write streams an archive of store to w.
+func Write(w io.Writer, store storage.Storage, opts Options) (*Summary, error) {
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	sum := &Summary{}
+
+	if err := enc.Encode(Header{
+		Format:    Format,
+		Version:   Version,
+		CreatedAt: time.Now().Unix(),
+		Secrets:   opts.IncludeSecrets,
+	}); err != nil {
+		return nil, err
+	}
+
+	settingsIDs := []string{storage.DefaultSettingsID}
+	owners := []string{""}
+	if opts.Owner != "" {
+		store = storage.ForUser(store, opts.Owner)
+		settingsIDs = []string{opts.Owner}
+		owners = settingsIDs
+	} else {
+		users, err := store.ListUsers()
+		if err != nil {
+			return nil, fmt.Errorf("list users: %w", err)
+		}
+		for i := range users {
+			if err := enc.Encode(Record{Kind: KindUser, User: &users[i]}); err != nil {
+				return nil, err
+			}
+			sum.Users++
+			settingsIDs = append(settingsIDs, users[i].ID)
+			owners = append(owners, users[i].ID)
+		}
+	}
+
+	for _, owner := range owners {
+		profiles, err := store.ListProviderProfiles(owner)
+		if err != nil {
+			return nil, fmt.Errorf("list provider profiles: %w", err)
+		}
+		for i := range profiles {
+			if !opts.IncludeSecrets {
+				profiles[i].LLMApiKey = ""
+			}
+			if err := enc.Encode(Record{Kind: KindProvider, Provider: &profiles[i]}); err != nil {
+				return nil, err
+			}
+			sum.Providers++
+		}
+	}
+
+	threads, err := store.ListThreads()
+	if err != nil {
+		return nil, fmt.Errorf("list threads: %w", err)
+	}
+	for i := range threads {
+		if err := enc.Encode(Record{Kind: KindThread, Thread: &threads[i]}); err != nil {
+			return nil, err
+		}
+		sum.Threads++
+
+		msgs, err := store.ListMessages(threads[i].ID)
+		if err != nil {
+			return nil, fmt.Errorf("list messages of %s: %w", threads[i].ID, err)
+		}
+		for _, m := range export.Flatten(storage.BuildTree(msgs)) {
+			if err := enc.Encode(Record{Kind: KindMessage, Message: &m}); err != nil {
+				return nil, err
+			}
+			sum.Messages++
+		}
+	}
+
+	for _, id := range settingsIDs {
+		settings, err := store.GetSettings(id)
+		if err != nil {
+			return nil, fmt.Errorf("load settings: %w", err)
+		}
+		s := *settings
+		if !opts.IncludeSecrets {
+			s.LLMApiKey = ""
+		}
+		if err := enc.Encode(Record{Kind: KindSettings, Settings: &s}); err != nil {
+			return nil, err
+		}
+		sum.Settings = true
+	}
+	return sum, gz.Close()
+}
+
+// Restore reads an archive from r into store, keeping the original IDs. The
+// archive may also be uncompressed. Settings without an API key keep the
+// key already in store. Restore stops at the first error; records before it
+// stay written, so restore into an empty store.
+func Restore(store storage.Storage, r io.Reader) (*Summary, error) {
+	br := bufio.NewReader(r)
+	var in io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		defer func() {
+			_ = gz.Close()
+		}()
+		in = gz
+	}
+	dec := json.NewDecoder(in)
+
+	var h Header
+	if err := dec.Decode(&h); err != nil || h.Format != Format {
+		return nil, fmt.Errorf("%w: missing header", ErrInvalidArchive)
+	}
+	if h.Version < 1 || h.Version > Version {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, h.Version)
+	}
+
+	sum := &Summary{}
+	for line := 2; ; line++ {
+		var rec Record
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return sum, fmt.Errorf("%w: record %d: %v", ErrInvalidArchive, line, err)
+		}
+		if err := restoreRecord(store, rec, sum); err != nil {
+			return sum, fmt.Errorf("record %d: %w", line, err)
+		}
+	}
+	return sum, nil
+}
+
+func restoreRecord(store storage.Storage, rec Record, sum *Summary) error {
+	switch {
+	case rec.Kind == KindUser && rec.User != nil:
+		if err := store.CreateUser(*rec.User); err != nil {
+			if errors.Is(err, storage.ErrConflict) {
+				return fmt.Errorf("%w: user %s", ErrNotEmpty, rec.User.Username)
+			}
+			return err
+		}
+		sum.Users++
+	case rec.Kind == KindProvider && rec.Provider != nil:
+		if err := store.CreateProviderProfile(*rec.Provider); err != nil {
+			if errors.Is(err, storage.ErrConflict) {
+				return fmt.Errorf("%w: provider %s", ErrNotEmpty, rec.Provider.ID)
+			}
+			return err
+		}
+		sum.Providers++
+	case rec.Kind == KindThread && rec.Thread != nil:
+		existing, err := store.GetThread(rec.Thread.ID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return fmt.Errorf("%w: %s", ErrNotEmpty, rec.Thread.ID)
+		}
+		if err := store.CreateThread(*rec.Thread); err != nil {
+			return err
+		}
+		sum.Threads++
+	case rec.Kind == KindMessage && rec.Message != nil:
+		if err := store.CreateMessage(*rec.Message); err != nil {
+			return err
+		}
+		sum.Messages++
+	case rec.Kind == KindSettings && rec.Settings != nil:
+		s := *rec.Settings
+		if s.ID == "" {
+			s.ID = storage.DefaultSettingsID
+		}
+		if s.LLMApiKey == "" {
+			current, err := store.GetSettings(s.ID)
+			if err != nil {
+				return err
+			}
+			if current != nil {
+				s.LLMApiKey = current.LLMApiKey
+			}
+		}
+		if err := store.UpdateSettings(s); err != nil {
+			return err
+		}
+		sum.Settings = true
+	default:
+		return fmt.Errorf("%w: unknown record kind %q", ErrInvalidArchive, rec.Kind)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package backup_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/krackenservices/threadwell/backup"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+	"github.com/krackenservices/threadwell/storage/memory"
+	"github.com/krackenservices/threadwell/storage/sqlite"
+	"github.com/stretchr/testify/require"
+)
+
+// seed fills store with two threads, one of them branched, and settings and
+// a provider profile holding API keys.
+func seed(t *testing.T, store storage.Storage) {
+	t.Helper()
+	root, a := "b-root", "b-a"
+	require.NoError(t, store.CreateThread(models.Thread{ID: "b-t1", Title: "first", CreatedAt: 1, UpdatedAt: 50}))
+	require.NoError(t, store.CreateProviderProfile(models.ProviderProfile{ID: "b-p1", Name: "hosted", LLMProvider: "openai", LLMApiKey: "sk-profile", IsDefault: true, CreatedAt: 1}))
+	require.NoError(t, store.CreateThread(models.Thread{ID: "b-t2", Title: "second", CreatedAt: 2, ProviderID: "b-p1"}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: root, ThreadID: "b-t1", Role: "user", Content: "hi", Timestamp: 10}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: a, ThreadID: "b-t1", ParentID: &root, RootID: &root, Role: "assistant", Content: "hello", Timestamp: 20}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "b-b", ThreadID: "b-t1", ParentID: &root, RootID: &root, Role: "assistant", Content: "hey", Timestamp: 30}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "b-c", ThreadID: "b-t1", ParentID: &a, RootID: &root, Role: "user", Content: "more", Timestamp: 40}))
+	require.NoError(t, store.UpdateSettings(models.Settings{ID: "default", LLMProvider: "openai", LLMName: "gpt", LLMApiKey: "sk-secret"}))
+}
+
+func requireSameData(t *testing.T, want, got storage.Storage) {
+	t.Helper()
+	wantThreads, err := want.ListThreads()
+	require.NoError(t, err)
+	gotThreads, err := got.ListThreads()
+	require.NoError(t, err)
+	require.Equal(t, wantThreads, gotThreads)
+	for _, th := range wantThreads {
+		wantMsgs, err := want.ListMessages(th.ID)
+		require.NoError(t, err)
+		gotMsgs, err := got.ListMessages(th.ID)
+		require.NoError(t, err)
+		require.Equal(t, wantMsgs, gotMsgs)
+	}
+}
+
+func TestBackupRestoresIntoAnotherBackend(t *testing.T) {
+	src := memory.New()
+	seed(t, src)
+
+	var archive bytes.Buffer
+	sum, err := backup.Write(&archive, src, backup.Options{IncludeSecrets: true})
+	require.NoError(t, err)
+	require.Equal(t, &backup.Summary{Providers: 1, Threads: 2, Messages: 4, Settings: true}, sum)
+
+	dst, err := sqlite.New(filepath.Join(t.TempDir(), "restore.db"))
+	require.NoError(t, err)
+	sum, err = backup.Restore(dst, &archive)
+	require.NoError(t, err)
+	require.Equal(t, 2, sum.Threads)
+	requireSameData(t, src, dst)
+
+	settings, err := dst.GetSettings(storage.DefaultSettingsID)
+	require.NoError(t, err)
+	require.Equal(t, "sk-secret", settings.LLMApiKey)
+	require.Equal(t, "gpt", settings.LLMName)
+	profile, err := dst.GetProviderProfile("b-p1")
+	require.NoError(t, err)
+	require.Equal(t, "sk-profile", profile.LLMApiKey)
+	require.True(t, profile.IsDefault)
+}
+
+func TestBackupExcludesSecretsByDefault(t *testing.T) {
+	src := memory.New()
+	seed(t, src)
+	var archive bytes.Buffer
+	_, err := backup.Write(&archive, src, backup.Options{})
+	require.NoError(t, err)
+
+	gz, err := gzip.NewReader(&archive)
+	require.NoError(t, err)
+	raw, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	require.NotContains(t, string(raw), "sk-secret")
+	require.NotContains(t, string(raw), "sk-profile")
+	first, _, _ := strings.Cut(string(raw), "\n")
+	var h backup.Header
+	require.NoError(t, json.Unmarshal([]byte(first), &h))
+	require.Equal(t, backup.Format, h.Format)
+	require.Equal(t, backup.Version, h.Version)
+	require.False(t, h.Secrets)
+
+	// Restoring settings without a key keeps the target's own key.
+	dst := memory.New()
+	require.NoError(t, dst.UpdateSettings(models.Settings{ID: "default", LLMApiKey: "sk-local"}))
+	_, err = backup.Restore(dst, bytes.NewReader(raw))
+	require.NoError(t, err, "uncompressed archives restore too")
+	settings, err := dst.GetSettings(storage.DefaultSettingsID)
+	require.NoError(t, err)
+	require.Equal(t, "sk-local", settings.LLMApiKey)
+	require.Equal(t, "openai", settings.LLMProvider)
+}
+
+func TestRestoreRejectsBadInput(t *testing.T) {
+	_, err := backup.Restore(memory.New(), strings.NewReader(`{"format":"threadwell","version":1}`))
+	require.ErrorIs(t, err, backup.ErrInvalidArchive)
+
+	_, err = backup.Restore(memory.New(), strings.NewReader(`{"format":"threadwell-backup","version":99}`))
+	require.ErrorIs(t, err, backup.ErrInvalidArchive)
+
+	_, err = backup.Restore(memory.New(), strings.NewReader(`{"format":"threadwell-backup","version":1}
+{"kind":"widget"}`))
+	require.ErrorIs(t, err, backup.ErrInvalidArchive)
+
+	src := memory.New()
+	seed(t, src)
+	var archive bytes.Buffer
+	_, err = backup.Write(&archive, src, backup.Options{})
+	require.NoError(t, err)
+	_, err = backup.Restore(src, &archive)
+	require.ErrorIs(t, err, backup.ErrNotEmpty)
+}
+
+func TestBackupCarriesUsersAndLimitsToOwner(t *testing.T) {
+	src := memory.New()
+	seed(t, src)
+	alice := models.User{ID: "u-alice", Username: "alice", PasswordHash: "bcrypt-hash", CreatedAt: 1}
+	require.NoError(t, src.CreateUser(alice))
+	scoped := storage.ForUser(src, alice.ID)
+	require.NoError(t, scoped.CreateThread(models.Thread{ID: "b-mine", Title: "mine", CreatedAt: 3}))
+	require.NoError(t, scoped.UpdateSettings(models.Settings{LLMProvider: "claude", LLMApiKey: "sk-alice"}))
+
+	// A whole-store archive restores accounts, owners and per-user settings.
+	var archive bytes.Buffer
+	sum, err := backup.Write(&archive, src, backup.Options{IncludeSecrets: true})
+	require.NoError(t, err)
+	require.Equal(t, &backup.Summary{Users: 1, Providers: 1, Threads: 3, Messages: 4, Settings: true}, sum)
+	dst := memory.New()
+	_, err = backup.Restore(dst, &archive)
+	require.NoError(t, err)
+	requireSameData(t, src, dst)
+	user, err := dst.GetUser(alice.ID)
+	require.NoError(t, err)
+	require.Equal(t, alice, *user)
+	settings, err := dst.GetSettings(alice.ID)
+	require.NoError(t, err)
+	require.Equal(t, "sk-alice", settings.LLMApiKey)
+
+	// An owner's archive holds only that user's threads and settings.
+	archive.Reset()
+	sum, err = backup.Write(&archive, src, backup.Options{Owner: alice.ID})
+	require.NoError(t, err)
+	require.Equal(t, &backup.Summary{Threads: 1, Settings: true}, sum)
+	gz, err := gzip.NewReader(&archive)
+	require.NoError(t, err)
+	raw, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	require.NotContains(t, string(raw), "bcrypt-hash")
+	require.NotContains(t, string(raw), "b-t1")
+	require.Contains(t, string(raw), `"llm_provider":"claude"`)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/krackenservices/threadwell/backup"
+	"github.com/krackenservices/threadwell/config"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+// runBackup implements `threadwell backup [-o file] [-secrets]`. The archive
+// goes to stdout unless -o is given.
+func runBackup(cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	out := fs.String("o", "", "write the archive to this file instead of stdout")
+	secrets := fs.Bool("secrets", false, "include the LLM API key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	store, closeStore, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		w = f
+	}
+
+	sum, err := backup.Write(w, store, backup.Options{IncludeSecrets: *secrets})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "backed up %d thread(s) and %d message(s)\n", sum.Threads, sum.Messages)
+	return nil
+}
+
+// runRestore implements `threadwell restore <file>`; "-" reads stdin.
+func runRestore(cfg config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: threadwell restore <file|->")
+	}
+	if !persistent(cfg) {
+		return errors.New("memory storage is lost on exit; start the server with STORAGE_SEED instead")
+	}
+
+	store, closeStore, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		r = f
+	}
+
+	sum, err := backup.Restore(store, r)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %d thread(s) and %d message(s)\n", sum.Threads, sum.Messages)
+	return nil
+}
+
+// seedStore loads a backup archive into a freshly created store.
+func seedStore(store storage.Storage, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	sum, err := backup.Restore(store, f)
+	if err != nil {
+		return err
+	}
+	log.Printf("seeded storage from %s: %d thread(s), %d message(s)", path, sum.Threads, sum.Messages)
+	return nil
+}
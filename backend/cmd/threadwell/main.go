@@ -5,14 +5,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/krackenservices/threadwell/api"
 	"github.com/krackenservices/threadwell/config"
 	_ "github.com/krackenservices/threadwell/docs" // generated by swag init
+	"github.com/krackenservices/threadwell/secrets"
 	"github.com/krackenservices/threadwell/storage"
 	"github.com/krackenservices/threadwell/storage/memory"
+	"github.com/krackenservices/threadwell/storage/postgres"
 	"github.com/krackenservices/threadwell/storage/sqlite"
 	"github.com/rs/cors" // Import the new library
 	"github.com/swaggo/http-swagger"
@@ -23,24 +31,59 @@ import (
 func main() {
 	cfg := config.Load()
 
-	var (
-		store storage.Storage
-		err   error
-	)
-	switch cfg.Storage.Type {
-	case "sqlite":
-		store, err = sqlite.New(cfg.Storage.Path)
-	case "memory":
-		store = memory.New()
-	default:
-		log.Printf("unsupported or undefined storage type: '%s' - Defaulting to Memory", cfg.Storage.Type)
-		store = memory.New()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			if err := runMigrate(cfg, os.Args[2:]); err != nil {
+				log.Fatalf("migrate: %v", err)
+			}
+			return
+		case "backup":
+			if err := runBackup(cfg, os.Args[2:]); err != nil {
+				log.Fatalf("backup: %v", err)
+			}
+			return
+		case "restore":
+			if err := runRestore(cfg, os.Args[2:]); err != nil {
+				log.Fatalf("restore: %v", err)
+			}
+			return
+		case "user":
+			if err := runUser(cfg, os.Args[2:]); err != nil {
+				log.Fatalf("user: %v", err)
+			}
+			return
+		default:
+			log.Fatalf("unknown command %q (available: migrate, backup, restore, user)", os.Args[1])
+		}
 	}
+
+	store, closeStore, err := openStore(cfg)
 	if err != nil {
 		log.Fatalf("storage init error: %v", err)
 	}
+	if cfg.Storage.Seed != "" {
+		if persistent(cfg) {
+			log.Printf("STORAGE_SEED ignored for persistent storage; use `threadwell restore`")
+		} else if err := seedStore(store, cfg.Storage.Seed); err != nil {
+			log.Fatalf("seed storage: %v", err)
+		}
+	}
+
+	var opts []api.Option
+	switch cfg.Auth.Mode {
+	case config.AuthUsers:
+		if cfg.Auth.Signup {
+			opts = append(opts, api.WithSignup())
+		}
+	case config.AuthLocal:
+		log.Printf("AUTH_MODE=local: authentication is disabled")
+		opts = append(opts, api.WithLocalMode())
+	default:
+		log.Fatalf("unknown AUTH_MODE %q (available: users, local)", cfg.Auth.Mode)
+	}
 
-	apiHandler := api.RegisterRoutes(store)
+	apiHandler := api.RegisterRoutes(store, opts...)
 	mux := http.NewServeMux()
 	mux.Handle("/swagger/", httpSwagger.WrapHandler)
 	mux.HandleFunc("/swagger.json", func(w http.ResponseWriter, r *http.Request) {
@@ -48,19 +91,126 @@ func main() {
 	})
 	mux.Handle("/", apiHandler)
 
-	// Configure CORS using the library
+	// Tokens travel in the Authorization header, not cookies, so the
+	// browser never needs to send credentials cross-origin.
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
+		AllowedOrigins:   cfg.Server.CORSOrigins,
 		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions},
 		AllowedHeaders:   []string{"Content-Type", "Authorization"},
-		AllowCredentials: true,
+		ExposedHeaders:   []string{api.NextCursorHeader},
+		AllowCredentials: false,
 	})
 
 	// Wrap your router with the CORS handler
 	handler := c.Handler(mux)
 
-	log.Println("Listening on :8001")
-	if err := http.ListenAndServe(":8001", handler); err != nil {
+	// Shut down on SIGINT/SIGTERM so storage can flush (durable memory
+	// mode writes a final snapshot).
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: cfg.Server.Addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Listening on %s", cfg.Server.Addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
+	closeStore()
+}
+
+// openStore opens the configured storage with LLM API keys encrypted under
+// the master key, sealing any still stored in plaintext. The returned func
+// releases it.
+func openStore(cfg config.Config) (storage.Storage, func(), error) {
+	store, closeStore, err := openBackend(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	box, err := openBox(cfg)
+	if err != nil {
+		closeStore()
+		return nil, nil, err
+	}
+	n, err := secrets.EncryptStored(store, box)
+	if err != nil {
+		closeStore()
+		return nil, nil, err
+	}
+	if n > 0 {
+		log.Printf("encrypted %d stored API key(s)", n)
+	}
+	return secrets.Encrypt(store, box), closeStore, nil
+}
+
+// openBox loads the master key. Storage that is lost on exit may run
+// without one; a random key is used for the process.
+func openBox(cfg config.Config) (*secrets.Box, error) {
+	key, err := secrets.LoadKey(cfg.Secrets.MasterKey, cfg.Secrets.MasterKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		if persistent(cfg) {
+			return nil, errors.New("MASTER_KEY or MASTER_KEY_FILE is required to encrypt stored API keys")
+		}
+		key = secrets.NewKey()
+	}
+	return secrets.NewBox(key)
+}
+
+// openBackend creates the configured storage backend. Unknown types fall
+// back to memory. The returned func releases it.
+func openBackend(cfg config.Config) (storage.Storage, func(), error) {
+	switch cfg.Storage.Type {
+	case "sqlite":
+		store, err := sqlite.Open(cfg.Storage.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := store.Init(); err != nil {
+			_ = store.Close()
+			return nil, nil, err
+		}
+		return store, func() { _ = store.Close() }, nil
+	case "postgres":
+		if cfg.Storage.DSN == "" {
+			return nil, nil, errors.New("STORAGE_DSN is required for postgres storage")
+		}
+		store, err := postgres.Open(cfg.Storage.DSN)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := store.Init(); err != nil {
+			_ = store.Close()
+			return nil, nil, err
+		}
+		return store, func() { _ = store.Close() }, nil
+	case "memory":
+	default:
+		log.Printf("unsupported or undefined storage type: '%s' - Defaulting to Memory", cfg.Storage.Type)
+	}
+	if cfg.Storage.Path == "" {
+		return memory.New(), func() {}, nil
+	}
+	store, err := memory.Open(cfg.Storage.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("memory: durable mode, snapshot at %s", cfg.Storage.Path)
+	return store, func() {
+		if err := store.Close(); err != nil {
+			log.Printf("memory: %v", err)
+		}
+	}, nil
+}
+
+// persistent reports whether the configured storage keeps data after the
+// process exits. Memory storage does when STORAGE_PATH is set.
+func persistent(cfg config.Config) bool {
+	return cfg.Storage.Type == "sqlite" || cfg.Storage.Type == "postgres" || cfg.Storage.Path != ""
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/krackenservices/threadwell/config"
+	"github.com/krackenservices/threadwell/storage/postgres"
+	"github.com/krackenservices/threadwell/storage/sqlite"
+)
+
+// migrator is a store with a versioned schema.
+type migrator interface {
+	SchemaVersion() (int, error)
+	Migrate() ([]int, error)
+	Close() error
+}
+
+// runMigrate implements `threadwell migrate [status|up]` for SQLite and
+// PostgreSQL storage. With no argument it reports the schema version,
+// without writing to the database.
+func runMigrate(cfg config.Config, args []string) error {
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	var (
+		store  migrator
+		latest int
+		err    error
+	)
+	switch cfg.Storage.Type {
+	case "sqlite":
+		if cfg.Storage.Path == "" {
+			return errors.New("STORAGE_PATH is required")
+		}
+		if action == "status" {
+			store, err = sqlite.OpenReadOnly(cfg.Storage.Path)
+		} else {
+			store, err = sqlite.Open(cfg.Storage.Path)
+		}
+		latest = sqlite.LatestSchemaVersion()
+	case "postgres":
+		if cfg.Storage.DSN == "" {
+			return errors.New("STORAGE_DSN is required")
+		}
+		store, err = postgres.Open(cfg.Storage.DSN)
+		latest = postgres.LatestSchemaVersion()
+	default:
+		return fmt.Errorf("migrations only apply to sqlite and postgres storage (STORAGE_TYPE=%q)", cfg.Storage.Type)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	current, err := store.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "status":
+		fmt.Printf("schema version: %d (latest: %d)\n", current, latest)
+		if current < latest {
+			fmt.Printf("%d migration(s) pending; run `threadwell migrate up`\n", latest-current)
+		}
+		return nil
+	case "up":
+		applied, err := store.Migrate()
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Printf("already at schema version %d\n", current)
+			return nil
+		}
+		fmt.Printf("migrated from version %d to %d (applied %v)\n", current, latest, applied)
+		return nil
+	default:
+		return fmt.Errorf("unknown action %q (use status or up)", action)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/krackenservices/threadwell/auth"
+	"github.com/krackenservices/threadwell/config"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+const userUsage = "usage: threadwell user add [-claim] <username> | threadwell user list"
+
+// runUser implements `threadwell user add [-claim] <username>` and
+// `threadwell user list`. The password is read from THREADWELL_PASSWORD or
+// the first line of stdin.
+func runUser(cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(userUsage)
+	}
+	if !persistent(cfg) {
+		return errors.New("memory storage is lost on exit; configure STORAGE_TYPE and STORAGE_PATH first")
+	}
+	switch args[0] {
+	case "add":
+		return runUserAdd(cfg, args[1:])
+	case "list":
+		store, closeStore, err := openStore(cfg)
+		if err != nil {
+			return err
+		}
+		defer closeStore()
+		users, err := store.ListUsers()
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			fmt.Printf("%s\t%s\t%s\n", u.ID, u.Username, time.Unix(u.CreatedAt, 0).UTC().Format(time.RFC3339))
+		}
+		return nil
+	default:
+		return errors.New(userUsage)
+	}
+}
+
+func runUserAdd(cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+	claim := fs.Bool("claim", false, "give the user every thread and provider profile without an owner and the local-mode settings")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(userUsage)
+	}
+
+	password := os.Getenv("THREADWELL_PASSWORD")
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "password:")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("read password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	store, closeStore, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	u, err := auth.CreateUser(store, fs.Arg(0), password)
+	if errors.Is(err, storage.ErrConflict) {
+		return fmt.Errorf("username %q is taken", fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created user %s (%s)\n", u.Username, u.ID)
+	if !*claim {
+		return nil
+	}
+
+	// Data written in local mode has no owner; hand it to this user.
+	threads, err := store.ListThreads()
+	if err != nil {
+		return err
+	}
+	claimed := 0
+	for _, t := range threads {
+		if t.OwnerID != "" {
+			continue
+		}
+		t.OwnerID = u.ID
+		if err := store.UpdateThread(t); err != nil {
+			return err
+		}
+		claimed++
+	}
+	profiles, err := store.ListProviderProfiles("")
+	if err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		p.OwnerID = u.ID
+		if err := store.UpdateProviderProfile(p); err != nil {
+			return err
+		}
+	}
+	settings, err := store.GetSettings(storage.DefaultSettingsID)
+	if err != nil {
+		return err
+	}
+	settings.ID = u.ID
+	if err := store.UpdateSettings(*settings); err != nil {
+		return err
+	}
+	fmt.Printf("claimed %d thread(s), %d provider profile(s) and the local settings\n", claimed, len(profiles))
+	return nil
+}
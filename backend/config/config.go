@@ -1,22 +1,74 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strings"
+)
+
+// Auth modes. AuthUsers requires a bearer token on every API call;
+// AuthLocal serves a single unauthenticated user.
+const (
+	AuthUsers = "users"
+	AuthLocal = "local"
+)
+
+// DefaultCORSOrigins are the Vite dev server and the bundled web UI.
+var DefaultCORSOrigins = []string{"http://localhost:5173", "http://localhost:8080"}
 
 type Config struct {
 	Storage struct {
-		Type string `json:"type"` // "sqlite" or "memory"
-		Path string `json:"path"` // e.g. "data.db"
+		Type string `json:"type"` // "sqlite", "postgres" or "memory"
+		Path string `json:"path"` // e.g. "data.db"; for memory, enables durable mode
+		DSN  string `json:"dsn"`  // PostgreSQL connection string
+		Seed string `json:"seed"` // backup archive loaded into memory storage at startup
 	} `json:"storage"`
+	Auth struct {
+		Mode   string `json:"mode"`   // AuthUsers (default) or AuthLocal
+		Signup bool   `json:"signup"` // allow self-registration
+	} `json:"auth"`
+	Secrets struct {
+		MasterKey     string `json:"-"`               // base64 or hex key that encrypts stored API keys
+		MasterKeyFile string `json:"master_key_file"` // file holding the key; created if missing
+	} `json:"secrets"`
+	Server struct {
+		Addr        string   `json:"addr"`         // listen address
+		CORSOrigins []string `json:"cors_origins"` // browser origins allowed to call the API
+	} `json:"server"`
 }
 
 func Load() Config {
-	return Config{
-		Storage: struct {
-			Type string `json:"type"`
-			Path string `json:"path"`
-		}{
-			Type: os.Getenv("STORAGE_TYPE"),
-			Path: os.Getenv("STORAGE_PATH"),
-		},
+	var cfg Config
+	cfg.Storage.Type = os.Getenv("STORAGE_TYPE")
+	cfg.Storage.Path = os.Getenv("STORAGE_PATH")
+	cfg.Storage.DSN = os.Getenv("STORAGE_DSN")
+	cfg.Storage.Seed = os.Getenv("STORAGE_SEED")
+
+	cfg.Auth.Mode = os.Getenv("AUTH_MODE")
+	if cfg.Auth.Mode == "" {
+		cfg.Auth.Mode = AuthUsers
+	}
+	cfg.Auth.Signup = os.Getenv("AUTH_SIGNUP") == "true"
+
+	cfg.Secrets.MasterKey = os.Getenv("MASTER_KEY")
+	cfg.Secrets.MasterKeyFile = os.Getenv("MASTER_KEY_FILE")
+
+	// Local mode has no authentication, so only listen on loopback unless
+	// told otherwise.
+	cfg.Server.Addr = os.Getenv("LISTEN_ADDR")
+	if cfg.Server.Addr == "" {
+		cfg.Server.Addr = ":8001"
+		if cfg.Auth.Mode == AuthLocal {
+			cfg.Server.Addr = "127.0.0.1:8001"
+		}
+	}
+	cfg.Server.CORSOrigins = DefaultCORSOrigins
+	if v := os.Getenv("CORS_ORIGINS"); v != "" {
+		cfg.Server.CORSOrigins = nil
+		for _, o := range strings.Split(v, ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				cfg.Server.CORSOrigins = append(cfg.Server.CORSOrigins, o)
+			}
+		}
 	}
+	return cfg
 }
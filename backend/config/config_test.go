@@ -19,3 +19,26 @@ func TestLoadReadsEnv(t *testing.T) {
 		t.Fatalf("expected storage path /tmp/test.db, got %s", cfg.Storage.Path)
 	}
 }
+
+func TestLoadAuthDefaults(t *testing.T) {
+	cfg := Load()
+	if cfg.Auth.Mode != AuthUsers || cfg.Auth.Signup {
+		t.Fatalf("expected users mode without signup, got %+v", cfg.Auth)
+	}
+	if cfg.Server.Addr != ":8001" {
+		t.Fatalf("expected :8001, got %s", cfg.Server.Addr)
+	}
+
+	os.Setenv("AUTH_MODE", "local")
+	os.Setenv("CORS_ORIGINS", "https://a.example, https://b.example")
+	defer os.Unsetenv("AUTH_MODE")
+	defer os.Unsetenv("CORS_ORIGINS")
+
+	cfg = Load()
+	if cfg.Server.Addr != "127.0.0.1:8001" {
+		t.Fatalf("expected loopback address in local mode, got %s", cfg.Server.Addr)
+	}
+	if len(cfg.Server.CORSOrigins) != 2 || cfg.Server.CORSOrigins[1] != "https://b.example" {
+		t.Fatalf("unexpected origins %v", cfg.Server.CORSOrigins)
+	}
+}
@@ -0,0 +1,159 @@
+// Package export renders a thread's messages as JSON, Markdown or JSONL.
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+const (
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+	FormatJSONL    = "jsonl"
+)
+
+// DocumentFormat and DocumentVersion identify ThreadWell's own JSON export.
+const (
+	DocumentFormat  = "threadwell"
+	DocumentVersion = 1
+)
+
+// ErrUnknownFormat is returned for a format other than json, markdown or jsonl.
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// Document is the JSON export of a thread. Messages keep their IDs and
+// parent links and are listed parents first, so the thread can be imported
+// again unchanged.
+type Document struct {
+	Format   string           `json:"format"`
+	Version  int              `json:"version"`
+	Thread   models.Thread    `json:"thread"`
+	Messages []models.Message `json:"messages"`
+}
+
+// ChatMessage is one turn in the {"role","content"} chat format.
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatExample is one JSONL line: a single root-to-leaf conversation.
+type ChatExample struct {
+	Messages []ChatMessage `json:"messages"`
+}
+
+// ContentType returns the MIME type and file extension for format.
+func ContentType(format string) (mime, ext string, err error) {
+	switch format {
+	case FormatJSON:
+		return "application/json", "json", nil
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8", "md", nil
+	case FormatJSONL:
+		return "application/x-ndjson", "jsonl", nil
+	}
+	return "", "", fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+}
+
+// Write renders msgs, which may be a whole thread or a single branch, in the
+// given format.
+func Write(w io.Writer, format string, thread models.Thread, msgs []models.Message) error {
+	tree := storage.BuildTree(msgs)
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(Document{
+			Format:   DocumentFormat,
+			Version:  DocumentVersion,
+			Thread:   thread,
+			Messages: Flatten(tree),
+		})
+	case FormatMarkdown:
+		return writeMarkdown(w, thread, tree)
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		for _, path := range Paths(tree) {
+			ex := ChatExample{Messages: make([]ChatMessage, len(path))}
+			for i, m := range path {
+				ex.Messages[i] = ChatMessage{Role: m.Role, Content: m.Content}
+			}
+			if err := enc.Encode(ex); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+}
+
+// Flatten lists a tree depth first, each message before its replies.
+func Flatten(tree []*storage.TreeNode) []models.Message {
+	out := []models.Message{}
+	var walk func(nodes []*storage.TreeNode)
+	walk = func(nodes []*storage.TreeNode) {
+		for _, n := range nodes {
+			out = append(out, n.Message)
+			walk(n.Children)
+		}
+	}
+	walk(tree)
+	return out
+}
+
+// Paths returns every root-to-leaf conversation in the tree, in tree order.
+func Paths(tree []*storage.TreeNode) [][]models.Message {
+	var out [][]models.Message
+	var walk func(n *storage.TreeNode, prefix []models.Message)
+	walk = func(n *storage.TreeNode, prefix []models.Message) {
+		path := append(prefix[:len(prefix):len(prefix)], n.Message)
+		if len(n.Children) == 0 {
+			out = append(out, path)
+			return
+		}
+		for _, c := range n.Children {
+			walk(c, path)
+		}
+	}
+	for _, root := range tree {
+		walk(root, nil)
+	}
+	return out
+}
+
+// writeMarkdown renders the thread as a nested list, one level per reply.
+func writeMarkdown(w io.Writer, thread models.Thread, tree []*storage.TreeNode) error {
+	var sb strings.Builder
+	title := thread.Title
+	if title == "" {
+		title = thread.ID
+	}
+	sb.WriteString("# " + title + "\n\n")
+
+	var walk func(nodes []*storage.TreeNode)
+	walk = func(nodes []*storage.TreeNode) {
+		for _, n := range nodes {
+			indent := strings.Repeat("  ", n.Depth)
+			lines := strings.Split(strings.TrimRight(n.Content, "\n"), "\n")
+			sb.WriteString(indent + "- **" + n.Role + "**: " + lines[0] + "\n")
+			for _, line := range lines[1:] {
+				if line == "" {
+					sb.WriteString("\n")
+					continue
+				}
+				sb.WriteString(indent + "  " + line + "\n")
+			}
+			walk(n.Children)
+		}
+	}
+	walk(tree)
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
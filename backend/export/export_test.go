@@ -0,0 +1,77 @@
+package export_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/krackenservices/threadwell/export"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/stretchr/testify/require"
+)
+
+// fixture is q → {a1, a2 → q2}
+func fixture() (models.Thread, []models.Message) {
+	q, a2 := "q", "a2"
+	return models.Thread{ID: "t1", Title: "Plants", CreatedAt: 1}, []models.Message{
+		{ID: "q2", ThreadID: "t1", ParentID: &a2, RootID: &q, Role: "user", Content: "And cacti?", Timestamp: 4},
+		{ID: "a1", ThreadID: "t1", ParentID: &q, RootID: &q, Role: "assistant", Content: "Weekly.", Timestamp: 2},
+		{ID: "q", ThreadID: "t1", Role: "user", Content: "How often should I water ferns?", Timestamp: 1},
+		{ID: "a2", ThreadID: "t1", ParentID: &q, RootID: &q, Role: "assistant", Content: "Keep the soil moist.\n\nMist them too.", Timestamp: 3},
+	}
+}
+
+func TestWriteJSONOrdersParentsFirst(t *testing.T) {
+	thread, msgs := fixture()
+	var buf bytes.Buffer
+	require.NoError(t, export.Write(&buf, export.FormatJSON, thread, msgs))
+
+	var doc export.Document
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	require.Equal(t, export.DocumentFormat, doc.Format)
+	require.Equal(t, export.DocumentVersion, doc.Version)
+	require.Equal(t, "Plants", doc.Thread.Title)
+	var ids []string
+	for _, m := range doc.Messages {
+		ids = append(ids, m.ID)
+	}
+	require.Equal(t, []string{"q", "a1", "a2", "q2"}, ids)
+}
+
+func TestWriteMarkdownNestsReplies(t *testing.T) {
+	thread, msgs := fixture()
+	var buf bytes.Buffer
+	require.NoError(t, export.Write(&buf, export.FormatMarkdown, thread, msgs))
+
+	require.Equal(t, `# Plants
+
+- **user**: How often should I water ferns?
+  - **assistant**: Weekly.
+  - **assistant**: Keep the soil moist.
+
+    Mist them too.
+    - **user**: And cacti?
+`, buf.String())
+}
+
+func TestWriteJSONLOneLinePerBranch(t *testing.T) {
+	thread, msgs := fixture()
+	var buf bytes.Buffer
+	require.NoError(t, export.Write(&buf, export.FormatJSONL, thread, msgs))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, `{"messages":[{"role":"user","content":"How often should I water ferns?"},{"role":"assistant","content":"Weekly."}]}`, lines[0])
+
+	var ex export.ChatExample
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &ex))
+	require.Len(t, ex.Messages, 3)
+	require.Equal(t, "And cacti?", ex.Messages[2].Content)
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	thread, msgs := fixture()
+	err := export.Write(&bytes.Buffer{}, "pdf", thread, msgs)
+	require.ErrorIs(t, err, export.ErrUnknownFormat)
+}
@@ -0,0 +1,280 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/krackenservices/threadwell/export"
+	"github.com/krackenservices/threadwell/models"
+)
+
+// chatgptConversation is one element of ChatGPT's conversations.json. The
+// conversation is a tree of nodes linked by parent; edits and regenerations
+// appear as sibling nodes.
+type chatgptConversation struct {
+	Title      string                 `json:"title"`
+	CreateTime float64                `json:"create_time"`
+	Mapping    map[string]chatgptNode `json:"mapping"`
+}
+
+type chatgptNode struct {
+	ID      string          `json:"id"`
+	Parent  *string         `json:"parent"`
+	Message *chatgptMessage `json:"message"`
+}
+
+type chatgptMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime *float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Text        string            `json:"text"`
+	} `json:"content"`
+	Metadata struct {
+		Hidden bool `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+// text joins the string parts of a message. Non-text parts such as images
+// are dropped.
+func (m *chatgptMessage) text() string {
+	if m.Content.Text != "" {
+		return m.Content.Text
+	}
+	var parts []string
+	for _, raw := range m.Content.Parts {
+		var s string
+		if json.Unmarshal(raw, &s) == nil && s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func parseChatGPT(raw json.RawMessage) entry {
+	var c chatgptConversation
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return entry{conv: Conversation{Source: SourceChatGPT}, err: fmt.Errorf("invalid ChatGPT conversation: %w", err)}
+	}
+	conv := Conversation{Source: SourceChatGPT, Title: c.Title, CreatedAt: int64(c.CreateTime)}
+
+	// Empty and hidden nodes (the root placeholder, system prompts) are
+	// dropped; their children hang off the nearest kept ancestor instead.
+	kept := map[string]bool{}
+	for id, n := range c.Mapping {
+		if n.Message != nil && !n.Message.Metadata.Hidden && strings.TrimSpace(n.Message.text()) != "" {
+			kept[id] = true
+		}
+	}
+	for id, n := range c.Mapping {
+		if !kept[id] {
+			continue
+		}
+		msg := models.Message{ID: id, Role: n.Message.Author.Role, Content: n.Message.text(), Timestamp: conv.CreatedAt}
+		if n.Message.CreateTime != nil {
+			msg.Timestamp = int64(*n.Message.CreateTime)
+		}
+		parent, err := keptAncestor(c.Mapping, kept, n.Parent)
+		if err != nil {
+			return entry{conv: conv, err: err}
+		}
+		msg.ParentID = parent
+		conv.Messages = append(conv.Messages, msg)
+	}
+
+	var err error
+	conv.Messages, err = parentsFirst(conv.Messages)
+	return entry{conv: conv, err: err}
+}
+
+// keptAncestor walks up from parent to the first node that is imported.
+func keptAncestor(mapping map[string]chatgptNode, kept map[string]bool, parent *string) (*string, error) {
+	for steps := 0; parent != nil; steps++ {
+		if steps > len(mapping) {
+			return nil, errors.New("conversation mapping has a cycle")
+		}
+		if kept[*parent] {
+			id := *parent
+			return &id, nil
+		}
+		n, ok := mapping[*parent]
+		if !ok {
+			return nil, nil
+		}
+		parent = n.Parent
+	}
+	return nil, nil
+}
+
+// openaiConversation is a chat completion request body, or just its
+// messages array.
+type openaiConversation struct {
+	Title    string          `json:"title"`
+	Messages []openaiMessage `json:"messages"`
+}
+
+type openaiMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// text accepts both a plain string and an array of {"type":"text"} parts.
+func (m openaiMessage) text() (string, error) {
+	if len(m.Content) == 0 || string(m.Content) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(m.Content, &s); err == nil {
+		return s, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return "", errors.New("content must be a string or an array of parts")
+	}
+	var texts []string
+	for _, p := range parts {
+		if p.Type == "text" && p.Text != "" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// parseOpenAI reads a linear conversation. It has no timestamps, so messages
+// get consecutive ones ending now.
+func parseOpenAI(raw json.RawMessage) entry {
+	var c openaiConversation
+	if len(raw) > 0 && raw[0] == '[' {
+		if err := json.Unmarshal(raw, &c.Messages); err != nil {
+			return entry{conv: Conversation{Source: SourceOpenAI}, err: fmt.Errorf("invalid message array: %w", err)}
+		}
+	} else if err := json.Unmarshal(raw, &c); err != nil {
+		return entry{conv: Conversation{Source: SourceOpenAI}, err: fmt.Errorf("invalid conversation: %w", err)}
+	}
+
+	conv := Conversation{Source: SourceOpenAI, Title: c.Title}
+	at := sequence(len(c.Messages))
+	var parent *string
+	for i, m := range c.Messages {
+		if m.Role == "" {
+			return entry{conv: conv, err: fmt.Errorf("message %d has no role", i)}
+		}
+		content, err := m.text()
+		if err != nil {
+			return entry{conv: conv, err: fmt.Errorf("message %d: %w", i, err)}
+		}
+		msg := models.Message{ID: fmt.Sprint(i), ParentID: parent, Role: m.Role, Content: content, Timestamp: at(i)}
+		conv.Messages = append(conv.Messages, msg)
+		id := msg.ID
+		parent = &id
+	}
+	if conv.Title == "" {
+		conv.Title = titleFrom(conv.Messages)
+	}
+	return entry{conv: conv}
+}
+
+// titleFrom uses the start of the first user message as a title.
+func titleFrom(msgs []models.Message) string {
+	for _, m := range msgs {
+		if m.Role != "user" {
+			continue
+		}
+		title := strings.Join(strings.Fields(m.Content), " ")
+		if r := []rune(title); len(r) > 60 {
+			title = string(r[:60]) + "…"
+		}
+		return title
+	}
+	return ""
+}
+
+// parseThreadWell reads a document written by export.Write in JSON format.
+func parseThreadWell(raw json.RawMessage) entry {
+	var doc export.Document
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return entry{conv: Conversation{Source: SourceThreadWell}, err: fmt.Errorf("invalid ThreadWell export: %w", err)}
+	}
+	conv := Conversation{
+		Source:       SourceThreadWell,
+		Title:        doc.Thread.Title,
+		CreatedAt:    doc.Thread.CreatedAt,
+		Model:        doc.Thread.Model,
+		SystemPrompt: doc.Thread.SystemPrompt,
+		Temperature:  doc.Thread.Temperature,
+		MaxTokens:    doc.Thread.MaxTokens,
+	}
+	if doc.Version < 1 || doc.Version > export.DocumentVersion {
+		return entry{conv: conv, err: fmt.Errorf("unsupported export version %d", doc.Version)}
+	}
+	for i, m := range doc.Messages {
+		if m.ID == "" || m.Role == "" {
+			return entry{conv: conv, err: fmt.Errorf("message %d needs an id and a role", i)}
+		}
+	}
+	var err error
+	conv.Messages, err = parentsFirst(doc.Messages)
+	return entry{conv: conv, err: err}
+}
+
+// parentsFirst orders messages so every parent precedes its children,
+// siblings by timestamp then ID. It fails on duplicate IDs, a parent that is
+// not in the conversation, or a cycle.
+func parentsFirst(msgs []models.Message) ([]models.Message, error) {
+	if len(msgs) == 0 {
+		return nil, errors.New("conversation has no messages")
+	}
+	byID := make(map[string]bool, len(msgs))
+	for _, m := range msgs {
+		if byID[m.ID] {
+			return nil, fmt.Errorf("duplicate message id %s", m.ID)
+		}
+		byID[m.ID] = true
+	}
+	children := map[string][]models.Message{}
+	var roots []models.Message
+	for _, m := range msgs {
+		switch {
+		case m.ParentID == nil:
+			roots = append(roots, m)
+		case !byID[*m.ParentID]:
+			return nil, fmt.Errorf("message %s has unknown parent %s", m.ID, *m.ParentID)
+		default:
+			children[*m.ParentID] = append(children[*m.ParentID], m)
+		}
+	}
+
+	out := make([]models.Message, 0, len(msgs))
+	queue := roots
+	for len(queue) > 0 {
+		sortSiblings(queue)
+		next := []models.Message{}
+		for _, m := range queue {
+			out = append(out, m)
+			next = append(next, children[m.ID]...)
+		}
+		queue = next
+	}
+	if len(out) != len(msgs) {
+		return nil, errors.New("messages form a cycle")
+	}
+	return out, nil
+}
+
+func sortSiblings(msgs []models.Message) {
+	sort.SliceStable(msgs, func(i, j int) bool {
+		if msgs[i].Timestamp != msgs[j].Timestamp {
+			return msgs[i].Timestamp < msgs[j].Timestamp
+		}
+		return msgs[i].ID < msgs[j].ID
+	})
+}
@@ -0,0 +1,237 @@
+// Package importer turns chat exports from other tools, and ThreadWell's own
+// JSON export, into threads and messages.
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+// Source names the format a conversation was read from.
+const (
+	SourceChatGPT    = "chatgpt"
+	SourceOpenAI     = "openai"
+	SourceThreadWell = "threadwell"
+)
+
+// ErrInvalidInput is returned when the body is not JSON, or is JSON in none
+// of the supported shapes. Problems inside a single conversation are reported
+// in its Result instead.
+var ErrInvalidInput = errors.New("invalid import")
+
+// Conversation is one thread read from an export. Messages still carry the
+// IDs and parent links of the source; Save assigns new ones.
+type Conversation struct {
+	Source    string
+	Title     string
+	CreatedAt int64
+	Messages  []models.Message
+
+	// Reply settings, from ThreadWell exports. The provider profile is not
+	// carried over, as profiles belong to the exporting store.
+	Model        string
+	SystemPrompt string
+	Temperature  *float64
+	MaxTokens    int
+}
+
+// Result reports what happened to one conversation of an import.
+type Result struct {
+	Index    int    `json:"index"`
+	Source   string `json:"source"`
+	Title    string `json:"title"`
+	ThreadID string `json:"thread_id,omitempty"`
+	Messages int    `json:"messages"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report is the outcome of a whole import.
+type Report struct {
+	Imported int      `json:"imported"`
+	Failed   int      `json:"failed"`
+	Results  []Result `json:"results"`
+}
+
+// entry is a parsed conversation, or the reason it could not be parsed.
+type entry struct {
+	conv Conversation
+	err  error
+}
+
+// Import reads every conversation in r and saves each one as a new thread.
+// The body may hold one JSON value or several in a row (such as JSONL).
+// A conversation that fails is skipped and reported; the rest still import.
+func Import(store storage.Storage, r io.Reader) (*Report, error) {
+	entries, err := parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Results: make([]Result, 0, len(entries))}
+	for i, e := range entries {
+		res := Result{Index: i, Source: e.conv.Source, Title: e.conv.Title}
+		if e.err == nil {
+			res.ThreadID, e.err = Save(store, e.conv)
+		}
+		if e.err != nil {
+			res.Error = e.err.Error()
+			report.Failed++
+		} else {
+			res.Messages = len(e.conv.Messages)
+			report.Imported++
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report, nil
+}
+
+// parse splits the input into conversations, whatever format each is in.
+func parse(r io.Reader) ([]entry, error) {
+	dec := json.NewDecoder(r)
+	var entries []entry
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+		}
+		found, err := parseValue(raw)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, found...)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: no conversations found", ErrInvalidInput)
+	}
+	return entries, nil
+}
+
+// probe holds the keys used to tell the supported shapes apart.
+type probe struct {
+	Format   string          `json:"format"`
+	Mapping  json.RawMessage `json:"mapping"`
+	Messages json.RawMessage `json:"messages"`
+	Role     *string         `json:"role"`
+}
+
+func parseValue(raw json.RawMessage) ([]entry, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		if len(items) == 0 {
+			return nil, nil
+		}
+		// An array of chat messages is a single conversation.
+		var first probe
+		if err := json.Unmarshal(items[0], &first); err == nil && first.Role != nil {
+			return []entry{parseOpenAI(raw)}, nil
+		}
+		var out []entry
+		for _, item := range items {
+			found, err := parseValue(item)
+			if err != nil {
+				// Keep going: one bad element should not sink the batch.
+				out = append(out, entry{err: err})
+				continue
+			}
+			out = append(out, found...)
+		}
+		return out, nil
+	}
+
+	var p probe
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, fmt.Errorf("%w: expected an object or array", ErrInvalidInput)
+	}
+	switch {
+	case p.Format == SourceThreadWell:
+		return []entry{parseThreadWell(raw)}, nil
+	case p.Mapping != nil:
+		return []entry{parseChatGPT(raw)}, nil
+	case p.Messages != nil:
+		return []entry{parseOpenAI(raw)}, nil
+	}
+	return nil, fmt.Errorf("%w: unrecognised conversation format", ErrInvalidInput)
+}
+
+// Save stores a conversation as a new thread with fresh IDs. Messages must be
+// listed parents first. If any message fails the thread is removed again, so
+// a conversation is imported whole or not at all.
+func Save(store storage.Storage, conv Conversation) (string, error) {
+	if len(conv.Messages) == 0 {
+		return "", errors.New("conversation has no messages")
+	}
+	createdAt := conv.CreatedAt
+	if createdAt == 0 {
+		createdAt = conv.Messages[0].Timestamp
+	}
+	title := conv.Title
+	if title == "" {
+		title = "Imported conversation"
+	}
+	thread := models.Thread{
+		ID:           uuid.NewString(),
+		Title:        title,
+		CreatedAt:    createdAt,
+		Model:        conv.Model,
+		SystemPrompt: conv.SystemPrompt,
+		Temperature:  conv.Temperature,
+		MaxTokens:    conv.MaxTokens,
+	}
+	if err := store.CreateThread(thread); err != nil {
+		return "", fmt.Errorf("create thread: %w", err)
+	}
+
+	ids := map[string]string{}
+	roots := map[string]string{}
+	for _, m := range conv.Messages {
+		msg := models.Message{
+			ID:        uuid.NewString(),
+			ThreadID:  thread.ID,
+			Role:      m.Role,
+			Content:   m.Content,
+			Timestamp: m.Timestamp,
+		}
+		// Every message points at its root, roots at themselves, as in
+		// threads made by MoveSubtree.
+		root := msg.ID
+		if m.ParentID != nil {
+			parent, ok := ids[*m.ParentID]
+			if !ok {
+				_ = store.DeleteThread(thread.ID)
+				return "", fmt.Errorf("message %s appears before its parent %s", m.ID, *m.ParentID)
+			}
+			root = roots[parent]
+			msg.ParentID = &parent
+		}
+		msg.RootID = &root
+		ids[m.ID] = msg.ID
+		roots[msg.ID] = root
+
+		if err := store.CreateMessage(msg); err != nil {
+			_ = store.DeleteThread(thread.ID)
+			return "", fmt.Errorf("save message %s: %w", m.ID, err)
+		}
+	}
+	return thread.ID, nil
+}
+
+// sequence gives n messages without timestamps consecutive times ending now,
+// so they keep their order.
+func sequence(n int) func(i int) int64 {
+	start := time.Now().Unix() - int64(n) + 1
+	return func(i int) int64 { return start + int64(i) }
+}
@@ -0,0 +1,180 @@
+package importer_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/krackenservices/threadwell/export"
+	"github.com/krackenservices/threadwell/importer"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+	"github.com/krackenservices/threadwell/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// chatgptExport has a hidden system prompt under the root placeholder, and
+// a regenerated answer as a second branch.
+const chatgptExport = `[{
+	"title": "Ferns",
+	"create_time": 1700000000.5,
+	"mapping": {
+		"root": {"id": "root", "message": null, "parent": null, "children": ["sys"]},
+		"sys": {"id": "sys", "parent": "root", "children": ["q"], "message": {
+			"author": {"role": "system"}, "content": {"content_type": "text", "parts": [""]},
+			"metadata": {"is_visually_hidden_from_conversation": true}}},
+		"q": {"id": "q", "parent": "sys", "children": ["a1", "a2"], "message": {
+			"author": {"role": "user"}, "create_time": 1700000001,
+			"content": {"content_type": "text", "parts": ["How often should I water ferns?"]}}},
+		"a1": {"id": "a1", "parent": "q", "children": [], "message": {
+			"author": {"role": "assistant"}, "create_time": 1700000002,
+			"content": {"content_type": "text", "parts": ["Weekly."]}}},
+		"a2": {"id": "a2", "parent": "q", "children": [], "message": {
+			"author": {"role": "assistant"}, "create_time": 1700000003,
+			"content": {"content_type": "multimodal_text", "parts": [{"asset_pointer": "file-1"}, "Keep the soil moist."]}}}
+	}
+}, {
+	"title": "Broken",
+	"mapping": {
+		"x": {"id": "x", "parent": "y", "message": {"author": {"role": "user"}, "content": {"parts": ["x"]}}},
+		"y": {"id": "y", "parent": "x", "message": {"author": {"role": "user"}, "content": {"parts": ["y"]}}}
+	}
+}]`
+
+func threadMessages(t *testing.T, store storage.Storage, threadID string) (*models.Thread, []*storage.TreeNode) {
+	t.Helper()
+	thread, err := store.GetThread(threadID)
+	require.NoError(t, err)
+	msgs, err := store.ListMessages(threadID)
+	require.NoError(t, err)
+	return thread, storage.BuildTree(msgs)
+}
+
+func TestImportChatGPTKeepsBranchesAndReportsErrors(t *testing.T) {
+	store := memory.New()
+	report, err := importer.Import(store, strings.NewReader(chatgptExport))
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Imported)
+	require.Equal(t, 1, report.Failed)
+	require.Len(t, report.Results, 2)
+	require.Equal(t, importer.SourceChatGPT, report.Results[0].Source)
+	require.Equal(t, 3, report.Results[0].Messages)
+	require.Equal(t, "Broken", report.Results[1].Title)
+	require.Contains(t, report.Results[1].Error, "cycle")
+
+	thread, tree := threadMessages(t, store, report.Results[0].ThreadID)
+	require.Equal(t, "Ferns", thread.Title)
+	require.Equal(t, int64(1700000000), thread.CreatedAt)
+	require.Len(t, tree, 1)
+	q := tree[0]
+	require.Equal(t, "How often should I water ferns?", q.Content)
+	require.Equal(t, int64(1700000001), q.Timestamp)
+	require.Nil(t, q.ParentID)
+	require.Len(t, q.Children, 2)
+	require.Equal(t, "Weekly.", q.Children[0].Content)
+	require.Equal(t, "Keep the soil moist.", q.Children[1].Content)
+	require.Equal(t, q.ID, *q.Children[1].RootID)
+
+	threads, err := store.ListThreads()
+	require.NoError(t, err)
+	require.Len(t, threads, 1, "the failed conversation leaves nothing behind")
+}
+
+func TestImportOpenAIMessages(t *testing.T) {
+	store := memory.New()
+	body := `[{"role": "system", "content": "Be brief."}, {"role": "user", "content": [{"type": "text", "text": "Name a fern"}]}, {"role": "assistant", "content": "Bracken."}]
+{"title": "Second", "messages": [{"role": "user", "content": "hi"}]}
+{"messages": [{"content": "no role"}]}`
+	report, err := importer.Import(store, strings.NewReader(body))
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Imported)
+	require.Equal(t, 1, report.Failed)
+	require.Equal(t, "Name a fern", report.Results[0].Title)
+	require.Equal(t, "Second", report.Results[1].Title)
+	require.Contains(t, report.Results[2].Error, "no role")
+
+	_, tree := threadMessages(t, store, report.Results[0].ThreadID)
+	require.Len(t, tree, 1)
+	var chain []string
+	for n := tree[0]; n != nil; {
+		chain = append(chain, n.Role+": "+n.Content)
+		if len(n.Children) == 0 {
+			break
+		}
+		require.Greater(t, n.Children[0].Timestamp, n.Timestamp)
+		n = n.Children[0]
+	}
+	require.Equal(t, []string{"system: Be brief.", "user: Name a fern", "assistant: Bracken."}, chain)
+}
+
+func TestImportRoundTripsThreadWellExport(t *testing.T) {
+	q, a2 := "q", "a2"
+	temperature := 0.3
+	thread := models.Thread{ID: "t1", Title: "Plants", CreatedAt: 10, UpdatedAt: 40,
+		Model: "botanist", SystemPrompt: "Be practical.", Temperature: &temperature, MaxTokens: 300}
+	msgs := []models.Message{
+		{ID: "q", ThreadID: "t1", Role: "user", Content: "Water?", Timestamp: 10},
+		{ID: "a1", ThreadID: "t1", ParentID: &q, RootID: &q, Role: "assistant", Content: "Weekly.", Timestamp: 20},
+		{ID: "a2", ThreadID: "t1", ParentID: &q, RootID: &q, Role: "assistant", Content: "Often.", Timestamp: 30},
+		{ID: "q2", ThreadID: "t1", ParentID: &a2, RootID: &q, Role: "user", Content: "Cacti?", Timestamp: 40},
+	}
+	var first bytes.Buffer
+	require.NoError(t, export.Write(&first, export.FormatJSON, thread, msgs))
+
+	store := memory.New()
+	report, err := importer.Import(store, bytes.NewReader(first.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Imported)
+	require.Equal(t, importer.SourceThreadWell, report.Results[0].Source)
+
+	imported, err := store.GetThread(report.Results[0].ThreadID)
+	require.NoError(t, err)
+	require.NotEqual(t, "t1", imported.ID)
+	require.Equal(t, int64(10), imported.CreatedAt)
+	require.Equal(t, "botanist", imported.Model)
+	require.Equal(t, "Be practical.", imported.SystemPrompt)
+	require.Equal(t, 0.3, *imported.Temperature)
+	require.Equal(t, 300, imported.MaxTokens)
+	got, err := store.ListMessages(imported.ID)
+	require.NoError(t, err)
+
+	// Roots point at themselves, like the roots of moved subtrees.
+	for _, m := range got {
+		require.NotNil(t, m.RootID, m.Content)
+		if m.ParentID == nil {
+			require.Equal(t, m.ID, *m.RootID)
+		}
+	}
+
+	// Exporting the imported thread gives the same tree, up to IDs.
+	var second bytes.Buffer
+	require.NoError(t, export.Write(&second, export.FormatMarkdown, *imported, got))
+	var want bytes.Buffer
+	require.NoError(t, export.Write(&want, export.FormatMarkdown, thread, msgs))
+	require.Equal(t, want.String(), second.String())
+	for _, m := range got {
+		require.Contains(t, []int64{10, 20, 30, 40}, m.Timestamp)
+	}
+}
+
+func TestImportRejectsInvalidInput(t *testing.T) {
+	for name, body := range map[string]string{
+		"not json":     `{"title": `,
+		"empty":        ``,
+		"unrecognised": `{"title": "x"}`,
+		"scalar":       `42`,
+	} {
+		_, err := importer.Import(memory.New(), strings.NewReader(body))
+		require.ErrorIs(t, err, importer.ErrInvalidInput, name)
+	}
+}
+
+func TestImportValidatesThreadWellDocuments(t *testing.T) {
+	body := `{"format": "threadwell", "version": 9, "thread": {"title": "future"}, "messages": [{"id": "a", "role": "user", "content": "x"}]}
+{"format": "threadwell", "version": 1, "thread": {"title": "orphan"}, "messages": [{"id": "a", "parent_id": "gone", "role": "user", "content": "x"}]}`
+	report, err := importer.Import(memory.New(), strings.NewReader(body))
+	require.NoError(t, err)
+	require.Equal(t, 0, report.Imported)
+	require.Contains(t, report.Results[0].Error, "version")
+	require.Contains(t, report.Results[1].Error, "unknown parent")
+}
@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/krackenservices/threadwell/models"
+)
+
+// Message is a single chat turn sent to a model.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Request is the prompt passed to a provider, oldest message first.
+// Temperature and MaxTokens tune the reply; nil and 0 leave the model's
+// defaults.
+type Request struct {
+	Messages    []Message
+	Temperature *float64
+	MaxTokens   int
+}
+
+// Response is the completed reply returned by a provider.
+type Response struct {
+	Content string
+}
+
+// Provider generates assistant replies for a chat history.
+type Provider interface {
+	Complete(ctx context.Context, req Request) (Response, error)
+}
+
+// DefaultTimeout bounds a single completion call against a remote provider,
+// and how long a streamed reply may take to start. A stream that has started
+// runs until it ends or the request context is cancelled.
+const DefaultTimeout = 120 * time.Second
+
+// newClient returns the HTTP client for remote providers. It has no overall
+// timeout, which would also cut off long streams; Complete bounds its call
+// with DefaultTimeout instead.
+func newClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = DefaultTimeout
+	return &http.Client{Transport: transport}
+}
+
+// NewProvider returns the provider selected by the stored settings.
+// SimulateOnly always wins so the backend can run fully offline.
+func NewProvider(cfg models.Settings) (Provider, error) {
+	if cfg.SimulateOnly {
+		return NewSimulated(), nil
+	}
+
+	client := newClient()
+	switch cfg.LLMProvider {
+	case "ollama":
+		return NewOllama(cfg.LLMEndpoint, cfg.LLMName, client), nil
+	case "openai":
+		return NewOpenAI(cfg.LLMEndpoint, cfg.LLMName, cfg.LLMApiKey, client), nil
+	case "simulator":
+		return NewSimulated(), nil
+	default:
+		return nil, fmt.Errorf("unsupported llm provider: %q", cfg.LLMProvider)
+	}
+}
+
+// FromMessages converts stored messages into the provider prompt format.
+func FromMessages(msgs []models.Message) []Message {
+	out := make([]Message, 0, len(msgs))
+	for _, m := range msgs {
+		out = append(out, Message{Role: m.Role, Content: m.Content})
+	}
+	return out
+}
+
+// Supported reports whether NewProvider accepts the llm_provider name.
+func Supported(name string) bool {
+	switch name {
+	case "ollama", "openai", "simulator":
+		return true
+	}
+	return false
+}
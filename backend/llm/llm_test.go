@@ -0,0 +1,190 @@
+package llm_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/krackenservices/threadwell/llm"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProviderSelectsImplementation(t *testing.T) {
+	p, err := llm.NewProvider(models.Settings{LLMProvider: "openai", SimulateOnly: true})
+	require.NoError(t, err)
+	require.IsType(t, &llm.Simulated{}, p)
+
+	p, err = llm.NewProvider(models.Settings{LLMProvider: "ollama"})
+	require.NoError(t, err)
+	require.IsType(t, &llm.Ollama{}, p)
+
+	p, err = llm.NewProvider(models.Settings{LLMProvider: "openai"})
+	require.NoError(t, err)
+	require.IsType(t, &llm.OpenAI{}, p)
+
+	_, err = llm.NewProvider(models.Settings{LLMProvider: "unknown"})
+	require.Error(t, err)
+}
+
+func TestSimulatedEchoesLastMessage(t *testing.T) {
+	res, err := llm.NewSimulated().Complete(context.Background(), llm.Request{
+		Messages: []llm.Message{
+			{Role: "user", Content: "first"},
+			{Role: "user", Content: " hello\nworld "},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "**(Simulated)** You said: hello world", res.Content)
+}
+
+func TestOllamaComplete(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/chat", r.URL.Path)
+
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		require.Equal(t, "qwen", body["model"])
+		require.Equal(t, false, body["stream"])
+		require.NotContains(t, body, "options")
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": map[string]string{"role": "assistant", "content": "hi from ollama"},
+		})
+	}))
+	defer srv.Close()
+
+	p := llm.NewOllama(srv.URL+"/", "qwen", srv.Client())
+	res, err := p.Complete(context.Background(), llm.Request{
+		Messages: []llm.Message{{Role: "user", Content: "hi"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "hi from ollama", res.Content)
+}
+
+func TestOllamaModelNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	_, err := llm.NewOllama(srv.URL, "missing", srv.Client()).Complete(context.Background(), llm.Request{})
+	require.ErrorContains(t, err, "model not found")
+}
+
+func TestOpenAICompleteSendsKey(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{
+				{"message": map[string]string{"role": "assistant", "content": "hi from openai"}},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	p := llm.NewOpenAI(srv.URL, "", "secret", srv.Client())
+	res, err := p.Complete(context.Background(), llm.Request{
+		Messages: []llm.Message{{Role: "user", Content: "hi"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "hi from openai", res.Content)
+}
+
+func TestRequestTuningIsSent(t *testing.T) {
+	var body map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": map[string]string{"role": "assistant", "content": "ok"},
+			"choices": []map[string]interface{}{{"message": map[string]string{"role": "assistant", "content": "ok"}}},
+		})
+	}))
+	defer srv.Close()
+
+	temperature := 0.0
+	req := llm.Request{Messages: []llm.Message{{Role: "user", Content: "hi"}}, Temperature: &temperature, MaxTokens: 64}
+
+	_, err := llm.NewOllama(srv.URL, "qwen", srv.Client()).Complete(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"temperature": 0.0, "num_predict": 64.0}, body["options"])
+
+	_, err = llm.NewOpenAI(srv.URL, "gpt", "", srv.Client()).Complete(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, 0.0, body["temperature"])
+	require.Equal(t, 64.0, body["max_tokens"])
+}
+
+func TestOpenAIErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	_, err := llm.NewOpenAI(srv.URL, "", "bad", srv.Client()).Complete(context.Background(), llm.Request{})
+	require.Error(t, err)
+}
+
+func TestOllamaStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		require.Equal(t, true, body["stream"])
+
+		_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"Hel"},"done":false}
+{"message":{"role":"assistant","content":"lo"},"done":false}
+{"message":{"role":"assistant","content":""},"done":true}
+`))
+	}))
+	defer srv.Close()
+
+	var deltas []string
+	res, err := llm.Stream(context.Background(), llm.NewOllama(srv.URL, "", srv.Client()), llm.Request{},
+		func(d string) error {
+			deltas = append(deltas, d)
+			return nil
+		})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Hel", "lo"}, deltas)
+	require.Equal(t, "Hello", res.Content)
+}
+
+func TestOpenAIStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n" +
+			"data: {\"choices\":[{\"delta\":{\"content\":\"Hi \"}}]}\n\n" +
+			"data: {\"choices\":[{\"delta\":{\"content\":\"there\"}}]}\n\n" +
+			"data: [DONE]\n\n"))
+	}))
+	defer srv.Close()
+
+	var deltas []string
+	res, err := llm.Stream(context.Background(), llm.NewOpenAI(srv.URL, "", "", srv.Client()), llm.Request{},
+		func(d string) error {
+			deltas = append(deltas, d)
+			return nil
+		})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Hi ", "there"}, deltas)
+	require.Equal(t, "Hi there", res.Content)
+}
+
+func TestSimulatedStreamMatchesComplete(t *testing.T) {
+	req := llm.Request{Messages: []llm.Message{{Role: "user", Content: "one two"}}}
+	full, err := llm.NewSimulated().Complete(context.Background(), req)
+	require.NoError(t, err)
+
+	var sb strings.Builder
+	res, err := llm.Stream(context.Background(), llm.NewSimulated(), req, func(d string) error {
+		sb.WriteString(d)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, full.Content, res.Content)
+	require.Equal(t, full.Content, sb.String())
+}
@@ -0,0 +1,158 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const defaultOllamaModel = "llama3"
+
+// Ollama talks to a local Ollama server via /api/chat.
+type Ollama struct {
+	endpoint string
+	model    string
+	client   *http.Client
+}
+
+func NewOllama(endpoint, model string, client *http.Client) *Ollama {
+	if model == "" {
+		model = defaultOllamaModel
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Ollama{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		model:    model,
+		client:   client,
+	}
+}
+
+type ollamaChatRequest struct {
+	Model    string         `json:"model"`
+	Messages []Message      `json:"messages"`
+	Stream   bool           `json:"stream"`
+	Options  *ollamaOptions `json:"options,omitempty"`
+}
+
+type ollamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Message *Message `json:"message"`
+	Done    bool     `json:"done"`
+	Error   string   `json:"error"`
+}
+
+// post sends a chat request and returns the response once the status is OK.
+// A nil response with no error means the server had nothing to say.
+func (o *Ollama) post(ctx context.Context, req Request, stream bool) (*http.Response, error) {
+	chat := ollamaChatRequest{
+		Model:    o.model,
+		Messages: req.Messages,
+		Stream:   stream,
+	}
+	if req.Temperature != nil || req.MaxTokens > 0 {
+		chat.Options = &ollamaOptions{Temperature: req.Temperature, NumPredict: req.MaxTokens}
+	}
+	body, err := json.Marshal(chat)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	res, err := o.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("ollama request failed: %w", err)
+	}
+	if res.StatusCode == http.StatusOK {
+		return res, nil
+	}
+	_ = res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("ollama: model not found")
+	case http.StatusServiceUnavailable:
+		return nil, fmt.Errorf("ollama: service unavailable")
+	default:
+		return nil, fmt.Errorf("ollama request failed: status %d", res.StatusCode)
+	}
+}
+
+func (o *Ollama) Complete(ctx context.Context, req Request) (Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+	res, err := o.post(ctx, req, false)
+	if err != nil {
+		return Response{}, err
+	}
+	if res == nil {
+		return Response{Content: "[No response]"}, nil
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	var data ollamaChatResponse
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return Response{}, fmt.Errorf("ollama: invalid response: %w", err)
+	}
+	if data.Message == nil || data.Message.Content == "" {
+		return Response{Content: "[No response]"}, nil
+	}
+	return Response{Content: data.Message.Content}, nil
+}
+
+// Stream reads Ollama's newline-delimited JSON chunks until done.
+func (o *Ollama) Stream(ctx context.Context, req Request, onDelta DeltaFunc) (Response, error) {
+	res, err := o.post(ctx, req, true)
+	if err != nil {
+		return Response{}, err
+	}
+	if res == nil {
+		return Response{Content: "[No response]"}, onDelta("[No response]")
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	var sb strings.Builder
+	dec := json.NewDecoder(res.Body)
+	for {
+		var chunk ollamaChatResponse
+		if err := dec.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return Response{Content: sb.String()}, fmt.Errorf("ollama: invalid stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return Response{Content: sb.String()}, fmt.Errorf("ollama: %s", chunk.Error)
+		}
+		if chunk.Message != nil && chunk.Message.Content != "" {
+			sb.WriteString(chunk.Message.Content)
+			if err := onDelta(chunk.Message.Content); err != nil {
+				return Response{Content: sb.String()}, err
+			}
+		}
+		if chunk.Done {
+			break
+		}
+	}
+	return Response{Content: sb.String()}, nil
+}
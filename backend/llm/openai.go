@@ -0,0 +1,153 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const defaultOpenAIModel = "gpt-3.5-turbo"
+
+// OpenAI talks to any OpenAI-compatible chat completions endpoint.
+// The endpoint is the full URL, e.g. https://api.openai.com/v1/chat/completions.
+type OpenAI struct {
+	endpoint string
+	model    string
+	apiKey   string
+	client   *http.Client
+}
+
+func NewOpenAI(endpoint, model, apiKey string, client *http.Client) *OpenAI {
+	if model == "" {
+		model = defaultOpenAIModel
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &OpenAI{
+		endpoint: endpoint,
+		model:    model,
+		apiKey:   apiKey,
+		client:   client,
+	}
+}
+
+type openAIChatRequest struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Stream      bool      `json:"stream,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
+}
+
+type openAIChatResponse struct {
+	Choices []struct {
+		Message Message `json:"message"`
+	} `json:"choices"`
+}
+
+type openAIStreamChunk struct {
+	Choices []struct {
+		Delta Message `json:"delta"`
+	} `json:"choices"`
+}
+
+// post sends a chat completion request and returns the OK response.
+func (o *OpenAI) post(ctx context.Context, req Request, stream bool) (*http.Response, error) {
+	body, err := json.Marshal(openAIChatRequest{
+		Model:       o.model,
+		Messages:    req.Messages,
+		Stream:      stream,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	res, err := o.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("openai request failed: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		_ = res.Body.Close()
+		return nil, fmt.Errorf("openai request failed: status %d", res.StatusCode)
+	}
+	return res, nil
+}
+
+func (o *OpenAI) Complete(ctx context.Context, req Request) (Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+	res, err := o.post(ctx, req, false)
+	if err != nil {
+		return Response{}, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	var data openAIChatResponse
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return Response{}, fmt.Errorf("openai: invalid response: %w", err)
+	}
+	if len(data.Choices) == 0 || data.Choices[0].Message.Content == "" {
+		return Response{Content: "[No response]"}, nil
+	}
+	return Response{Content: data.Choices[0].Message.Content}, nil
+}
+
+// Stream reads the server-sent "data:" lines of a streamed completion.
+func (o *OpenAI) Stream(ctx context.Context, req Request, onDelta DeltaFunc) (Response, error) {
+	res, err := o.post(ctx, req, true)
+	if err != nil {
+		return Response{}, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	var sb strings.Builder
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return Response{Content: sb.String()}, fmt.Errorf("openai: invalid stream chunk: %w", err)
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		delta := chunk.Choices[0].Delta.Content
+		sb.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return Response{Content: sb.String()}, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Response{Content: sb.String()}, fmt.Errorf("openai: stream read failed: %w", err)
+	}
+	return Response{Content: sb.String()}, nil
+}
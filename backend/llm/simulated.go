@@ -0,0 +1,26 @@
+package llm
+
+import (
+	"context"
+	"strings"
+)
+
+// Simulated echoes the last message back without calling a model.
+// It mirrors the frontend's SimulateOnly behaviour.
+type Simulated struct{}
+
+func NewSimulated() *Simulated {
+	return &Simulated{}
+}
+
+func (s *Simulated) Complete(ctx context.Context, req Request) (Response, error) {
+	if err := ctx.Err(); err != nil {
+		return Response{}, err
+	}
+	last := "unknown"
+	if n := len(req.Messages); n > 0 && req.Messages[n-1].Content != "" {
+		last = req.Messages[n-1].Content
+	}
+	last = strings.TrimSpace(strings.ReplaceAll(last, "\n", " "))
+	return Response{Content: "**(Simulated)** You said: " + last}, nil
+}
@@ -0,0 +1,55 @@
+package llm
+
+import (
+	"context"
+	"strings"
+)
+
+// DeltaFunc receives each chunk of content as it arrives. Returning an error
+// aborts the stream.
+type DeltaFunc func(delta string) error
+
+// Streamer is implemented by providers that can emit partial output.
+type Streamer interface {
+	Stream(ctx context.Context, req Request, onDelta DeltaFunc) (Response, error)
+}
+
+// Stream emits the reply through onDelta and returns the full content. Providers
+// without native streaming deliver their whole reply as a single delta.
+// On error the returned Response holds whatever content arrived before it.
+func Stream(ctx context.Context, p Provider, req Request, onDelta DeltaFunc) (Response, error) {
+	if s, ok := p.(Streamer); ok {
+		return s.Stream(ctx, req, onDelta)
+	}
+	res, err := p.Complete(ctx, req)
+	if err != nil {
+		return Response{}, err
+	}
+	if err := onDelta(res.Content); err != nil {
+		return Response{}, err
+	}
+	return res, nil
+}
+
+// Stream replays the simulated reply one word at a time.
+func (s *Simulated) Stream(ctx context.Context, req Request, onDelta DeltaFunc) (Response, error) {
+	full, err := s.Complete(ctx, req)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var sb strings.Builder
+	for _, word := range strings.SplitAfter(full.Content, " ") {
+		if err := ctx.Err(); err != nil {
+			return Response{Content: sb.String()}, err
+		}
+		if word == "" {
+			continue
+		}
+		sb.WriteString(word)
+		if err := onDelta(word); err != nil {
+			return Response{Content: sb.String()}, err
+		}
+	}
+	return Response{Content: sb.String()}, nil
+}
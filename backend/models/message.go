@@ -9,3 +9,13 @@ type Message struct {
 	Content   string  `json:"content"`
 	Timestamp int64   `json:"timestamp"`
 }
+
+// MessageRevision is a previous version of a message, recorded on every edit.
+type MessageRevision struct {
+	ID        string `json:"id"`
+	MessageID string `json:"message_id"`
+	Version   int    `json:"version"` // 1 is the original text
+	Role      string `json:"role"`
+	Content   string `json:"content"`
+	Timestamp int64  `json:"timestamp"` // when this version was replaced
+}
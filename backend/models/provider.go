@@ -0,0 +1,16 @@
+package models
+
+// ProviderProfile is a named LLM configuration. A user may keep several and
+// mark one as their default; threads and single replies can pick another.
+type ProviderProfile struct {
+	ID          string `json:"id"`
+	OwnerID     string `json:"owner_id,omitempty"` // user who owns it; empty in local mode
+	Name        string `json:"name"`
+	LLMProvider string `json:"llm_provider"`          // e.g. "ollama", "openai"
+	LLMEndpoint string `json:"llm_endpoint"`          // http://localhost:11434 etc.
+	LLMApiKey   string `json:"llm_api_key,omitempty"` // never returned by the API
+	LLMName     string `json:"llm_model"`             // name of model to use
+	IsDefault   bool   `json:"is_default"`
+	CreatedAt   int64  `json:"created_at"`
+	UpdatedAt   int64  `json:"updated_at"`
+}
@@ -1,7 +1,7 @@
 package models
 
 type Settings struct {
-	ID           string `json:"id"`                    // always "default"
+	ID           string `json:"id"`                    // owning user ID, or "default" in local mode
 	LLMProvider  string `json:"llm_provider"`          // e.g. "ollama", "openai", "claude"
 	LLMEndpoint  string `json:"llm_endpoint"`          // http://localhost:11434 etc.
 	LLMApiKey    string `json:"llm_api_key,omitempty"` // (optional, not returned on GET)
@@ -4,4 +4,14 @@ type Thread struct {
 	ID        string `json:"id"`
 	Title     string `json:"title"`
 	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`         // last title change or new message
+	OwnerID   string `json:"owner_id,omitempty"` // user who owns it; empty in local mode
+
+	// Reply settings. Empty values fall back to the provider profile and
+	// then to the provider's own defaults; branches inherit them.
+	ProviderID   string   `json:"provider_id,omitempty"`   // profile to use; empty means the owner's default
+	Model        string   `json:"model,omitempty"`         // overrides the profile's model
+	SystemPrompt string   `json:"system_prompt,omitempty"` // sent ahead of the conversation
+	Temperature  *float64 `json:"temperature,omitempty"`   // sampling temperature, 0 to 2
+	MaxTokens    int      `json:"max_tokens,omitempty"`    // reply length limit
 }
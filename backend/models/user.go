@@ -0,0 +1,30 @@
+package models
+
+// User is an account that owns threads and settings.
+type User struct {
+	ID           string `json:"id"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash,omitempty"` // bcrypt; never returned by the API
+	CreatedAt    int64  `json:"created_at"`
+}
+
+// Session is a bearer token issued at login. Only a hash of the token is
+// stored, so a leaked database does not leak usable tokens.
+type Session struct {
+	ID        string `json:"id"` // hex SHA-256 of the token
+	UserID    string `json:"user_id"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"` // 0 means it never expires
+}
+
+// AccessToken is a personal access token for scripts and integrations. Like
+// a session only its hash is stored; unlike one it is limited to Scopes.
+type AccessToken struct {
+	ID        string   `json:"id"`
+	UserID    string   `json:"user_id"`
+	Name      string   `json:"name"`
+	TokenHash string   `json:"token_hash,omitempty"` // hex SHA-256; never returned by the API
+	Scopes    []string `json:"scopes"`
+	CreatedAt int64    `json:"created_at"`
+	ExpiresAt int64    `json:"expires_at"` // 0 means it never expires
+}
@@ -0,0 +1,271 @@
+// Package secrets encrypts the LLM API keys kept in settings and provider
+// profiles, so a copy of the database or its snapshot does not reveal them.
+// Keys are sealed with AES-256-GCM under a server-side master key.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+// KeySize is the length of a master key in bytes.
+const KeySize = 32
+
+// sealedPrefix marks an encrypted value; anything else is plaintext written
+// before encryption was introduced.
+const sealedPrefix = "enc:v1:"
+
+// ErrWrongKey is returned when a sealed value cannot be opened, usually
+// because the master key changed.
+var ErrWrongKey = errors.New("secrets: value cannot be decrypted with this master key")
+
+// NewKey returns a random master key.
+func NewKey() []byte {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		panic(err) // crypto/rand does not fail on supported platforms
+	}
+	return key
+}
+
+// ParseKey decodes a master key given as base64 or hex.
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	for _, decode := range []func(string) ([]byte, error){
+		base64.StdEncoding.DecodeString,
+		base64.RawStdEncoding.DecodeString,
+		base64.URLEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+		hex.DecodeString,
+	} {
+		if key, err := decode(s); err == nil && len(key) == KeySize {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("secrets: master key must be %d bytes, base64 or hex encoded", KeySize)
+}
+
+// LoadKey returns the master key given directly, or else the one in path.
+// A missing key file is created with a new random key. It returns nil when
+// neither is set.
+func LoadKey(key, path string) ([]byte, error) {
+	if key != "" {
+		return ParseKey(key)
+	}
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := NewKey()
+		encoded := base64.StdEncoding.EncodeToString(key) + "\n"
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.WriteString(encoded); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return key, f.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseKey(string(data))
+}
+
+// Box seals and opens values under one master key.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox returns a Box for a KeySize-byte master key.
+func NewBox(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("secrets: master key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Sealed reports whether s was produced by Seal.
+func Sealed(s string) bool {
+	return strings.HasPrefix(s, sealedPrefix)
+}
+
+// Seal encrypts plaintext. The value can only be opened with the same
+// context, which ties it to the record it is stored in.
+func (b *Box) Seal(plaintext, context string) string {
+	nonce := make([]byte, b.aead.NonceSize(), b.aead.NonceSize()+len(plaintext)+b.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	out := b.aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(out)
+}
+
+// Open decrypts a value from Seal. Plaintext values are returned unchanged.
+func (b *Box) Open(value, context string) (string, error) {
+	if !Sealed(value) {
+		return value, nil
+	}
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil || len(data) < b.aead.NonceSize() {
+		return "", ErrWrongKey
+	}
+	n := b.aead.NonceSize()
+	plain, err := b.aead.Open(nil, data[:n], data[n:], []byte(context))
+	if err != nil {
+		return "", ErrWrongKey
+	}
+	return string(plain), nil
+}
+
+// Encrypt returns a view of s that seals LLM API keys as settings and
+// provider profiles are stored and opens them as they are read. Everything
+// else passes through. Keys written through it are always sealed, even ones
+// that look sealed already: they come from clients, and a value stored as is
+// would otherwise be taken for ciphertext.
+func Encrypt(s storage.Storage, b *Box) storage.Storage {
+	return &encrypted{Storage: s, box: b}
+}
+
+type encrypted struct {
+	storage.Storage
+	box *Box
+}
+
+func (e *encrypted) GetSettings(id string) (*models.Settings, error) {
+	cfg, err := e.Storage.GetSettings(id)
+	if err != nil || cfg == nil {
+		return cfg, err
+	}
+	if cfg.LLMApiKey, err = e.box.Open(cfg.LLMApiKey, cfg.ID); err != nil {
+		return nil, fmt.Errorf("settings %s: %w", cfg.ID, err)
+	}
+	return cfg, nil
+}
+
+func (e *encrypted) UpdateSettings(cfg models.Settings) error {
+	if cfg.LLMApiKey != "" {
+		cfg.LLMApiKey = e.box.Seal(cfg.LLMApiKey, cfg.ID)
+	}
+	return e.Storage.UpdateSettings(cfg)
+}
+
+func (e *encrypted) ListProviderProfiles(ownerID string) ([]models.ProviderProfile, error) {
+	profiles, err := e.Storage.ListProviderProfiles(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range profiles {
+		if profiles[i].LLMApiKey, err = e.box.Open(profiles[i].LLMApiKey, profiles[i].ID); err != nil {
+			return nil, fmt.Errorf("provider %s: %w", profiles[i].ID, err)
+		}
+	}
+	return profiles, nil
+}
+
+func (e *encrypted) GetProviderProfile(id string) (*models.ProviderProfile, error) {
+	p, err := e.Storage.GetProviderProfile(id)
+	if err != nil || p == nil {
+		return p, err
+	}
+	if p.LLMApiKey, err = e.box.Open(p.LLMApiKey, p.ID); err != nil {
+		return nil, fmt.Errorf("provider %s: %w", p.ID, err)
+	}
+	return p, nil
+}
+
+func (e *encrypted) CreateProviderProfile(p models.ProviderProfile) error {
+	return e.Storage.CreateProviderProfile(e.sealProfile(p))
+}
+
+func (e *encrypted) UpdateProviderProfile(p models.ProviderProfile) error {
+	return e.Storage.UpdateProviderProfile(e.sealProfile(p))
+}
+
+func (e *encrypted) sealProfile(p models.ProviderProfile) models.ProviderProfile {
+	if p.LLMApiKey != "" {
+		p.LLMApiKey = e.box.Seal(p.LLMApiKey, p.ID)
+	}
+	return p
+}
+
+// EncryptStored seals every plaintext API key in settings and provider
+// profiles and checks that the sealed ones open with b, so a wrong master
+// key is caught at startup. It returns how many keys it sealed.
+func EncryptStored(s storage.Storage, b *Box) (int, error) {
+	users, err := s.ListUsers()
+	if err != nil {
+		return 0, err
+	}
+	ids := []string{storage.DefaultSettingsID}
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+
+	sealed := 0
+	for _, id := range ids {
+		cfg, err := s.GetSettings(id)
+		if err != nil {
+			return sealed, err
+		}
+		if cfg == nil || cfg.LLMApiKey == "" {
+			continue
+		}
+		if Sealed(cfg.LLMApiKey) {
+			if _, err := b.Open(cfg.LLMApiKey, cfg.ID); err != nil {
+				return sealed, fmt.Errorf("settings %s: %w", cfg.ID, err)
+			}
+			continue
+		}
+		cfg.LLMApiKey = b.Seal(cfg.LLMApiKey, cfg.ID)
+		if err := s.UpdateSettings(*cfg); err != nil {
+			return sealed, err
+		}
+		sealed++
+	}
+
+	for _, owner := range append([]string{""}, ids[1:]...) {
+		profiles, err := s.ListProviderProfiles(owner)
+		if err != nil {
+			return sealed, err
+		}
+		for _, p := range profiles {
+			if p.LLMApiKey == "" {
+				continue
+			}
+			if Sealed(p.LLMApiKey) {
+				if _, err := b.Open(p.LLMApiKey, p.ID); err != nil {
+					return sealed, fmt.Errorf("provider %s: %w", p.ID, err)
+				}
+				continue
+			}
+			p.LLMApiKey = b.Seal(p.LLMApiKey, p.ID)
+			if err := s.UpdateProviderProfile(p); err != nil {
+				return sealed, err
+			}
+			sealed++
+		}
+	}
+	return sealed, nil
+}
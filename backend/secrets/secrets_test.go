@@ -0,0 +1,132 @@
+package secrets_test
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/secrets"
+	"github.com/krackenservices/threadwell/storage"
+	"github.com/krackenservices/threadwell/storage/memory"
+)
+
+func TestParseAndLoadKey(t *testing.T) {
+	key := secrets.NewKey()
+	for _, encoded := range []string{
+		base64.StdEncoding.EncodeToString(key),
+		base64.RawURLEncoding.EncodeToString(key),
+		hex.EncodeToString(key) + "\n",
+	} {
+		got, err := secrets.ParseKey(encoded)
+		require.NoError(t, err)
+		require.Equal(t, key, got)
+	}
+	_, err := secrets.ParseKey("too short")
+	require.Error(t, err)
+
+	got, err := secrets.LoadKey("", "")
+	require.NoError(t, err)
+	require.Nil(t, got)
+
+	// A missing key file is created and read back on the next start.
+	path := filepath.Join(t.TempDir(), "master.key")
+	created, err := secrets.LoadKey("", path)
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	again, err := secrets.LoadKey("", path)
+	require.NoError(t, err)
+	require.Equal(t, created, again)
+}
+
+func TestEncryptSettings(t *testing.T) {
+	base := memory.New()
+	box, err := secrets.NewBox(secrets.NewKey())
+	require.NoError(t, err)
+	store := secrets.Encrypt(base, box)
+
+	cfg := models.Settings{ID: storage.DefaultSettingsID, LLMProvider: "openai", LLMApiKey: "sk-secret"}
+	require.NoError(t, store.UpdateSettings(cfg))
+
+	raw, err := base.GetSettings(storage.DefaultSettingsID)
+	require.NoError(t, err)
+	require.True(t, secrets.Sealed(raw.LLMApiKey))
+	require.NotContains(t, raw.LLMApiKey, "sk-secret")
+
+	got, err := store.GetSettings(storage.DefaultSettingsID)
+	require.NoError(t, err)
+	require.Equal(t, "sk-secret", got.LLMApiKey)
+
+	// A sealed key copied to another record does not open there.
+	moved := *raw
+	moved.ID = "someone-else"
+	require.NoError(t, base.UpdateSettings(moved))
+	_, err = store.GetSettings("someone-else")
+	require.ErrorIs(t, err, secrets.ErrWrongKey)
+
+	// Nor under another master key.
+	other, err := secrets.NewBox(secrets.NewKey())
+	require.NoError(t, err)
+	_, err = secrets.Encrypt(base, other).GetSettings(storage.DefaultSettingsID)
+	require.ErrorIs(t, err, secrets.ErrWrongKey)
+
+	// A client cannot plant ciphertext: keys that look sealed are sealed
+	// again and read back as written.
+	cfg.LLMApiKey = moved.LLMApiKey
+	require.NoError(t, store.UpdateSettings(cfg))
+	got, err = store.GetSettings(storage.DefaultSettingsID)
+	require.NoError(t, err)
+	require.Equal(t, moved.LLMApiKey, got.LLMApiKey)
+
+	profile := models.ProviderProfile{ID: "p1", Name: "planted", LLMProvider: "openai", LLMApiKey: "enc:v1:bogus"}
+	require.NoError(t, store.CreateProviderProfile(profile))
+	gotProfile, err := store.GetProviderProfile("p1")
+	require.NoError(t, err)
+	require.Equal(t, "enc:v1:bogus", gotProfile.LLMApiKey)
+	rawProfile, err := base.GetProviderProfile("p1")
+	require.NoError(t, err)
+	require.NotEqual(t, "enc:v1:bogus", rawProfile.LLMApiKey)
+}
+
+func TestEncryptStored(t *testing.T) {
+	base := memory.New()
+	require.NoError(t, base.CreateUser(models.User{ID: "u1", Username: "alice", PasswordHash: "x"}))
+	require.NoError(t, base.UpdateSettings(models.Settings{ID: storage.DefaultSettingsID, LLMApiKey: "sk-local"}))
+	require.NoError(t, base.UpdateSettings(models.Settings{ID: "u1", LLMApiKey: "sk-alice"}))
+	require.NoError(t, base.CreateProviderProfile(models.ProviderProfile{ID: "p1", OwnerID: "u1", Name: "hosted", LLMApiKey: "sk-profile"}))
+
+	box, err := secrets.NewBox(secrets.NewKey())
+	require.NoError(t, err)
+	n, err := secrets.EncryptStored(base, box)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	raw, err := base.GetSettings("u1")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(raw.LLMApiKey, "enc:"))
+	got, err := secrets.Encrypt(base, box).GetSettings("u1")
+	require.NoError(t, err)
+	require.Equal(t, "sk-alice", got.LLMApiKey)
+	rawProfile, err := base.GetProviderProfile("p1")
+	require.NoError(t, err)
+	require.True(t, secrets.Sealed(rawProfile.LLMApiKey))
+	profile, err := secrets.Encrypt(base, box).GetProviderProfile("p1")
+	require.NoError(t, err)
+	require.Equal(t, "sk-profile", profile.LLMApiKey)
+
+	n, err = secrets.EncryptStored(base, box)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	other, err := secrets.NewBox(secrets.NewKey())
+	require.NoError(t, err)
+	_, err = secrets.EncryptStored(base, other)
+	require.ErrorIs(t, err, secrets.ErrWrongKey)
+}
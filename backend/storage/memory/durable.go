@@ -0,0 +1,486 @@
+package memory
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/krackenservices/threadwell/models"
+)
+
+// Durable mode keeps the maps in memory but makes every mutation survive a
+// restart. Each call that changes state produces a batch of record-level
+// changes; the batch is appended to the write-ahead log and synced before it
+// is applied, so a caller never sees success for a change that is not on
+// disk. Every snapshotEvery batches the whole state is written to a snapshot
+// and the log is emptied.
+//
+// Changes set or remove whole records, so replaying a log over a snapshot
+// that already contains some of it gives the same state. That makes a crash
+// between writing a snapshot and truncating the log harmless.
+//
+// Log records are framed as a 4-byte length, a 4-byte CRC-32 of the payload
+// and the JSON payload. A short or corrupt final record is the remains of a
+// write interrupted by a crash: replay stops there and cuts it off. A write
+// that fails while the process keeps running is cut off straight away, so
+// later batches never land behind a torn record; if that fails too, the store
+// refuses further changes.
+
+// snapshotEvery is how many log batches trigger a compaction.
+const snapshotEvery = 1000
+
+// snapshotVersion identifies the snapshot layout. Version 1 held a single
+// settings record and no accounts.
+const snapshotVersion = 2
+
+// walSuffix is appended to the snapshot path to name the log.
+const walSuffix = ".wal"
+
+// maxRecordSize bounds a log record's payload. Replay treats a larger length
+// as a damaged header rather than allocating it.
+const maxRecordSize = 256 << 20
+
+// logFile is the part of *os.File the log is written through.
+type logFile interface {
+	io.Writer
+	io.Seeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// change kinds
+const (
+	opPutThread      = "put_thread"
+	opDeleteThread   = "delete_thread"
+	opPutMessage     = "put_message"
+	opDeleteMessage  = "delete_message" // also drops the message's revisions
+	opSetRevisions   = "set_revisions"  // empty Revisions removes them
+	opUpdateSettings = "update_settings"
+	opPutUser        = "put_user"
+	opPutSession     = "put_session"
+	opDeleteSession  = "delete_session"
+	opPutToken       = "put_token"
+	opDeleteToken    = "delete_token"
+	opPutProvider    = "put_provider"
+	opDeleteProvider = "delete_provider"
+)
+
+// change is one record-level mutation.
+type change struct {
+	Op        string                   `json:"op"`
+	ID        string                   `json:"id,omitempty"`
+	Thread    *models.Thread           `json:"thread,omitempty"`
+	Message   *models.Message          `json:"message,omitempty"`
+	Revisions []models.MessageRevision `json:"revisions,omitempty"`
+	Settings  *models.Settings         `json:"settings,omitempty"`
+	User      *models.User             `json:"user,omitempty"`
+	Session   *models.Session          `json:"session,omitempty"`
+	Token     *models.AccessToken      `json:"token,omitempty"`
+	Provider  *models.ProviderProfile  `json:"provider,omitempty"`
+}
+
+// snapshot is the whole state as written to STORAGE_PATH.
+type snapshot struct {
+	Version   int                                 `json:"version"`
+	Threads   []models.Thread                     `json:"threads"`
+	Messages  []models.Message                    `json:"messages"`
+	Revisions map[string][]models.MessageRevision `json:"revisions"`
+	Settings  []models.Settings                   `json:"settings"`
+	Users     []models.User                       `json:"users"`
+	Sessions  []models.Session                    `json:"sessions"`
+	Tokens    []models.AccessToken                `json:"tokens"`
+	Providers []models.ProviderProfile            `json:"providers"`
+}
+
+// Open returns a durable store kept in the snapshot at path and the log next
+// to it, replaying both. Missing files start an empty store.
+func Open(path string) (*MemoryStorage, error) {
+	m := New().(*MemoryStorage)
+	m.path = path
+	if err := m.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := m.replay(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close compacts a durable store into a fresh snapshot and releases the log.
+// It does nothing for a store made with New.
+func (m *MemoryStorage) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.wal == nil {
+		return nil
+	}
+	err := m.compact()
+	if cerr := m.wal.Close(); err == nil {
+		err = cerr
+	}
+	m.wal = nil
+	return err
+}
+
+func (m *MemoryStorage) putThread(t models.Thread) {
+	m.pending = append(m.pending, change{Op: opPutThread, Thread: &t})
+}
+
+// deleteThread removes only the thread record; callers delete its messages.
+func (m *MemoryStorage) deleteThread(id string) {
+	m.pending = append(m.pending, change{Op: opDeleteThread, ID: id})
+}
+
+func (m *MemoryStorage) putMessage(msg models.Message) {
+	m.pending = append(m.pending, change{Op: opPutMessage, Message: &msg})
+}
+
+func (m *MemoryStorage) deleteMessage(id string) {
+	m.pending = append(m.pending, change{Op: opDeleteMessage, ID: id})
+}
+
+func (m *MemoryStorage) setRevisions(messageID string, revs []models.MessageRevision) {
+	m.pending = append(m.pending, change{Op: opSetRevisions, ID: messageID, Revisions: revs})
+}
+
+func (m *MemoryStorage) updateSettings(cfg models.Settings) {
+	m.pending = append(m.pending, change{Op: opUpdateSettings, Settings: &cfg})
+}
+
+func (m *MemoryStorage) putUser(u models.User) {
+	m.pending = append(m.pending, change{Op: opPutUser, User: &u})
+}
+
+func (m *MemoryStorage) putSession(sess models.Session) {
+	m.pending = append(m.pending, change{Op: opPutSession, Session: &sess})
+}
+
+func (m *MemoryStorage) deleteSession(id string) {
+	m.pending = append(m.pending, change{Op: opDeleteSession, ID: id})
+}
+
+func (m *MemoryStorage) putToken(t models.AccessToken) {
+	m.pending = append(m.pending, change{Op: opPutToken, Token: &t})
+}
+
+func (m *MemoryStorage) deleteToken(id string) {
+	m.pending = append(m.pending, change{Op: opDeleteToken, ID: id})
+}
+
+func (m *MemoryStorage) putProvider(p models.ProviderProfile) {
+	m.pending = append(m.pending, change{Op: opPutProvider, Provider: &p})
+}
+
+func (m *MemoryStorage) deleteProvider(id string) {
+	m.pending = append(m.pending, change{Op: opDeleteProvider, ID: id})
+}
+
+// commit logs the pending changes when durable, then applies them. On a log
+// error nothing is applied. Callers must hold the write lock.
+func (m *MemoryStorage) commit() error {
+	batch := m.pending
+	m.pending = nil
+	if len(batch) == 0 {
+		return nil
+	}
+	if m.walErr != nil {
+		return fmt.Errorf("write-ahead log unusable: %w", m.walErr)
+	}
+	if m.wal != nil {
+		if err := m.appendLog(batch); err != nil {
+			return fmt.Errorf("write-ahead log: %w", err)
+		}
+	}
+	for _, c := range batch {
+		m.apply(c)
+	}
+	if m.wal != nil && m.walBatches >= snapshotEvery {
+		if err := m.compact(); err != nil {
+			// The log still holds everything; try again after the next batch.
+			log.Printf("memory: snapshot failed: %v", err)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) apply(c change) {
+	switch c.Op {
+	case opPutThread:
+		m.threads[c.Thread.ID] = *c.Thread
+	case opDeleteThread:
+		delete(m.threads, c.ID)
+	case opPutMessage:
+		m.messages[c.Message.ID] = *c.Message
+	case opDeleteMessage:
+		delete(m.messages, c.ID)
+		delete(m.revisions, c.ID)
+	case opSetRevisions:
+		if len(c.Revisions) == 0 {
+			delete(m.revisions, c.ID)
+		} else {
+			m.revisions[c.ID] = c.Revisions
+		}
+	case opUpdateSettings:
+		m.settings[c.Settings.ID] = *c.Settings
+	case opPutUser:
+		m.users[c.User.ID] = *c.User
+	case opPutSession:
+		m.sessions[c.Session.ID] = *c.Session
+	case opDeleteSession:
+		delete(m.sessions, c.ID)
+	case opPutToken:
+		m.tokens[c.Token.ID] = *c.Token
+	case opDeleteToken:
+		delete(m.tokens, c.ID)
+	case opPutProvider:
+		m.providers[c.Provider.ID] = *c.Provider
+	case opDeleteProvider:
+		delete(m.providers, c.ID)
+	}
+}
+
+func (m *MemoryStorage) appendLog(batch []change) error {
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("batch of %d bytes exceeds the %d byte record limit", len(payload), maxRecordSize)
+	}
+	frame := make([]byte, 8, 8+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	frame = append(frame, payload...)
+	_, err = m.wal.Write(frame)
+	if err == nil {
+		err = m.wal.Sync()
+	}
+	if err != nil {
+		m.rollbackLog()
+		return err
+	}
+	m.walSize += int64(len(frame))
+	m.walBatches++
+	return nil
+}
+
+// rollbackLog cuts the log back to its last complete record after a failed
+// append. If it cannot, the store stops accepting changes.
+func (m *MemoryStorage) rollbackLog() {
+	err := m.wal.Truncate(m.walSize)
+	if err == nil {
+		_, err = m.wal.Seek(m.walSize, io.SeekStart)
+	}
+	if err != nil {
+		log.Printf("memory: cannot cut failed write from log: %v", err)
+		m.walErr = err
+	}
+}
+
+func (m *MemoryStorage) loadSnapshot() error {
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Settings are read separately because their layout changed in version 2.
+	var snap struct {
+		snapshot
+		Settings json.RawMessage `json:"settings"`
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("read snapshot %s: %w", m.path, err)
+	}
+	var settings []models.Settings
+	switch snap.Version {
+	case 1:
+		var cfg *models.Settings
+		if err := json.Unmarshal(snap.Settings, &cfg); err != nil {
+			return fmt.Errorf("read snapshot %s: %w", m.path, err)
+		}
+		if cfg != nil {
+			settings = append(settings, *cfg)
+		}
+	case snapshotVersion:
+		if err := json.Unmarshal(snap.Settings, &settings); err != nil {
+			return fmt.Errorf("read snapshot %s: %w", m.path, err)
+		}
+	default:
+		return fmt.Errorf("snapshot %s has unsupported version %d", m.path, snap.Version)
+	}
+	for _, t := range snap.Threads {
+		m.threads[t.ID] = t
+	}
+	for _, msg := range snap.Messages {
+		m.messages[msg.ID] = msg
+	}
+	for id, revs := range snap.Revisions {
+		m.revisions[id] = revs
+	}
+	for _, cfg := range settings {
+		m.settings[cfg.ID] = cfg
+	}
+	for _, u := range snap.Users {
+		m.users[u.ID] = u
+	}
+	for _, sess := range snap.Sessions {
+		m.sessions[sess.ID] = sess
+	}
+	for _, tok := range snap.Tokens {
+		m.tokens[tok.ID] = tok
+	}
+	for _, p := range snap.Providers {
+		m.providers[p.ID] = p
+	}
+	return nil
+}
+
+// replay applies every complete log record, cuts off a torn final record,
+// and leaves the log open for appending.
+func (m *MemoryStorage) replay() error {
+	f, err := os.OpenFile(m.path+walSuffix, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+	var good int64
+	for {
+		batch, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("memory: discarding damaged log tail at byte %d: %v", good, err)
+			break
+		}
+		for _, c := range batch {
+			m.apply(c)
+		}
+		good += n
+		m.walBatches++
+	}
+
+	if err := f.Truncate(good); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		_ = f.Close()
+		return err
+	}
+	m.wal = f
+	m.walSize = good
+	return nil
+}
+
+// readRecord reads one framed batch and returns it with its size in bytes.
+// It returns io.EOF only at a clean record boundary.
+func readRecord(r io.Reader) ([]change, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, errors.New("truncated record header")
+		}
+		return nil, 0, err
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size > maxRecordSize {
+		return nil, 0, fmt.Errorf("record length %d exceeds limit", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, errors.New("truncated record")
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+	var batch []change
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		return nil, 0, err
+	}
+	return batch, int64(len(header)) + int64(size), nil
+}
+
+// compact writes the current state to a new snapshot, replacing the old one
+// atomically, then empties the log. Callers must hold the write lock.
+func (m *MemoryStorage) compact() error {
+	snap := snapshot{
+		Version:   snapshotVersion,
+		Threads:   make([]models.Thread, 0, len(m.threads)),
+		Messages:  make([]models.Message, 0, len(m.messages)),
+		Revisions: m.revisions,
+		Settings:  make([]models.Settings, 0, len(m.settings)),
+		Users:     make([]models.User, 0, len(m.users)),
+		Sessions:  make([]models.Session, 0, len(m.sessions)),
+		Tokens:    make([]models.AccessToken, 0, len(m.tokens)),
+		Providers: make([]models.ProviderProfile, 0, len(m.providers)),
+	}
+	for _, t := range m.threads {
+		snap.Threads = append(snap.Threads, t)
+	}
+	for _, cfg := range m.settings {
+		snap.Settings = append(snap.Settings, cfg)
+	}
+	for _, u := range m.users {
+		snap.Users = append(snap.Users, u)
+	}
+	for _, sess := range m.sessions {
+		snap.Sessions = append(snap.Sessions, sess)
+	}
+	for _, tok := range m.tokens {
+		snap.Tokens = append(snap.Tokens, tok)
+	}
+	for _, p := range m.providers {
+		snap.Providers = append(snap.Providers, p)
+	}
+	for _, msg := range m.messages {
+		snap.Messages = append(snap.Messages, msg)
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), m.path); err != nil {
+		return err
+	}
+
+	if err := m.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := m.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	m.walSize = 0
+	m.walBatches = 0
+	return nil
+}
@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/krackenservices/threadwell/models"
+	"github.com/stretchr/testify/require"
+)
+
+// shortWriter writes only half of the next frame and reports an error, as a
+// full disk would.
+type shortWriter struct {
+	logFile
+	fail bool
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if !w.fail {
+		return w.logFile.Write(p)
+	}
+	w.fail = false
+	n, _ := w.logFile.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func TestFailedAppendKeepsLaterBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "threadwell.json")
+	store, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, store.CreateThread(models.Thread{ID: "t1", Title: "kept", CreatedAt: 1}))
+
+	store.wal = &shortWriter{logFile: store.wal, fail: true}
+	err = store.CreateMessage(models.Message{ID: "lost", ThreadID: "t1", Role: "user", Content: "torn", Timestamp: 2})
+	require.Error(t, err)
+	got, err := store.GetMessage("lost")
+	require.NoError(t, err)
+	require.Nil(t, got)
+
+	require.NoError(t, store.CreateMessage(models.Message{ID: "m2", ThreadID: "t1", Role: "user", Content: "after", Timestamp: 3}))
+
+	reopened, err := Open(path)
+	require.NoError(t, err)
+	msgs, err := reopened.ListMessages("t1")
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, "m2", msgs[0].ID)
+}
+
+// failingTruncate cannot cut the log back after a failed write.
+type failingTruncate struct {
+	shortWriter
+}
+
+func (f *failingTruncate) Truncate(int64) error { return errors.New("read-only file system") }
+
+func TestFailedRollbackRefusesChanges(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "threadwell.json"))
+	require.NoError(t, err)
+	store.wal = &failingTruncate{shortWriter{logFile: store.wal, fail: true}}
+
+	require.Error(t, store.CreateThread(models.Thread{ID: "t1", Title: "torn", CreatedAt: 1}))
+	require.Error(t, store.CreateThread(models.Thread{ID: "t2", Title: "refused", CreatedAt: 2}))
+	threads, err := store.ListThreads()
+	require.NoError(t, err)
+	require.Empty(t, threads)
+}
@@ -2,6 +2,8 @@ package memory
 
 import (
 	"errors"
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -11,16 +13,35 @@ import (
 )
 
 type MemoryStorage struct {
-	mu       sync.RWMutex
-	threads  map[string]models.Thread
-	messages map[string]models.Message
-	settings *models.Settings
+	mu        sync.RWMutex
+	threads   map[string]models.Thread
+	messages  map[string]models.Message
+	revisions map[string][]models.MessageRevision // keyed by message ID
+	settings  map[string]models.Settings
+	users     map[string]models.User
+	sessions  map[string]models.Session
+	tokens    map[string]models.AccessToken // by ID
+	providers map[string]models.ProviderProfile
+
+	// Durable mode only; see durable.go.
+	path       string   // snapshot file; the log is path + walSuffix
+	wal        logFile  // nil for a purely in-memory store
+	walSize    int64    // bytes of complete records in the log
+	walBatches int      // batches logged since the last snapshot
+	walErr     error    // set when a failed append could not be undone
+	pending    []change // changes of the mutation in progress
 }
 
 func New() storage.Storage {
 	return &MemoryStorage{
-		threads:  make(map[string]models.Thread),
-		messages: make(map[string]models.Message),
+		threads:   make(map[string]models.Thread),
+		messages:  make(map[string]models.Message),
+		revisions: make(map[string][]models.MessageRevision),
+		settings:  make(map[string]models.Settings),
+		users:     make(map[string]models.User),
+		sessions:  make(map[string]models.Session),
+		tokens:    make(map[string]models.AccessToken),
+		providers: make(map[string]models.ProviderProfile),
 	}
 }
 
@@ -35,9 +56,28 @@ func (m *MemoryStorage) ListThreads() ([]models.Thread, error) {
 	for _, t := range m.threads {
 		out = append(out, t)
 	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt != out[j].CreatedAt {
+			return out[i].CreatedAt < out[j].CreatedAt
+		}
+		return out[i].ID < out[j].ID
+	})
 	return out, nil
 }
 
+func (m *MemoryStorage) QueryThreads(q storage.ThreadQuery) (*storage.ThreadPage, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	all := make([]models.Thread, 0, len(m.threads))
+	for _, t := range m.threads {
+		all = append(all, t)
+	}
+	return storage.PageThreads(all, q)
+}
+
 func (m *MemoryStorage) GetThread(id string) (*models.Thread, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -51,20 +91,26 @@ func (m *MemoryStorage) GetThread(id string) (*models.Thread, error) {
 func (m *MemoryStorage) CreateThread(t models.Thread) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.threads[t.ID] = t
-	return nil
+	if _, ok := m.threads[t.ID]; ok {
+		return fmt.Errorf("thread %s: %w", t.ID, storage.ErrConflict)
+	}
+	if t.UpdatedAt == 0 {
+		t.UpdatedAt = t.CreatedAt
+	}
+	m.putThread(t)
+	return m.commit()
 }
 
 func (m *MemoryStorage) DeleteThread(id string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	delete(m.threads, id)
+	m.deleteThread(id)
 	for mid, msg := range m.messages {
 		if msg.ThreadID == id {
-			delete(m.messages, mid)
+			m.deleteMessage(mid)
 		}
 	}
-	return nil
+	return m.commit()
 }
 
 func (m *MemoryStorage) ListMessages(threadID string) ([]models.Message, error) {
@@ -76,6 +122,13 @@ func (m *MemoryStorage) ListMessages(threadID string) ([]models.Message, error)
 			messages = append(messages, msg)
 		}
 	}
+	// Match the SQLite ordering rather than leaking map order
+	sort.Slice(messages, func(i, j int) bool {
+		if messages[i].Timestamp != messages[j].Timestamp {
+			return messages[i].Timestamp < messages[j].Timestamp
+		}
+		return messages[i].ID < messages[j].ID
+	})
 	return messages, nil
 }
 
@@ -92,51 +145,253 @@ func (m *MemoryStorage) GetMessage(id string) (*models.Message, error) {
 func (m *MemoryStorage) CreateMessage(msg models.Message) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.messages[msg.ID] = msg
+	if _, ok := m.messages[msg.ID]; ok {
+		return fmt.Errorf("message %s: %w", msg.ID, storage.ErrConflict)
+	}
+	if err := m.checkRefs(msg); err != nil {
+		return err
+	}
+	m.putMessage(msg)
+	if t := m.threads[msg.ThreadID]; msg.Timestamp > t.UpdatedAt {
+		t.UpdatedAt = msg.Timestamp
+		m.putThread(t)
+	}
+	return m.commit()
+}
+
+func (m *MemoryStorage) QueryMessages(q storage.MessageQuery) (*storage.MessagePage, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	all := make([]models.Message, 0)
+	for _, msg := range m.messages {
+		if msg.ThreadID == q.ThreadID {
+			all = append(all, msg)
+		}
+	}
+	return storage.PageMessages(all, q)
+}
+
+// checkRefs validates the thread, parent and root a message points at.
+// Callers must hold the lock.
+func (m *MemoryStorage) checkRefs(msg models.Message) error {
+	if _, ok := m.threads[msg.ThreadID]; !ok {
+		return fmt.Errorf("%w: thread %s does not exist", storage.ErrInvalidReference, msg.ThreadID)
+	}
+	refs := []*string{msg.ParentID}
+	// A root may point at itself, the way MoveSubtree leaves moved roots.
+	if msg.RootID == nil || *msg.RootID != msg.ID {
+		refs = append(refs, msg.RootID)
+	}
+	for _, ref := range refs {
+		if ref == nil {
+			continue
+		}
+		target, ok := m.messages[*ref]
+		if !ok {
+			return fmt.Errorf("%w: message %s does not exist", storage.ErrInvalidReference, *ref)
+		}
+		if target.ThreadID != msg.ThreadID {
+			return fmt.Errorf("%w: message %s belongs to thread %s", storage.ErrInvalidReference, *ref, target.ThreadID)
+		}
+	}
 	return nil
 }
 
+func (m *MemoryStorage) UpdateMessage(msg models.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.messages[msg.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	revs := append([]models.MessageRevision{}, m.revisions[msg.ID]...)
+	revs = append(revs, models.MessageRevision{
+		ID:        uuid.NewString(),
+		MessageID: msg.ID,
+		Version:   len(revs) + 1,
+		Role:      existing.Role,
+		Content:   existing.Content,
+		Timestamp: time.Now().Unix(),
+	})
+	m.setRevisions(msg.ID, revs)
+	existing.Role = msg.Role
+	existing.Content = msg.Content
+	m.putMessage(existing)
+	return m.commit()
+}
+
+func (m *MemoryStorage) ListMessageRevisions(messageID string) ([]models.MessageRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]models.MessageRevision, len(m.revisions[messageID]))
+	copy(out, m.revisions[messageID])
+	return out, nil
+}
+
 func (m *MemoryStorage) DeleteMessage(id string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	delete(m.messages, id)
-	return nil
+	for _, msg := range m.messages {
+		if msg.ParentID != nil && *msg.ParentID == id {
+			return storage.ErrHasChildren
+		}
+	}
+	m.deleteMessage(id)
+	return m.commit()
 }
 
-func (m *MemoryStorage) MoveSubtree(fromMessageID string) (string, error) {
+func (m *MemoryStorage) DeleteSubtree(id string) ([]string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if _, ok := m.messages[id]; !ok {
+		return nil, storage.ErrNotFound
+	}
 
-	orig, ok := m.messages[fromMessageID]
+	deleted := []string{id}
+	for _, d := range m.descendants(id) {
+		deleted = append(deleted, d.ID)
+	}
+	for _, mid := range deleted {
+		m.deleteMessage(mid)
+	}
+	if err := m.commit(); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+func (m *MemoryStorage) Ancestors(id string) ([]models.Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	msg, ok := m.messages[id]
 	if !ok {
-		return "", errors.New("message not found")
+		return nil, fmt.Errorf("message %w", storage.ErrNotFound)
 	}
+	return m.ancestors(msg), nil
+}
 
-	// 🧠 Step 1: Walk UP the ancestry chain
-	ancestry := []models.Message{}
-	cur := &orig
-	for cur != nil && cur.ParentID != nil {
-		parent, ok := m.messages[*cur.ParentID]
+func (m *MemoryStorage) Descendants(id string) ([]models.Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.messages[id]; !ok {
+		return nil, fmt.Errorf("message %w", storage.ErrNotFound)
+	}
+	return m.descendants(id), nil
+}
+
+// ancestors walks parent links up from msg and returns them root first.
+// Callers must hold the lock.
+func (m *MemoryStorage) ancestors(msg models.Message) []models.Message {
+	out := []models.Message{}
+	seen := map[string]bool{msg.ID: true}
+	for msg.ParentID != nil && !seen[*msg.ParentID] {
+		parent, ok := m.messages[*msg.ParentID]
 		if !ok {
 			break
 		}
-		ancestry = append([]models.Message{parent}, ancestry...) // prepend
-		cur = &parent
+		seen[parent.ID] = true
+		out = append(out, parent)
+		msg = parent
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
 	}
+	return out
+}
 
-	// 🧠 Step 2: BFS to collect descendants
-	descendants := map[string]models.Message{orig.ID: orig}
-	queue := []string{orig.ID}
-	for len(queue) > 0 {
-		pid := queue[0]
-		queue = queue[1:]
-		for _, msg := range m.messages {
-			if msg.ParentID != nil && *msg.ParentID == pid {
-				descendants[msg.ID] = msg
-				queue = append(queue, msg.ID)
+// descendants returns everything below id, level by level with each level
+// ordered by timestamp and ID, the same order SQLite's recursive query uses.
+// Callers must hold the lock.
+func (m *MemoryStorage) descendants(id string) []models.Message {
+	children := map[string][]models.Message{}
+	for _, msg := range m.messages {
+		if msg.ParentID != nil {
+			children[*msg.ParentID] = append(children[*msg.ParentID], msg)
+		}
+	}
+
+	out := []models.Message{}
+	level := []string{id}
+	for len(level) > 0 {
+		var next []models.Message
+		for _, pid := range level {
+			next = append(next, children[pid]...)
+		}
+		sort.Slice(next, func(i, j int) bool {
+			if next[i].Timestamp != next[j].Timestamp {
+				return next[i].Timestamp < next[j].Timestamp
 			}
+			return next[i].ID < next[j].ID
+		})
+		level = level[:0]
+		for _, msg := range next {
+			out = append(out, msg)
+			level = append(level, msg.ID)
 		}
 	}
+	return out
+}
+
+func (m *MemoryStorage) Search(q storage.SearchQuery) ([]storage.SearchResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	threads := make([]models.Thread, 0, len(m.threads))
+	for _, t := range m.threads {
+		threads = append(threads, t)
+	}
+	msgs := make([]models.Message, 0, len(m.messages))
+	for _, msg := range m.messages {
+		msgs = append(msgs, msg)
+	}
+	return storage.SearchDocuments(threads, msgs, q)
+}
+
+func (m *MemoryStorage) MoveSubtree(fromMessageID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res, err := m.branch(fromMessageID, true)
+	if err != nil {
+		return "", err
+	}
+	if err := m.commit(); err != nil {
+		return "", err
+	}
+	return res.ThreadID, nil
+}
+
+func (m *MemoryStorage) CopySubtree(fromMessageID string) (*storage.BranchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res, err := m.branch(fromMessageID, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// branch copies the ancestry and subtree of fromMessageID into a new thread,
+// removing the subtree from the source when move is set.
+// Callers must hold the write lock.
+func (m *MemoryStorage) branch(fromMessageID string, move bool) (*storage.BranchResult, error) {
+	orig, ok := m.messages[fromMessageID]
+	if !ok {
+		return nil, fmt.Errorf("message %w", storage.ErrNotFound)
+	}
+
+	// 🧠 Step 1: Walk UP the ancestry chain
+	ancestry := m.ancestors(orig)
+
+	// 🧠 Step 2: Collect descendants
+	descendants := map[string]models.Message{orig.ID: orig}
+	for _, d := range m.descendants(orig.ID) {
+		descendants[d.ID] = d
+	}
 
 	// 🧠 Step 3: Combine all messages to move
 	toMove := map[string]models.Message{}
@@ -170,11 +425,10 @@ func (m *MemoryStorage) MoveSubtree(fromMessageID string) (string, error) {
 		}
 		title = "Branched: " + preview
 	}
-	m.threads[newThreadID] = models.Thread{
-		ID:        newThreadID,
-		Title:     title,
-		CreatedAt: time.Now().Unix(),
-	}
+	now := time.Now().Unix()
+	branch := m.threads[orig.ThreadID] // keeps the owner and reply settings
+	branch.ID, branch.Title, branch.CreatedAt, branch.UpdatedAt = newThreadID, title, now, now
+	m.putThread(branch)
 
 	// 🧠 Step 6: Copy messages
 	for _, old := range toMove {
@@ -187,7 +441,7 @@ func (m *MemoryStorage) MoveSubtree(fromMessageID string) (string, error) {
 		}
 
 		// Insert new message into new thread
-		m.messages[newID] = models.Message{
+		m.putMessage(models.Message{
 			ID:        newID,
 			ThreadID:  newThreadID,
 			ParentID:  newParent,
@@ -195,38 +449,169 @@ func (m *MemoryStorage) MoveSubtree(fromMessageID string) (string, error) {
 			Role:      old.Role,
 			Content:   old.Content,
 			Timestamp: old.Timestamp,
-		}
+		})
 
 		// ❌ Delete only if this message is part of the branch (from `fromID` down)
-		if _, ok := descendants[old.ID]; ok {
-			delete(m.messages, old.ID)
+		if _, ok := descendants[old.ID]; ok && move {
+			m.moveRevisions(old.ID, newID)
+			m.deleteMessage(old.ID)
 		}
 	}
-	return newThreadID, nil
+	return &storage.BranchResult{ThreadID: newThreadID, IDMap: idMap}, nil
 }
 
-func (s *MemoryStorage) GetSettings() (*models.Settings, error) {
-	s.mu.RLock()
-	if s.settings != nil {
-		cfg := s.settings
-		s.mu.RUnlock()
-		return cfg, nil
+func (m *MemoryStorage) GraftSubtree(fromMessageID, targetThreadID string, targetParentID *string) (*storage.BranchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	orig, ok := m.messages[fromMessageID]
+	if !ok {
+		return nil, fmt.Errorf("message %w", storage.ErrNotFound)
+	}
+	if _, ok := m.threads[targetThreadID]; !ok {
+		return nil, fmt.Errorf("%w: thread %s does not exist", storage.ErrInvalidReference, targetThreadID)
 	}
-	s.mu.RUnlock()
 
-	s.mu.Lock()
-	if s.settings == nil {
-		s.settings = &models.Settings{
-			ID:           "default",
-			LLMProvider:  "ollama",
-			LLMEndpoint:  "http://localhost:11434",
-			LLMName:      "llama3",
-			SimulateOnly: true,
+	// Collect the subtree, parents before children
+	subtree := append([]models.Message{orig}, m.descendants(orig.ID)...)
+	inSubtree := map[string]bool{}
+	for _, msg := range subtree {
+		inSubtree[msg.ID] = true
+	}
+
+	var rootID *string
+	if targetParentID != nil {
+		if inSubtree[*targetParentID] {
+			return nil, storage.ErrCycle
+		}
+		parentID := *targetParentID // don't keep the caller's pointer
+		targetParentID = &parentID
+		parent, ok := m.messages[*targetParentID]
+		if !ok || parent.ThreadID != targetThreadID {
+			return nil, fmt.Errorf("%w: parent %s is not in thread %s", storage.ErrInvalidReference, *targetParentID, targetThreadID)
+		}
+		rootID = parent.RootID
+		if rootID == nil {
+			rootID = &parent.ID
+		}
+	}
+
+	idMap := map[string]string{}
+	for _, msg := range subtree {
+		idMap[msg.ID] = uuid.NewString()
+	}
+	if rootID == nil {
+		newRoot := idMap[orig.ID]
+		rootID = &newRoot
+	}
+
+	for _, old := range subtree {
+		newParent := targetParentID
+		if old.ID != orig.ID {
+			remapped := idMap[*old.ParentID]
+			newParent = &remapped
+		}
+		newID := idMap[old.ID]
+		m.putMessage(models.Message{
+			ID:        newID,
+			ThreadID:  targetThreadID,
+			ParentID:  newParent,
+			RootID:    rootID,
+			Role:      old.Role,
+			Content:   old.Content,
+			Timestamp: old.Timestamp,
+		})
+		m.moveRevisions(old.ID, newID)
+		m.deleteMessage(old.ID)
+	}
+	// The target thread changed, so it sorts as recently updated
+	target := m.threads[targetThreadID]
+	target.UpdatedAt = time.Now().Unix()
+	m.putThread(target)
+	if err := m.commit(); err != nil {
+		return nil, err
+	}
+	return &storage.BranchResult{ThreadID: targetThreadID, IDMap: idMap}, nil
+}
+
+func (m *MemoryStorage) MergeThreads(targetThreadID, sourceThreadID string, targetParentID *string, deleteSource bool) (*storage.MergeResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.threads[targetThreadID]; !ok {
+		return nil, fmt.Errorf("thread %w", storage.ErrNotFound)
+	}
+	if _, ok := m.threads[sourceThreadID]; !ok || sourceThreadID == targetThreadID {
+		return nil, fmt.Errorf("%w: cannot merge thread %s into %s", storage.ErrInvalidReference, sourceThreadID, targetThreadID)
+	}
+	if targetParentID != nil {
+		if p, ok := m.messages[*targetParentID]; !ok || p.ThreadID != targetThreadID {
+			return nil, fmt.Errorf("%w: parent %s is not in thread %s", storage.ErrInvalidReference, *targetParentID, targetThreadID)
+		}
+	}
+
+	var target, source []models.Message
+	for _, msg := range m.messages {
+		switch msg.ThreadID {
+		case targetThreadID:
+			target = append(target, msg)
+		case sourceThreadID:
+			source = append(source, msg)
 		}
 	}
-	cfg := s.settings
-	s.mu.Unlock()
-	return cfg, nil
+
+	res, created := storage.PlanMerge(targetThreadID, target, source, targetParentID, uuid.NewString)
+	for _, msg := range created {
+		m.putMessage(msg)
+	}
+	merged := m.threads[targetThreadID]
+	merged.UpdatedAt = time.Now().Unix()
+	m.putThread(merged)
+
+	if deleteSource {
+		for _, msg := range source {
+			if newID, ok := res.IDMap[msg.ID]; ok {
+				m.moveRevisions(msg.ID, newID)
+			}
+			m.deleteMessage(msg.ID)
+		}
+		m.deleteThread(sourceThreadID)
+		res.SourceDeleted = true
+	}
+	if err := m.commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// moveRevisions copies a message's edit history to its new ID. Deleting the
+// old message drops the original. Callers must hold the write lock.
+func (m *MemoryStorage) moveRevisions(oldID, newID string) {
+	revs, ok := m.revisions[oldID]
+	if !ok {
+		return
+	}
+	moved := make([]models.MessageRevision, len(revs))
+	for i, r := range revs {
+		r.MessageID = newID
+		moved[i] = r
+	}
+	m.setRevisions(newID, moved)
+}
+
+func (s *MemoryStorage) GetSettings(id string) (*models.Settings, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if cfg, ok := s.settings[id]; ok {
+		return &cfg, nil
+	}
+	return &models.Settings{
+		ID:           id,
+		LLMProvider:  "ollama",
+		LLMEndpoint:  "http://localhost:11434",
+		LLMName:      "llama3",
+		SimulateOnly: true,
+	}, nil
 }
 
 func (m *MemoryStorage) UpdateThread(t models.Thread) error {
@@ -234,17 +619,24 @@ func (m *MemoryStorage) UpdateThread(t models.Thread) error {
 	defer m.mu.Unlock()
 
 	// Check if thread exists
-	if _, ok := m.threads[t.ID]; !ok {
+	existing, ok := m.threads[t.ID]
+	if !ok {
 		return errors.New("thread not found")
 	}
+	if t.UpdatedAt == 0 {
+		t.UpdatedAt = existing.UpdatedAt
+	}
+	if t.OwnerID == "" {
+		t.OwnerID = existing.OwnerID
+	}
 
-	m.threads[t.ID] = t
-	return nil
+	m.putThread(t)
+	return m.commit()
 }
 
 func (s *MemoryStorage) UpdateSettings(cfg models.Settings) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.settings = &cfg
-	return nil
+	s.updateSettings(cfg)
+	return s.commit()
 }
@@ -1,15 +1,191 @@
 package memory_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
 	"github.com/krackenservices/threadwell/storage/memory"
 	"github.com/krackenservices/threadwell/testhelpers"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMemoryStorage(t *testing.T) {
 	store := memory.New()
 	testhelpers.RunStorageSuite(t, "memory", store)
+	testhelpers.RunDeleteSubtreeSuite(t, "memory", store)
+	testhelpers.RunIntegritySuite(t, "memory", store)
 	testhelpers.RunMoveSubtreeSuite(t, "memory", store)
+	testhelpers.RunCopySubtreeSuite(t, "memory", store)
+	testhelpers.RunGraftSubtreeSuite(t, "memory", store)
+	testhelpers.RunMergeThreadsSuite(t, "memory", store)
+	testhelpers.RunTreeSuite(t, "memory", store)
+	testhelpers.RunAncestrySuite(t, "memory", store)
+	testhelpers.RunPaginationSuite(t, "memory", store)
+	testhelpers.RunSearchSuite(t, "memory", store)
 	testhelpers.RunSettingsSuite(t, "memory", store)
+	testhelpers.RunUserSuite(t, "memory", store)
+	testhelpers.RunOwnershipSuite(t, "memory", store)
+	testhelpers.RunProviderSuite(t, "memory", store)
+}
+
+func BenchmarkMemoryTreeQueries(b *testing.B) {
+	testhelpers.RunTreeBenchmarks(b, "memory", memory.New())
+}
+
+func TestDurableMemoryStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "threadwell.json")
+	store, err := memory.Open(path)
+	require.NoError(t, err)
+	testhelpers.RunStorageSuite(t, "durable", store)
+	testhelpers.RunDeleteSubtreeSuite(t, "durable", store)
+	testhelpers.RunIntegritySuite(t, "durable", store)
+	testhelpers.RunMoveSubtreeSuite(t, "durable", store)
+	testhelpers.RunCopySubtreeSuite(t, "durable", store)
+	testhelpers.RunGraftSubtreeSuite(t, "durable", store)
+	testhelpers.RunMergeThreadsSuite(t, "durable", store)
+	testhelpers.RunTreeSuite(t, "durable", store)
+	testhelpers.RunAncestrySuite(t, "durable", store)
+	testhelpers.RunPaginationSuite(t, "durable", store)
+	testhelpers.RunSearchSuite(t, "durable", store)
+	testhelpers.RunSettingsSuite(t, "durable", store)
+	testhelpers.RunUserSuite(t, "durable", store)
+	testhelpers.RunOwnershipSuite(t, "durable", store)
+	testhelpers.RunProviderSuite(t, "durable", store)
+	want := dump(t, store)
+
+	// Replaying the log alone, as after a crash, rebuilds the same state.
+	replayed, err := memory.Open(path)
+	require.NoError(t, err)
+	require.Equal(t, want, dump(t, replayed))
+	require.NoError(t, replayed.Close())
+
+	// So does the snapshot Close leaves behind, with an empty log.
+	compacted, err := memory.Open(path)
+	require.NoError(t, err)
+	require.Equal(t, want, dump(t, compacted))
+	info, err := os.Stat(path + ".wal")
+	require.NoError(t, err)
+	require.Zero(t, info.Size())
+	require.NoError(t, compacted.Close())
+}
+
+func TestDurableMemoryStorageTruncatedLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "threadwell.json")
+	store, err := memory.Open(path)
+	require.NoError(t, err)
+	require.NoError(t, store.CreateThread(models.Thread{ID: "t1", Title: "kept", CreatedAt: 1}))
+	require.NoError(t, store.CreateMessage(models.Message{ID: "m1", ThreadID: "t1", Role: "user", Content: "first", Timestamp: 2}))
+	info, err := os.Stat(path + ".wal")
+	require.NoError(t, err)
+	complete := info.Size()
+	require.NoError(t, store.CreateMessage(models.Message{ID: "m2", ThreadID: "t1", Role: "user", Content: "torn", Timestamp: 3}))
+
+	// Simulate a crash part way through writing the last record.
+	info, err = os.Stat(path + ".wal")
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path+".wal", complete+(info.Size()-complete)/2))
+
+	recovered, err := memory.Open(path)
+	require.NoError(t, err)
+	msgs, err := recovered.ListMessages("t1")
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, "m1", msgs[0].ID)
+
+	// The torn tail is cut off, so records written after recovery replay.
+	require.NoError(t, recovered.CreateMessage(models.Message{ID: "m3", ThreadID: "t1", Role: "user", Content: "after", Timestamp: 4}))
+	again, err := memory.Open(path)
+	require.NoError(t, err)
+	msgs, err = again.ListMessages("t1")
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	require.Equal(t, "m3", msgs[1].ID)
+
+	// So is a record whose header was only partly written.
+	info, err = os.Stat(path + ".wal")
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path+".wal", info.Size()+3))
+	again, err = memory.Open(path)
+	require.NoError(t, err)
+	msgs, err = again.ListMessages("t1")
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+
+	// And one whose length is garbage, without allocating that length.
+	f, err := os.OpenFile(path+".wal", os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	again, err = memory.Open(path)
+	require.NoError(t, err)
+	msgs, err = again.ListMessages("t1")
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	info, err = os.Stat(path + ".wal")
+	require.NoError(t, err)
+	require.Less(t, info.Size(), int64(1000))
+}
+
+// state is everything a store holds, in a comparable form.
+type state struct {
+	Threads   []models.Thread
+	Messages  map[string][]models.Message
+	Revisions map[string][]models.MessageRevision
+	Users     []models.User
+	Tokens    map[string][]models.AccessToken
+	Providers map[string][]models.ProviderProfile
+	Settings  map[string]*models.Settings
+}
+
+func dump(t *testing.T, store storage.Storage) state {
+	t.Helper()
+	s := state{
+		Messages:  map[string][]models.Message{},
+		Revisions: map[string][]models.MessageRevision{},
+		Tokens:    map[string][]models.AccessToken{},
+		Providers: map[string][]models.ProviderProfile{},
+		Settings:  map[string]*models.Settings{},
+	}
+	var err error
+	s.Threads, err = store.ListThreads()
+	require.NoError(t, err)
+	for _, th := range s.Threads {
+		msgs, err := store.ListMessages(th.ID)
+		require.NoError(t, err)
+		s.Messages[th.ID] = msgs
+		for _, m := range msgs {
+			revs, err := store.ListMessageRevisions(m.ID)
+			require.NoError(t, err)
+			if len(revs) > 0 {
+				s.Revisions[m.ID] = revs
+			}
+		}
+	}
+	s.Users, err = store.ListUsers()
+	require.NoError(t, err)
+	for _, id := range userIDs(s.Users) {
+		s.Tokens[id], err = store.ListAccessTokens(id)
+		require.NoError(t, err)
+	}
+	for _, id := range append([]string{""}, userIDs(s.Users)...) {
+		s.Providers[id], err = store.ListProviderProfiles(id)
+		require.NoError(t, err)
+	}
+	for _, id := range append([]string{storage.DefaultSettingsID}, userIDs(s.Users)...) {
+		s.Settings[id], err = store.GetSettings(id)
+		require.NoError(t, err)
+	}
+	return s
+}
+
+func userIDs(users []models.User) []string {
+	ids := make([]string, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	return ids
 }
@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"sort"
+
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+func (m *MemoryStorage) ListProviderProfiles(ownerID string) ([]models.ProviderProfile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]models.ProviderProfile, 0)
+	for _, p := range m.providers {
+		if p.OwnerID == ownerID {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt != out[j].CreatedAt {
+			return out[i].CreatedAt < out[j].CreatedAt
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+func (m *MemoryStorage) GetProviderProfile(id string) (*models.ProviderProfile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.providers[id]
+	if !ok {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func (m *MemoryStorage) CreateProviderProfile(p models.ProviderProfile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.providers[p.ID]; ok {
+		return storage.ErrConflict
+	}
+	if p.UpdatedAt == 0 {
+		p.UpdatedAt = p.CreatedAt
+	}
+	m.putProfile(p)
+	return m.commit()
+}
+
+func (m *MemoryStorage) UpdateProviderProfile(p models.ProviderProfile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.providers[p.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if p.OwnerID == "" {
+		p.OwnerID = existing.OwnerID
+	}
+	p.CreatedAt = existing.CreatedAt
+	m.putProfile(p)
+	return m.commit()
+}
+
+func (m *MemoryStorage) DeleteProviderProfile(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.providers[id]; !ok {
+		return storage.ErrNotFound
+	}
+	for _, t := range m.threads {
+		if t.ProviderID == id {
+			t.ProviderID = ""
+			m.putThread(t)
+		}
+	}
+	m.deleteProvider(id)
+	return m.commit()
+}
+
+// putProfile stores p, first clearing the owner's previous default when p
+// is the new one. Callers must hold the write lock.
+func (m *MemoryStorage) putProfile(p models.ProviderProfile) {
+	if p.IsDefault {
+		for _, other := range m.providers {
+			if other.OwnerID == p.OwnerID && other.IsDefault && other.ID != p.ID {
+				other.IsDefault = false
+				m.putProvider(other)
+			}
+		}
+	}
+	m.putProvider(p)
+}
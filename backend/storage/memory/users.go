@@ -0,0 +1,139 @@
+package memory
+
+import (
+	"sort"
+
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+func (m *MemoryStorage) CreateUser(u models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.users {
+		if existing.ID == u.ID || existing.Username == u.Username {
+			return storage.ErrConflict
+		}
+	}
+	m.putUser(u)
+	return m.commit()
+}
+
+func (m *MemoryStorage) GetUser(id string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.users[id]
+	if !ok {
+		return nil, nil
+	}
+	return &u, nil
+}
+
+func (m *MemoryStorage) GetUserByUsername(username string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, u := range m.users {
+		if u.Username == username {
+			return &u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStorage) ListUsers() ([]models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]models.User, 0, len(m.users))
+	for _, u := range m.users {
+		out = append(out, u)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt != out[j].CreatedAt {
+			return out[i].CreatedAt < out[j].CreatedAt
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+func (m *MemoryStorage) CreateSession(sess models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.putSession(sess)
+	return m.commit()
+}
+
+func (m *MemoryStorage) GetSession(id string) (*models.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sess, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	return &sess, nil
+}
+
+func (m *MemoryStorage) DeleteSession(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[id]; !ok {
+		return nil
+	}
+	m.deleteSession(id)
+	return m.commit()
+}
+
+func (m *MemoryStorage) CreateAccessToken(t models.AccessToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.tokens {
+		if existing.ID == t.ID || existing.TokenHash == t.TokenHash {
+			return storage.ErrConflict
+		}
+	}
+	t.Scopes = append([]string(nil), t.Scopes...)
+	m.putToken(t)
+	return m.commit()
+}
+
+func (m *MemoryStorage) GetAccessToken(hash string) (*models.AccessToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, t := range m.tokens {
+		if t.TokenHash == hash {
+			t.Scopes = append([]string(nil), t.Scopes...)
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStorage) ListAccessTokens(userID string) ([]models.AccessToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]models.AccessToken, 0)
+	for _, t := range m.tokens {
+		if t.UserID == userID {
+			t.Scopes = append([]string(nil), t.Scopes...)
+			out = append(out, t)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt != out[j].CreatedAt {
+			return out[i].CreatedAt < out[j].CreatedAt
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+func (m *MemoryStorage) DeleteAccessToken(userID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[id]
+	if !ok || t.UserID != userID {
+		return storage.ErrNotFound
+	}
+	m.deleteToken(id)
+	return m.commit()
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/krackenservices/threadwell/models"
+)
+
+// MergeResult describes the outcome of merging one thread into another.
+type MergeResult struct {
+	ThreadID      string            `json:"thread_id"`
+	IDMap         map[string]string `json:"id_map"`       // source message ID → new message ID
+	Deduplicated  map[string]string `json:"deduplicated"` // source message ID → existing target message ID
+	SourceDeleted bool              `json:"source_deleted"`
+}
+
+// PlanMerge works out how the source thread's messages attach to the target
+// thread and returns the new messages to insert, parents before children.
+//
+// Source messages that repeat a target message at the same position (same
+// role, content and timestamp under an equivalent parent) are dropped and
+// mapped onto the existing message. This removes the ancestor copies that
+// MoveSubtree puts at the top of a branch, so a branch merged back lands where
+// it was cut from. Source roots that have no duplicate attach under
+// targetParentID, or become new roots when it is nil.
+func PlanMerge(targetThreadID string, target, source []models.Message, targetParentID *string, newID func() string) (*MergeResult, []models.Message) {
+	res := &MergeResult{
+		ThreadID:     targetThreadID,
+		IDMap:        map[string]string{},
+		Deduplicated: map[string]string{},
+	}
+
+	targetByID := map[string]models.Message{}
+	for _, m := range target {
+		targetByID[m.ID] = m
+	}
+	targetChildren := childrenByParent(target)
+	sourceChildren := childrenByParent(source)
+
+	rootOf := func(m models.Message) string {
+		if m.RootID != nil {
+			return *m.RootID
+		}
+		return m.ID
+	}
+
+	type step struct {
+		msg models.Message
+		// parent is the message this one attaches under after the merge,
+		// nil for a new root. existing is true when parent is a target message.
+		parent   *string
+		existing bool
+		rootID   *string
+	}
+
+	var queue []step
+	for _, r := range sourceChildren[""] {
+		queue = append(queue, step{msg: r, existing: true})
+	}
+
+	var created []models.Message
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		var dup *models.Message
+		if cur.existing {
+			key := ""
+			if cur.parent != nil {
+				key = *cur.parent
+			}
+			dup = findDuplicate(targetChildren[key], cur.msg)
+			if dup == nil && cur.parent == nil && targetParentID != nil {
+				dup = findDuplicate(targetChildren[*targetParentID], cur.msg)
+			}
+		}
+
+		if dup != nil {
+			res.Deduplicated[cur.msg.ID] = dup.ID
+			rootID := rootOf(*dup)
+			for _, c := range sourceChildren[cur.msg.ID] {
+				dupID := dup.ID
+				queue = append(queue, step{msg: c, parent: &dupID, existing: true, rootID: &rootID})
+			}
+			continue
+		}
+
+		parent, rootID := cur.parent, cur.rootID
+		if cur.existing && cur.parent == nil && targetParentID != nil {
+			// A source root with no duplicate hangs off the chosen message
+			parentID := *targetParentID
+			parent = &parentID
+			r := parentID
+			if p, ok := targetByID[parentID]; ok {
+				r = rootOf(p)
+			}
+			rootID = &r
+		}
+
+		id := newID()
+		res.IDMap[cur.msg.ID] = id
+		if rootID == nil {
+			rootID = &id
+		}
+		created = append(created, models.Message{
+			ID:        id,
+			ThreadID:  targetThreadID,
+			ParentID:  parent,
+			RootID:    rootID,
+			Role:      cur.msg.Role,
+			Content:   cur.msg.Content,
+			Timestamp: cur.msg.Timestamp,
+		})
+		for _, c := range sourceChildren[cur.msg.ID] {
+			newParent := id
+			queue = append(queue, step{msg: c, parent: &newParent, rootID: rootID})
+		}
+	}
+	return res, created
+}
+
+// childrenByParent indexes messages by parent ID, with roots (and messages
+// whose parent is outside the list) under "". Children are ordered by
+// timestamp, then ID, so merges are deterministic.
+func childrenByParent(msgs []models.Message) map[string][]models.Message {
+	ids := map[string]bool{}
+	for _, m := range msgs {
+		ids[m.ID] = true
+	}
+	out := map[string][]models.Message{}
+	for _, m := range msgs {
+		key := ""
+		if m.ParentID != nil && ids[*m.ParentID] {
+			key = *m.ParentID
+		}
+		out[key] = append(out[key], m)
+	}
+	for _, children := range out {
+		sort.Slice(children, func(i, j int) bool {
+			if children[i].Timestamp != children[j].Timestamp {
+				return children[i].Timestamp < children[j].Timestamp
+			}
+			return children[i].ID < children[j].ID
+		})
+	}
+	return out
+}
+
+func findDuplicate(candidates []models.Message, m models.Message) *models.Message {
+	for i := range candidates {
+		c := candidates[i]
+		if c.Role == m.Role && c.Content == m.Content && c.Timestamp == m.Timestamp {
+			return &c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+func (s *PostgresStorage) GraftSubtree(fromID, targetThreadID string, targetParentID *string) (*storage.BranchResult, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`SET CONSTRAINTS ALL DEFERRED`); err != nil {
+		return nil, rollback(tx, err)
+	}
+
+	orig, err := getMessage(tx, fromID, true)
+	if err != nil {
+		return nil, rollback(tx, err)
+	}
+	if orig == nil {
+		return nil, rollback(tx, fmt.Errorf("message %w", storage.ErrNotFound))
+	}
+
+	var threads int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM threads WHERE id = $1`, targetThreadID).Scan(&threads); err != nil {
+		return nil, rollback(tx, err)
+	}
+	if threads == 0 {
+		return nil, rollback(tx, fmt.Errorf("%w: thread %s does not exist", storage.ErrInvalidReference, targetThreadID))
+	}
+
+	// Collect the subtree, parents before children
+	below, err := descendants(tx, fromID)
+	if err != nil {
+		return nil, rollback(tx, err)
+	}
+	subtree := append([]models.Message{*orig}, below...)
+	inSubtree := map[string]bool{}
+	for _, m := range subtree {
+		inSubtree[m.ID] = true
+	}
+
+	var rootID *string
+	if targetParentID != nil {
+		if inSubtree[*targetParentID] {
+			return nil, rollback(tx, storage.ErrCycle)
+		}
+		parent, err := getMessage(tx, *targetParentID, false)
+		if err != nil {
+			return nil, rollback(tx, err)
+		}
+		if parent == nil || parent.ThreadID != targetThreadID {
+			return nil, rollback(tx, fmt.Errorf("%w: parent %s is not in thread %s", storage.ErrInvalidReference, *targetParentID, targetThreadID))
+		}
+		rootID = parent.RootID
+		if rootID == nil {
+			rootID = &parent.ID
+		}
+	}
+
+	idMap := map[string]string{}
+	for _, m := range subtree {
+		idMap[m.ID] = uuid.NewString()
+	}
+	if rootID == nil {
+		newRoot := idMap[orig.ID]
+		rootID = &newRoot
+	}
+
+	for _, m := range subtree {
+		newParent := targetParentID
+		if m.ID != orig.ID {
+			remapped := idMap[*m.ParentID]
+			newParent = &remapped
+		}
+		if _, err := tx.Exec(insertMessage,
+			idMap[m.ID], targetThreadID, newParent, rootID, m.Role, m.Content, m.Timestamp,
+		); err != nil {
+			return nil, rollback(tx, fmt.Errorf("insert failed for %s → %s: %w", m.ID, idMap[m.ID], err))
+		}
+		if _, err := tx.Exec(`UPDATE message_revisions SET message_id = $1 WHERE message_id = $2`, idMap[m.ID], m.ID); err != nil {
+			return nil, rollback(tx, fmt.Errorf("failed to move revisions of %s: %w", m.ID, err))
+		}
+	}
+
+	ids := make([]string, len(subtree))
+	for i, m := range subtree {
+		ids[i] = m.ID
+	}
+	if _, err := tx.Exec(`DELETE FROM messages WHERE id = ANY($1)`, ids); err != nil {
+		return nil, rollback(tx, fmt.Errorf("failed to delete originals: %w", err))
+	}
+
+	// The target thread changed, so it sorts as recently updated
+	if _, err := tx.Exec(`UPDATE threads SET updated_at = $1 WHERE id = $2`, time.Now().Unix(), targetThreadID); err != nil {
+		return nil, rollback(tx, fmt.Errorf("failed to touch thread %s: %w", targetThreadID, err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &storage.BranchResult{ThreadID: targetThreadID, IDMap: idMap}, nil
+}
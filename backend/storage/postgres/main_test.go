@@ -0,0 +1,21 @@
+package postgres_test
+
+import (
+	"os"
+	"sync"
+	"testing"
+)
+
+var (
+	serverOnce sync.Once
+	serverDSN  string
+	serverErr  error
+	stopServer = func() {}
+)
+
+// TestMain stops the cluster startServer may have launched.
+func TestMain(m *testing.M) {
+	code := m.Run()
+	stopServer()
+	os.Exit(code)
+}
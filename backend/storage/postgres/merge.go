@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+func (s *PostgresStorage) MergeThreads(targetThreadID, sourceThreadID string, targetParentID *string, deleteSource bool) (*storage.MergeResult, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// PlanMerge does not promise parents before children.
+	if _, err := tx.Exec(`SET CONSTRAINTS ALL DEFERRED`); err != nil {
+		return nil, rollback(tx, err)
+	}
+
+	// Lock both threads in a fixed order so that two merges of the same
+	// pair cannot deadlock.
+	rows, err := tx.Query(`SELECT id FROM threads WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`, targetThreadID, sourceThreadID)
+	if err != nil {
+		return nil, rollback(tx, err)
+	}
+	found := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return nil, rollback(tx, err)
+		}
+		found[id] = true
+	}
+	if err := rows.Close(); err != nil {
+		return nil, rollback(tx, err)
+	}
+	if !found[targetThreadID] {
+		return nil, rollback(tx, fmt.Errorf("thread %w", storage.ErrNotFound))
+	}
+	if !found[sourceThreadID] || sourceThreadID == targetThreadID {
+		return nil, rollback(tx, fmt.Errorf("%w: cannot merge thread %s into %s", storage.ErrInvalidReference, sourceThreadID, targetThreadID))
+	}
+	if targetParentID != nil {
+		parent, err := getMessage(tx, *targetParentID, false)
+		if err != nil {
+			return nil, rollback(tx, err)
+		}
+		if parent == nil || parent.ThreadID != targetThreadID {
+			return nil, rollback(tx, fmt.Errorf("%w: parent %s is not in thread %s", storage.ErrInvalidReference, *targetParentID, targetThreadID))
+		}
+	}
+
+	target, err := listMessages(tx, targetThreadID)
+	if err != nil {
+		return nil, rollback(tx, err)
+	}
+	source, err := listMessages(tx, sourceThreadID)
+	if err != nil {
+		return nil, rollback(tx, err)
+	}
+
+	res, created := storage.PlanMerge(targetThreadID, target, source, targetParentID, uuid.NewString)
+	for _, m := range created {
+		if _, err := tx.Exec(insertMessage,
+			m.ID, m.ThreadID, m.ParentID, m.RootID, m.Role, m.Content, m.Timestamp,
+		); err != nil {
+			return nil, rollback(tx, fmt.Errorf("insert failed for %s: %w", m.ID, err))
+		}
+	}
+
+	if deleteSource {
+		for _, m := range source {
+			if newID, ok := res.IDMap[m.ID]; ok {
+				_, err = tx.Exec(`UPDATE message_revisions SET message_id = $1 WHERE message_id = $2`, newID, m.ID)
+			} else {
+				_, err = tx.Exec(`DELETE FROM message_revisions WHERE message_id = $1`, m.ID)
+			}
+			if err != nil {
+				return nil, rollback(tx, fmt.Errorf("failed to carry revisions of %s: %w", m.ID, err))
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM messages WHERE thread_id = $1`, sourceThreadID); err != nil {
+			return nil, rollback(tx, err)
+		}
+		if _, err := tx.Exec(`DELETE FROM threads WHERE id = $1`, sourceThreadID); err != nil {
+			return nil, rollback(tx, err)
+		}
+		res.SourceDeleted = true
+	}
+
+	if _, err := tx.Exec(`UPDATE threads SET updated_at = $1 WHERE id = $2`, time.Now().Unix(), targetThreadID); err != nil {
+		return nil, rollback(tx, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,343 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+const messageColumns = `id, thread_id, parent_id, root_id, role, content, timestamp`
+
+// messageColumnsOf qualifies messageColumns with a table alias.
+func messageColumnsOf(alias string) string {
+	p := alias + "."
+	return p + "id, " + p + "thread_id, " + p + "parent_id, " + p + "root_id, " + p + "role, " + p + "content, " + p + "timestamp"
+}
+
+const insertMessage = `
+	INSERT INTO messages (id, thread_id, parent_id, root_id, role, content, timestamp)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+func (s *PostgresStorage) MoveSubtree(fromID string) (string, error) {
+	res, err := s.branch(fromID, true)
+	if err != nil {
+		return "", err
+	}
+	return res.ThreadID, nil
+}
+
+func (s *PostgresStorage) CopySubtree(fromID string) (*storage.BranchResult, error) {
+	return s.branch(fromID, false)
+}
+
+// branch copies the ancestry and subtree of fromID into a new thread in one
+// transaction, removing the subtree from the source when move is set. The
+// source rows are locked first so a concurrent edit cannot slip between the
+// copy and the delete.
+func (s *PostgresStorage) branch(fromID string, move bool) (*storage.BranchResult, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	orig, err := getMessage(tx, fromID, true)
+	if err != nil {
+		return nil, rollback(tx, fmt.Errorf("failed to find root message: %w", err))
+	}
+	if orig == nil {
+		return nil, rollback(tx, fmt.Errorf("message %w", storage.ErrNotFound))
+	}
+	ancestry, err := ancestors(tx, fromID)
+	if err != nil {
+		return nil, rollback(tx, err)
+	}
+	subtree, err := descendants(tx, fromID)
+	if err != nil {
+		return nil, rollback(tx, err)
+	}
+
+	// Parents before children
+	toCopy := append(append(ancestry, *orig), subtree...)
+	idMap := map[string]string{}
+	for _, m := range toCopy {
+		idMap[m.ID] = uuid.NewString()
+	}
+	rootNewID := idMap[toCopy[0].ID]
+
+	title := "Branched"
+	if orig.Content != "" {
+		preview := orig.Content
+		if len(preview) > 20 {
+			preview = preview[:20]
+		}
+		title = fmt.Sprintf("Branched: %s", preview)
+	}
+	newThreadID := uuid.NewString()
+	now := time.Now().Unix()
+	if _, err := tx.Exec(`
+		INSERT INTO threads (id, title, created_at, updated_at, owner_id, provider_id, model, system_prompt, temperature, max_tokens)
+		SELECT $1, $2, $3, $4, owner_id, provider_id, model, system_prompt, temperature, max_tokens FROM threads WHERE id = $5`,
+		newThreadID, title, now, now, orig.ThreadID); err != nil {
+		return nil, rollback(tx, fmt.Errorf("failed to create thread: %w", err))
+	}
+
+	for _, m := range toCopy {
+		var newParentID *string
+		if m.ParentID != nil {
+			if remapped, ok := idMap[*m.ParentID]; ok {
+				newParentID = &remapped
+			}
+		}
+		if _, err := tx.Exec(insertMessage,
+			idMap[m.ID], newThreadID, newParentID, rootNewID, m.Role, m.Content, m.Timestamp,
+		); err != nil {
+			return nil, rollback(tx, fmt.Errorf("insert failed for %s → %s: %w", m.ID, idMap[m.ID], err))
+		}
+	}
+
+	if move {
+		// Delete the moved messages leaves first, keeping their edit history
+		moved := append([]models.Message{*orig}, subtree...)
+		for i := len(moved) - 1; i >= 0; i-- {
+			id := moved[i].ID
+			if _, err := tx.Exec(`UPDATE message_revisions SET message_id = $1 WHERE message_id = $2`, idMap[id], id); err != nil {
+				return nil, rollback(tx, fmt.Errorf("failed to move revisions of %s: %w", id, err))
+			}
+			if _, err := tx.Exec(`DELETE FROM messages WHERE id = $1`, id); err != nil {
+				return nil, rollback(tx, fmt.Errorf("failed to delete %s: %w", id, err))
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &storage.BranchResult{ThreadID: newThreadID, IDMap: idMap}, nil
+}
+
+func (s *PostgresStorage) ListMessages(threadID string) ([]models.Message, error) {
+	return listMessages(s.db, threadID)
+}
+
+// listMessages loads every message in a thread through q.
+func listMessages(q querier, threadID string) ([]models.Message, error) {
+	rows, err := q.Query(`SELECT `+messageColumns+` FROM messages WHERE thread_id = $1 ORDER BY timestamp, id`, threadID)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// scanMessages reads rows selected with messageColumns and closes rows.
+func scanMessages(rows *sql.Rows) ([]models.Message, error) {
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	messages := make([]models.Message, 0)
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *m)
+	}
+	return messages, rows.Err()
+}
+
+func scanMessage(row interface{ Scan(...interface{}) error }) (*models.Message, error) {
+	var m models.Message
+	var parentID, rootID sql.NullString
+	if err := row.Scan(&m.ID, &m.ThreadID, &parentID, &rootID, &m.Role, &m.Content, &m.Timestamp); err != nil {
+		return nil, err
+	}
+	if parentID.Valid {
+		m.ParentID = &parentID.String
+	}
+	if rootID.Valid {
+		m.RootID = &rootID.String
+	}
+	return &m, nil
+}
+
+func (s *PostgresStorage) GetMessage(id string) (*models.Message, error) {
+	return getMessage(s.db, id, false)
+}
+
+// getMessage loads one message through q, which may be the database or a
+// transaction, optionally locking the row until the transaction ends. It
+// returns nil, nil if there is no such message.
+func getMessage(q querier, id string, lock bool) (*models.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE id = $1`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	m, err := scanMessage(q.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return m, err
+}
+
+func (s *PostgresStorage) CreateMessage(m models.Message) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := checkRefs(tx, m); err != nil {
+		return rollback(tx, err)
+	}
+	if _, err := tx.Exec(insertMessage,
+		m.ID, m.ThreadID, m.ParentID, m.RootID, m.Role, m.Content, m.Timestamp,
+	); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			err = fmt.Errorf("message %s: %w", m.ID, storage.ErrConflict)
+		}
+		return rollback(tx, err)
+	}
+	if _, err := tx.Exec(`UPDATE threads SET updated_at = $1 WHERE id = $2 AND updated_at < $1`,
+		m.Timestamp, m.ThreadID); err != nil {
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStorage) QueryMessages(q storage.MessageQuery) (*storage.MessagePage, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+	after, err := storage.DecodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	var args params
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE thread_id = ` + args.add(q.ThreadID)
+	if q.Role != "" {
+		query += ` AND role = ` + args.add(q.Role)
+	}
+	if q.Since != 0 {
+		query += ` AND timestamp >= ` + args.add(q.Since)
+	}
+	if q.Until != 0 {
+		query += ` AND timestamp <= ` + args.add(q.Until)
+	}
+	cmp, dir := ">", "ASC"
+	if q.Order == storage.OrderDesc {
+		cmp, dir = "<", "DESC"
+	}
+	if after != nil {
+		query += ` AND (timestamp, id) ` + cmp + ` (` + args.add(after.Value) + `, ` + args.add(after.ID) + `)`
+	}
+	query += ` ORDER BY timestamp ` + dir + `, id ` + dir
+	if q.Limit > 0 {
+		query += ` LIMIT ` + args.add(q.Limit+1)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	page := &storage.MessagePage{Items: msgs}
+	if q.Limit > 0 && len(msgs) > q.Limit {
+		page.Items = msgs[:q.Limit]
+		last := page.Items[q.Limit-1]
+		page.NextCursor = storage.EncodeCursor(storage.Cursor{Value: last.Timestamp, ID: last.ID})
+	}
+	return page, nil
+}
+
+func (s *PostgresStorage) DeleteMessage(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	var children int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM messages WHERE parent_id = $1`, id).Scan(&children); err != nil {
+		return rollback(tx, err)
+	}
+	if children > 0 {
+		return rollback(tx, storage.ErrHasChildren)
+	}
+	if _, err := tx.Exec(`DELETE FROM message_revisions WHERE message_id = $1`, id); err != nil {
+		return rollback(tx, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM messages WHERE id = $1`, id); err != nil {
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
+
+// checkRefs validates the thread, parent and root a message points at, so
+// callers get ErrInvalidReference rather than a raw constraint failure.
+func checkRefs(q querier, m models.Message) error {
+	var threads int
+	if err := q.QueryRow(`SELECT COUNT(*) FROM threads WHERE id = $1`, m.ThreadID).Scan(&threads); err != nil {
+		return err
+	}
+	if threads == 0 {
+		return fmt.Errorf("%w: thread %s does not exist", storage.ErrInvalidReference, m.ThreadID)
+	}
+	refs := []*string{m.ParentID}
+	// A root may point at itself, the way MoveSubtree leaves moved roots.
+	if m.RootID == nil || *m.RootID != m.ID {
+		refs = append(refs, m.RootID)
+	}
+	for _, ref := range refs {
+		if ref == nil {
+			continue
+		}
+		var threadID string
+		err := q.QueryRow(`SELECT thread_id FROM messages WHERE id = $1`, *ref).Scan(&threadID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: message %s does not exist", storage.ErrInvalidReference, *ref)
+		}
+		if err != nil {
+			return err
+		}
+		if threadID != m.ThreadID {
+			return fmt.Errorf("%w: message %s belongs to thread %s", storage.ErrInvalidReference, *ref, threadID)
+		}
+	}
+	return nil
+}
+
+func (s *PostgresStorage) DeleteSubtree(id string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	subtree, err := descendants(tx, id)
+	if err != nil {
+		return nil, rollback(tx, err)
+	}
+	deleted := []string{id}
+	for _, m := range subtree {
+		deleted = append(deleted, m.ID)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM message_revisions WHERE message_id = ANY($1)`, deleted); err != nil {
+		return nil, rollback(tx, err)
+	}
+	// A single statement, so links inside the subtree are checked only once
+	// every row is gone.
+	if _, err := tx.Exec(`DELETE FROM messages WHERE id = ANY($1)`, deleted); err != nil {
+		return nil, rollback(tx, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
@@ -0,0 +1,214 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration is one ordered schema step.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrationLock is the advisory lock key that serialises servers migrating
+// the same database at startup.
+const migrationLock = 0x7468726561647765 // "threadwe"
+
+// migrations must only ever be appended to; never edit a released step.
+//
+// IDs use the "C" collation so that ties in sort order break the same way
+// as in the other backends. Foreign keys are deferrable so that tree
+// rewrites can defer them to commit, as SQLite does with defer_foreign_keys.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "initial schema",
+		sql: `
+    CREATE TABLE threads (
+        id TEXT COLLATE "C" PRIMARY KEY,
+        title TEXT NOT NULL DEFAULT '',
+        created_at BIGINT NOT NULL DEFAULT 0,
+        updated_at BIGINT NOT NULL DEFAULT 0
+    );
+    CREATE INDEX idx_threads_created_at ON threads(created_at, id);
+    CREATE INDEX idx_threads_updated_at ON threads(updated_at, id);
+
+    CREATE TABLE messages (
+        id TEXT COLLATE "C" PRIMARY KEY,
+        thread_id TEXT COLLATE "C" NOT NULL REFERENCES threads(id) DEFERRABLE,
+        parent_id TEXT COLLATE "C" REFERENCES messages(id) DEFERRABLE,
+        root_id TEXT COLLATE "C" REFERENCES messages(id) DEFERRABLE,
+        role TEXT NOT NULL DEFAULT '',
+        content TEXT NOT NULL DEFAULT '',
+        timestamp BIGINT NOT NULL DEFAULT 0
+    );
+    CREATE INDEX idx_messages_parent_id ON messages(parent_id);
+    CREATE INDEX idx_messages_thread_id ON messages(thread_id, timestamp, id);
+
+    CREATE TABLE message_revisions (
+        id TEXT COLLATE "C" PRIMARY KEY,
+        message_id TEXT COLLATE "C" NOT NULL REFERENCES messages(id) DEFERRABLE,
+        version INTEGER NOT NULL,
+        role TEXT NOT NULL DEFAULT '',
+        content TEXT NOT NULL DEFAULT '',
+        timestamp BIGINT NOT NULL DEFAULT 0,
+        UNIQUE(message_id, version)
+    );
+
+    CREATE TABLE settings (
+        id TEXT PRIMARY KEY,
+        llm_provider TEXT NOT NULL DEFAULT '',
+        llm_endpoint TEXT NOT NULL DEFAULT '',
+        llm_api_key TEXT NOT NULL DEFAULT '',
+        llm_model TEXT NOT NULL DEFAULT '',
+        simulate_only BOOLEAN NOT NULL DEFAULT FALSE
+    );`,
+	},
+	{
+		version: 2,
+		name:    "users and thread owners",
+		sql: `
+    CREATE TABLE users (
+        id TEXT COLLATE "C" PRIMARY KEY,
+        username TEXT NOT NULL UNIQUE,
+        password_hash TEXT NOT NULL,
+        created_at BIGINT NOT NULL DEFAULT 0
+    );
+    CREATE TABLE sessions (
+        id TEXT COLLATE "C" PRIMARY KEY,
+        user_id TEXT COLLATE "C" NOT NULL REFERENCES users(id),
+        created_at BIGINT NOT NULL DEFAULT 0,
+        expires_at BIGINT NOT NULL DEFAULT 0
+    );
+    CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+    ALTER TABLE threads ADD COLUMN owner_id TEXT COLLATE "C" NOT NULL DEFAULT '';
+    CREATE INDEX idx_threads_owner_id ON threads(owner_id, created_at, id);`,
+	},
+	{
+		version: 3,
+		name:    "access tokens",
+		sql: `
+    CREATE TABLE access_tokens (
+        id TEXT COLLATE "C" PRIMARY KEY,
+        user_id TEXT COLLATE "C" NOT NULL REFERENCES users(id),
+        name TEXT NOT NULL DEFAULT '',
+        token_hash TEXT COLLATE "C" NOT NULL UNIQUE,
+        scopes TEXT NOT NULL DEFAULT '',
+        created_at BIGINT NOT NULL DEFAULT 0,
+        expires_at BIGINT NOT NULL DEFAULT 0
+    );
+    CREATE INDEX idx_access_tokens_user_id ON access_tokens(user_id, created_at, id);`,
+	},
+	{
+		version: 4,
+		name:    "provider profiles",
+		sql: `
+    CREATE TABLE provider_profiles (
+        id TEXT COLLATE "C" PRIMARY KEY,
+        owner_id TEXT COLLATE "C" NOT NULL DEFAULT '',
+        name TEXT NOT NULL,
+        llm_provider TEXT NOT NULL DEFAULT '',
+        llm_endpoint TEXT NOT NULL DEFAULT '',
+        llm_api_key TEXT NOT NULL DEFAULT '',
+        llm_model TEXT NOT NULL DEFAULT '',
+        is_default BOOLEAN NOT NULL DEFAULT FALSE,
+        created_at BIGINT NOT NULL DEFAULT 0,
+        updated_at BIGINT NOT NULL DEFAULT 0
+    );
+    CREATE INDEX idx_provider_profiles_owner_id ON provider_profiles(owner_id, created_at, id);
+    CREATE UNIQUE INDEX idx_provider_profiles_default ON provider_profiles(owner_id) WHERE is_default;
+    ALTER TABLE threads ADD COLUMN provider_id TEXT COLLATE "C" NOT NULL DEFAULT '';`,
+	},
+	{
+		version: 5,
+		name:    "thread reply settings",
+		sql: `
+    ALTER TABLE threads
+        ADD COLUMN model TEXT NOT NULL DEFAULT '',
+        ADD COLUMN system_prompt TEXT NOT NULL DEFAULT '',
+        ADD COLUMN temperature DOUBLE PRECISION,
+        ADD COLUMN max_tokens INTEGER NOT NULL DEFAULT 0;`,
+	},
+}
+
+// LatestSchemaVersion is the schema version this build migrates databases to.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func ensureVersionTable(q querier) error {
+	_, err := q.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT,
+			applied_at BIGINT
+		)
+	`)
+	return err
+}
+
+func schemaVersion(q querier) (int, error) {
+	var version sql.NullInt64
+	if err := q.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// SchemaVersion reports the highest migration applied to the database,
+// or 0 for an empty one. It only reads.
+func (s *PostgresStorage) SchemaVersion() (int, error) {
+	var exists bool
+	if err := s.db.QueryRow(`SELECT to_regclass('schema_version') IS NOT NULL`).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+	return schemaVersion(s.db)
+}
+
+// Migrate applies every pending migration in one transaction and returns
+// the versions it applied. An advisory lock makes concurrent callers wait
+// for each other, so each step runs once.
+func (s *PostgresStorage) Migrate() ([]int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, int64(migrationLock)); err != nil {
+		return nil, rollback(tx, err)
+	}
+	if err := ensureVersionTable(tx); err != nil {
+		return nil, rollback(tx, err)
+	}
+	current, err := schemaVersion(tx)
+	if err != nil {
+		return nil, rollback(tx, err)
+	}
+	if current > LatestSchemaVersion() {
+		return nil, rollback(tx, fmt.Errorf("database schema version %d is newer than this build supports (%d)", current, LatestSchemaVersion()))
+	}
+
+	var applied []int
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if _, err := tx.Exec(m.sql); err != nil {
+			return nil, rollback(tx, fmt.Errorf("migration %d (%s): %w", m.version, m.name, err))
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES ($1, $2, $3)`,
+			m.version, m.name, time.Now().Unix()); err != nil {
+			return nil, rollback(tx, err)
+		}
+		applied = append(applied, m.version)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
@@ -0,0 +1,88 @@
+// Package postgres stores threads in PostgreSQL, so that several ThreadWell
+// servers can share one database.
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" driver
+
+	"github.com/krackenservices/threadwell/storage"
+)
+
+type PostgresStorage struct {
+	db *sql.DB
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// New connects to the database at dsn (a postgres:// URL or key=value
+// string) and brings its schema up to date.
+func New(dsn string) (storage.Storage, error) {
+	s, err := Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Init(); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Open connects to the database without touching its schema, for tooling
+// such as the migrate command that needs to inspect it first.
+func Open(dsn string) (*PostgresStorage, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("connect to postgres: %w", err)
+	}
+	return &PostgresStorage{db: db}, nil
+}
+
+// Init brings the schema up to date. It refuses to run against a database
+// written by a newer build.
+func (s *PostgresStorage) Init() error {
+	applied, err := s.Migrate()
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		log.Printf("postgres: applied schema migrations %v (now at version %d)", applied, LatestSchemaVersion())
+	}
+	return nil
+}
+
+// Close releases the connection pool.
+func (s *PostgresStorage) Close() error {
+	return s.db.Close()
+}
+
+// rollback aborts tx and returns err, noting any rollback failure alongside it.
+func rollback(tx *sql.Tx, err error) error {
+	if rollbackErr := tx.Rollback(); rollbackErr != nil {
+		return fmt.Errorf("%w; additionally failed to rollback transaction: %v", err, rollbackErr)
+	}
+	return err
+}
+
+// params numbers the placeholders of a query built up piece by piece.
+type params []interface{}
+
+// add appends v and returns its placeholder.
+func (p *params) add(v interface{}) string {
+	*p = append(*p, v)
+	return "$" + strconv.Itoa(len(*p))
+}
@@ -0,0 +1,160 @@
+package postgres_test
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/krackenservices/threadwell/storage/postgres"
+	"github.com/krackenservices/threadwell/testhelpers"
+	"github.com/stretchr/testify/require"
+)
+
+// dsnEnv names an existing server to test against instead of starting one.
+const dsnEnv = "THREADWELL_TEST_POSTGRES_DSN"
+
+func TestPostgresStorage(t *testing.T) {
+	store, err := postgres.New(testDSN(t))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.(*postgres.PostgresStorage).Close() })
+
+	testhelpers.RunStorageSuite(t, "postgres", store)
+	testhelpers.RunDeleteSubtreeSuite(t, "postgres", store)
+	testhelpers.RunIntegritySuite(t, "postgres", store)
+	testhelpers.RunMoveSubtreeSuite(t, "postgres", store)
+	testhelpers.RunCopySubtreeSuite(t, "postgres", store)
+	testhelpers.RunGraftSubtreeSuite(t, "postgres", store)
+	testhelpers.RunMergeThreadsSuite(t, "postgres", store)
+	testhelpers.RunTreeSuite(t, "postgres", store)
+	testhelpers.RunAncestrySuite(t, "postgres", store)
+	testhelpers.RunPaginationSuite(t, "postgres", store)
+	testhelpers.RunSearchSuite(t, "postgres", store)
+	testhelpers.RunSettingsSuite(t, "postgres", store)
+	testhelpers.RunUserSuite(t, "postgres", store)
+	testhelpers.RunOwnershipSuite(t, "postgres", store)
+	testhelpers.RunProviderSuite(t, "postgres", store)
+}
+
+func TestPostgresMigrationsAreIdempotent(t *testing.T) {
+	dsn := testDSN(t)
+	first, err := postgres.Open(dsn)
+	require.NoError(t, err)
+	defer first.Close()
+	applied, err := first.Migrate()
+	require.NoError(t, err)
+	require.NotEmpty(t, applied)
+
+	second, err := postgres.Open(dsn)
+	require.NoError(t, err)
+	defer second.Close()
+	applied, err = second.Migrate()
+	require.NoError(t, err)
+	require.Empty(t, applied)
+	version, err := second.SchemaVersion()
+	require.NoError(t, err)
+	require.Equal(t, postgres.LatestSchemaVersion(), version)
+}
+
+// testDSN returns a DSN for an empty schema of its own, dropped when the
+// test ends. It uses the server named by THREADWELL_TEST_POSTGRES_DSN, or
+// else starts a throwaway one with initdb and pg_ctl, and skips the test if
+// neither is possible.
+func testDSN(t *testing.T) string {
+	t.Helper()
+	base := os.Getenv(dsnEnv)
+	if base == "" {
+		base = startServer(t)
+	}
+
+	db, err := sql.Open("pgx", base)
+	require.NoError(t, err)
+	defer db.Close()
+	schema := "threadwell_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	_, err = db.Exec(`CREATE SCHEMA ` + schema)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db, err := sql.Open("pgx", base)
+		if err != nil {
+			return
+		}
+		defer db.Close()
+		_, _ = db.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+	})
+	return withSearchPath(base, schema)
+}
+
+// withSearchPath adds a search_path runtime parameter to either DSN form.
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		return dsn + sep + "search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
+
+// startServer runs a private PostgreSQL cluster for the rest of the test
+// binary's life.
+func startServer(t *testing.T) string {
+	t.Helper()
+	serverOnce.Do(func() { serverDSN, serverErr = launch() })
+	if serverErr != nil {
+		t.Skipf("no PostgreSQL available (set %s to use an existing server): %v", dsnEnv, serverErr)
+	}
+	return serverDSN
+}
+
+func launch() (string, error) {
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		return "", err
+	}
+	pgctl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		return "", err
+	}
+	if os.Geteuid() == 0 {
+		return "", fmt.Errorf("initdb cannot run as root")
+	}
+
+	// Unix socket paths are short, so keep the directory near the root.
+	dir, err := os.MkdirTemp("", "twpg")
+	if err != nil {
+		return "", err
+	}
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "--auth=trust", "--no-sync").CombinedOutput(); err != nil {
+		return "", fmt.Errorf("initdb: %v: %s", err, out)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return "", err
+	}
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses='' -c fsync=off", port, dir)
+	if out, err := exec.Command(pgctl, "-D", data, "-o", opts, "-l", filepath.Join(dir, "log"), "-w", "start").CombinedOutput(); err != nil {
+		return "", fmt.Errorf("pg_ctl start: %v: %s", err, out)
+	}
+	stopServer = func() {
+		_ = exec.Command(pgctl, "-D", data, "-m", "immediate", "stop").Run()
+		_ = os.RemoveAll(dir)
+	}
+	return fmt.Sprintf("host=%s port=%d user=postgres dbname=postgres sslmode=disable", dir, port), nil
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,133 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+const providerColumns = `id, owner_id, name, llm_provider, llm_endpoint, llm_api_key, llm_model, is_default, created_at, updated_at`
+
+func (s *PostgresStorage) ListProviderProfiles(ownerID string) ([]models.ProviderProfile, error) {
+	rows, err := s.db.Query(`SELECT `+providerColumns+` FROM provider_profiles WHERE owner_id = $1 ORDER BY created_at, id`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	return scanProviders(rows)
+}
+
+func (s *PostgresStorage) GetProviderProfile(id string) (*models.ProviderProfile, error) {
+	rows, err := s.db.Query(`SELECT `+providerColumns+` FROM provider_profiles WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := scanProviders(rows)
+	if err != nil || len(profiles) == 0 {
+		return nil, err
+	}
+	return &profiles[0], nil
+}
+
+func (s *PostgresStorage) CreateProviderProfile(p models.ProviderProfile) error {
+	if p.UpdatedAt == 0 {
+		p.UpdatedAt = p.CreatedAt
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := clearDefault(tx, p); err != nil {
+		return rollback(tx, err)
+	}
+	_, err = tx.Exec(`INSERT INTO provider_profiles (`+providerColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		p.ID, p.OwnerID, p.Name, p.LLMProvider, p.LLMEndpoint, p.LLMApiKey, p.LLMName, p.IsDefault, p.CreatedAt, p.UpdatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			err = storage.ErrConflict
+		}
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStorage) UpdateProviderProfile(p models.ProviderProfile) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	var owner string
+	if err := tx.QueryRow(`SELECT owner_id FROM provider_profiles WHERE id = $1 FOR UPDATE`, p.ID).Scan(&owner); err != nil {
+		if err == sql.ErrNoRows {
+			err = storage.ErrNotFound
+		}
+		return rollback(tx, err)
+	}
+	if p.OwnerID == "" {
+		p.OwnerID = owner
+	}
+	if err := clearDefault(tx, p); err != nil {
+		return rollback(tx, err)
+	}
+	_, err = tx.Exec(`
+		UPDATE provider_profiles
+		SET owner_id = $1, name = $2, llm_provider = $3, llm_endpoint = $4, llm_api_key = $5, llm_model = $6, is_default = $7, updated_at = $8
+		WHERE id = $9`,
+		p.OwnerID, p.Name, p.LLMProvider, p.LLMEndpoint, p.LLMApiKey, p.LLMName, p.IsDefault, p.UpdatedAt, p.ID)
+	if err != nil {
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStorage) DeleteProviderProfile(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM provider_profiles WHERE id = $1`, id)
+	if err != nil {
+		return rollback(tx, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return rollback(tx, err)
+	}
+	if n == 0 {
+		return rollback(tx, storage.ErrNotFound)
+	}
+	if _, err := tx.Exec(`UPDATE threads SET provider_id = '' WHERE provider_id = $1`, id); err != nil {
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
+
+// clearDefault unsets the owner's current default when p is the new one.
+func clearDefault(tx *sql.Tx, p models.ProviderProfile) error {
+	if !p.IsDefault {
+		return nil
+	}
+	_, err := tx.Exec(`UPDATE provider_profiles SET is_default = FALSE WHERE owner_id = $1 AND is_default AND id <> $2`, p.OwnerID, p.ID)
+	return err
+}
+
+// scanProviders reads rows selected with providerColumns and closes rows.
+func scanProviders(rows *sql.Rows) ([]models.ProviderProfile, error) {
+	defer func() {
+		_ = rows.Close()
+	}()
+	profiles := make([]models.ProviderProfile, 0)
+	for rows.Next() {
+		var p models.ProviderProfile
+		if err := rows.Scan(&p.ID, &p.OwnerID, &p.Name, &p.LLMProvider, &p.LLMEndpoint, &p.LLMApiKey, &p.LLMName,
+			&p.IsDefault, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
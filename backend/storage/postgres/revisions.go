@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+func (s *PostgresStorage) UpdateMessage(m models.Message) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Lock the row so concurrent edits number their revisions in turn.
+	var prevRole, prevContent string
+	err = tx.QueryRow(`SELECT role, content FROM messages WHERE id = $1 FOR UPDATE`, m.ID).Scan(&prevRole, &prevContent)
+	if err == sql.ErrNoRows {
+		return rollback(tx, storage.ErrNotFound)
+	}
+	if err != nil {
+		return rollback(tx, err)
+	}
+
+	var version int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) + 1 FROM message_revisions WHERE message_id = $1`, m.ID).Scan(&version); err != nil {
+		return rollback(tx, err)
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO message_revisions (id, message_id, version, role, content, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		uuid.NewString(), m.ID, version, prevRole, prevContent, time.Now().Unix(),
+	); err != nil {
+		return rollback(tx, err)
+	}
+
+	if _, err := tx.Exec(`UPDATE messages SET role = $1, content = $2 WHERE id = $3`, m.Role, m.Content, m.ID); err != nil {
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStorage) ListMessageRevisions(messageID string) ([]models.MessageRevision, error) {
+	rows, err := s.db.Query(`
+		SELECT id, message_id, version, role, content, timestamp
+		FROM message_revisions WHERE message_id = $1 ORDER BY version`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	revs := make([]models.MessageRevision, 0)
+	for rows.Next() {
+		var r models.MessageRevision
+		if err := rows.Scan(&r.ID, &r.MessageID, &r.Version, &r.Role, &r.Content, &r.Timestamp); err != nil {
+			return nil, err
+		}
+		revs = append(revs, r)
+	}
+	return revs, rows.Err()
+}
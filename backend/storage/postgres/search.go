@@ -0,0 +1,80 @@
+package postgres
+
+import (
+	"strings"
+
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+// Search narrows candidates with ILIKE on the first word and ranks them with
+// the tokenized matcher shared with the memory backend, so results and
+// snippets are the same whichever backend serves them.
+func (s *PostgresStorage) Search(q storage.SearchQuery) ([]storage.SearchResult, error) {
+	terms, err := storage.ParseSearch(q.Text)
+	if err != nil {
+		return nil, err
+	}
+	// ILIKE folds case by the database locale, which may not cover
+	// non-ASCII letters, so such a word cannot narrow the scan.
+	like := "%"
+	if w := terms[0][0]; isASCII(w) {
+		like = "%" + escapeLike(w) + "%"
+	}
+
+	var threadArgs params
+	threadQuery := `SELECT ` + threadColumns + ` FROM threads`
+	var where []string
+	if q.ThreadID != "" {
+		where = append(where, `id = `+threadArgs.add(q.ThreadID))
+	}
+	if q.OwnerID != "" {
+		where = append(where, `owner_id = `+threadArgs.add(q.OwnerID))
+	}
+	if len(where) > 0 {
+		threadQuery += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	rows, err := s.db.Query(threadQuery, threadArgs...)
+	if err != nil {
+		return nil, err
+	}
+	threads, err := scanThreads(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgArgs params
+	msgQuery := `SELECT ` + messageColumns + ` FROM messages WHERE content ILIKE ` + msgArgs.add(like)
+	if q.Role != "" {
+		msgQuery += ` AND role = ` + msgArgs.add(q.Role)
+	}
+	if q.ThreadID != "" {
+		msgQuery += ` AND thread_id = ` + msgArgs.add(q.ThreadID)
+	}
+	if q.OwnerID != "" {
+		msgQuery += ` AND thread_id IN (SELECT id FROM threads WHERE owner_id = ` + msgArgs.add(q.OwnerID) + `)`
+	}
+	rows, err = s.db.Query(msgQuery, msgArgs...)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []models.Message
+	if msgs, err = scanMessages(rows); err != nil {
+		return nil, err
+	}
+	return storage.SearchDocuments(threads, msgs, q)
+}
+
+// escapeLike escapes ILIKE wildcards; backslash is the default escape.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
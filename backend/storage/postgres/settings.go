@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"database/sql"
+
+	"github.com/krackenservices/threadwell/models"
+)
+
+func (s *PostgresStorage) GetSettings(id string) (*models.Settings, error) {
+	row := s.db.QueryRow(`SELECT id, llm_provider, llm_endpoint, llm_api_key, llm_model, simulate_only FROM settings WHERE id = $1`, id)
+
+	var cfg models.Settings
+	err := row.Scan(&cfg.ID, &cfg.LLMProvider, &cfg.LLMEndpoint, &cfg.LLMApiKey, &cfg.LLMName, &cfg.SimulateOnly)
+	if err == sql.ErrNoRows {
+		cfg = models.Settings{
+			ID:           id,
+			LLMProvider:  "ollama",
+			LLMEndpoint:  "http://localhost:11434",
+			LLMName:      "llama3",
+			SimulateOnly: true,
+		}
+		_ = s.UpdateSettings(cfg)
+		return &cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (s *PostgresStorage) UpdateSettings(cfg models.Settings) error {
+	_, err := s.db.Exec(`
+		INSERT INTO settings (id, llm_provider, llm_endpoint, llm_api_key, llm_model, simulate_only)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE SET
+			llm_provider = excluded.llm_provider,
+			llm_endpoint = excluded.llm_endpoint,
+			llm_api_key = excluded.llm_api_key,
+			llm_model = excluded.llm_model,
+			simulate_only = excluded.simulate_only
+	`, cfg.ID, cfg.LLMProvider, cfg.LLMEndpoint, cfg.LLMApiKey, cfg.LLMName, cfg.SimulateOnly)
+	return err
+}
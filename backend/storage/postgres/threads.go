@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+const threadColumns = `id, title, created_at, updated_at, owner_id, provider_id, model, system_prompt, temperature, max_tokens`
+
+func (s *PostgresStorage) ListThreads() ([]models.Thread, error) {
+	rows, err := s.db.Query(`SELECT ` + threadColumns + ` FROM threads ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanThreads(rows)
+}
+
+func (s *PostgresStorage) QueryThreads(q storage.ThreadQuery) (*storage.ThreadPage, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+	after, err := storage.DecodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	// SortBy is one of two known column names after Normalize.
+	col := q.SortBy
+	query := `SELECT ` + threadColumns + ` FROM threads`
+	var where []string
+	var args params
+	cmp, dir := ">", "ASC"
+	if q.Order == storage.OrderDesc {
+		cmp, dir = "<", "DESC"
+	}
+	if q.OwnerID != "" {
+		where = append(where, `owner_id = `+args.add(q.OwnerID))
+	}
+	if after != nil {
+		where = append(where, `(`+col+`, id) `+cmp+` (`+args.add(after.Value)+`, `+args.add(after.ID)+`)`)
+	}
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += ` ORDER BY ` + col + ` ` + dir + `, id ` + dir
+	if q.Limit > 0 {
+		query += ` LIMIT ` + args.add(q.Limit+1)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	threads, err := scanThreads(rows)
+	if err != nil {
+		return nil, err
+	}
+	page := &storage.ThreadPage{Items: threads}
+	if q.Limit > 0 && len(threads) > q.Limit {
+		page.Items = threads[:q.Limit]
+		last := page.Items[q.Limit-1]
+		value := last.CreatedAt
+		if q.SortBy == storage.SortUpdatedAt {
+			value = last.UpdatedAt
+		}
+		page.NextCursor = storage.EncodeCursor(storage.Cursor{Value: value, ID: last.ID})
+	}
+	return page, nil
+}
+
+// threadFields returns the scan destinations for threadColumns.
+func threadFields(t *models.Thread) []interface{} {
+	return []interface{}{&t.ID, &t.Title, &t.CreatedAt, &t.UpdatedAt, &t.OwnerID,
+		&t.ProviderID, &t.Model, &t.SystemPrompt, &t.Temperature, &t.MaxTokens}
+}
+
+// scanThreads reads rows selected with threadColumns and closes rows.
+func scanThreads(rows *sql.Rows) ([]models.Thread, error) {
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	threads := make([]models.Thread, 0)
+	for rows.Next() {
+		var t models.Thread
+		if err := rows.Scan(threadFields(&t)...); err != nil {
+			return nil, err
+		}
+		threads = append(threads, t)
+	}
+	return threads, rows.Err()
+}
+
+func (s *PostgresStorage) GetThread(id string) (*models.Thread, error) {
+	row := s.db.QueryRow(`SELECT `+threadColumns+` FROM threads WHERE id = $1`, id)
+	var t models.Thread
+	if err := row.Scan(threadFields(&t)...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *PostgresStorage) CreateThread(t models.Thread) error {
+	if t.UpdatedAt == 0 {
+		t.UpdatedAt = t.CreatedAt
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO threads (id, title, created_at, updated_at, owner_id, provider_id, model, system_prompt, temperature, max_tokens)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		t.ID, t.Title, t.CreatedAt, t.UpdatedAt, t.OwnerID, t.ProviderID, t.Model, t.SystemPrompt, t.Temperature, t.MaxTokens)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return fmt.Errorf("thread %s: %w", t.ID, storage.ErrConflict)
+	}
+	return err
+}
+
+func (s *PostgresStorage) UpdateThread(t models.Thread) error {
+	var args params
+	query := `UPDATE threads SET title = ` + args.add(t.Title) +
+		`, provider_id = ` + args.add(t.ProviderID) +
+		`, model = ` + args.add(t.Model) +
+		`, system_prompt = ` + args.add(t.SystemPrompt) +
+		`, temperature = ` + args.add(t.Temperature) +
+		`, max_tokens = ` + args.add(t.MaxTokens)
+	if t.UpdatedAt != 0 {
+		query += `, updated_at = ` + args.add(t.UpdatedAt)
+	}
+	if t.OwnerID != "" {
+		query += `, owner_id = ` + args.add(t.OwnerID)
+	}
+	_, err := s.db.Exec(query+` WHERE id = `+args.add(t.ID), args...)
+	return err
+}
+
+func (s *PostgresStorage) DeleteThread(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM message_revisions
+		WHERE message_id IN (SELECT id FROM messages WHERE thread_id = $1)`, id); err != nil {
+		return rollback(tx, err)
+	}
+	// One statement removes every message, so parent links among them never
+	// dangle when the constraint is checked.
+	if _, err := tx.Exec(`DELETE FROM messages WHERE thread_id = $1`, id); err != nil {
+		return rollback(tx, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM threads WHERE id = $1`, id); err != nil {
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+func (s *PostgresStorage) Ancestors(id string) ([]models.Message, error) {
+	return ancestors(s.db, id)
+}
+
+func (s *PostgresStorage) Descendants(id string) ([]models.Message, error) {
+	return descendants(s.db, id)
+}
+
+// ancestors walks parent_id upwards in a single recursive query and returns
+// the chain root first. The starting row is selected too so that an unknown
+// id can be told apart from a root.
+func ancestors(q querier, id string) ([]models.Message, error) {
+	rows, err := q.Query(`
+		WITH RECURSIVE chain(id, parent_id, depth) AS (
+			SELECT id, parent_id, 0 FROM messages WHERE id = $1
+			UNION ALL
+			SELECT m.id, m.parent_id, c.depth + 1
+			FROM messages m JOIN chain c ON m.id = c.parent_id
+		)
+		SELECT `+messageColumnsOf("m")+`
+		FROM chain c JOIN messages m ON m.id = c.id
+		ORDER BY c.depth DESC`, id)
+	if err != nil {
+		return nil, fmt.Errorf("query ancestors: %w", err)
+	}
+	chain, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("message %w", storage.ErrNotFound)
+	}
+	return chain[:len(chain)-1], nil
+}
+
+// descendants collects the subtree below id in a single recursive query,
+// level by level with each level ordered by timestamp and ID.
+func descendants(q querier, id string) ([]models.Message, error) {
+	rows, err := q.Query(`
+		WITH RECURSIVE sub(id, depth) AS (
+			SELECT id, 0 FROM messages WHERE id = $1
+			UNION ALL
+			SELECT m.id, s.depth + 1
+			FROM messages m JOIN sub s ON m.parent_id = s.id
+		)
+		SELECT `+messageColumnsOf("m")+`
+		FROM sub s JOIN messages m ON m.id = s.id
+		ORDER BY s.depth, m.timestamp, m.id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("query descendants: %w", err)
+	}
+	subtree, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(subtree) == 0 {
+		return nil, fmt.Errorf("message %w", storage.ErrNotFound)
+	}
+	return subtree[1:], nil
+}
@@ -0,0 +1,154 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+const userColumns = `id, username, password_hash, created_at`
+
+// uniqueViolation is the SQLSTATE of a unique constraint failure.
+const uniqueViolation = "23505"
+
+func (s *PostgresStorage) CreateUser(u models.User) error {
+	_, err := s.db.Exec(`INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4)`,
+		u.ID, u.Username, u.PasswordHash, u.CreatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return storage.ErrConflict
+	}
+	return err
+}
+
+func (s *PostgresStorage) GetUser(id string) (*models.User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+}
+
+func (s *PostgresStorage) GetUserByUsername(username string) (*models.User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = $1`, username))
+}
+
+func (s *PostgresStorage) ListUsers() ([]models.User, error) {
+	rows, err := s.db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	users := make([]models.User, 0)
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func scanUser(row *sql.Row) (*models.User, error) {
+	var u models.User
+	if err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *PostgresStorage) CreateSession(sess models.Session) error {
+	_, err := s.db.Exec(`INSERT INTO sessions (id, user_id, created_at, expires_at) VALUES ($1, $2, $3, $4)`,
+		sess.ID, sess.UserID, sess.CreatedAt, sess.ExpiresAt)
+	return err
+}
+
+func (s *PostgresStorage) GetSession(id string) (*models.Session, error) {
+	var sess models.Session
+	err := s.db.QueryRow(`SELECT id, user_id, created_at, expires_at FROM sessions WHERE id = $1`, id).
+		Scan(&sess.ID, &sess.UserID, &sess.CreatedAt, &sess.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func (s *PostgresStorage) DeleteSession(id string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE id = $1`, id)
+	return err
+}
+
+// Scopes are stored space-separated, as in OAuth.
+const tokenColumns = `id, user_id, name, token_hash, scopes, created_at, expires_at`
+
+func (s *PostgresStorage) CreateAccessToken(t models.AccessToken) error {
+	_, err := s.db.Exec(`INSERT INTO access_tokens (`+tokenColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		t.ID, t.UserID, t.Name, t.TokenHash, strings.Join(t.Scopes, " "), t.CreatedAt, t.ExpiresAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return storage.ErrConflict
+	}
+	return err
+}
+
+func (s *PostgresStorage) GetAccessToken(hash string) (*models.AccessToken, error) {
+	rows, err := s.db.Query(`SELECT `+tokenColumns+` FROM access_tokens WHERE token_hash = $1`, hash)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := scanTokens(rows)
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	return &tokens[0], nil
+}
+
+func (s *PostgresStorage) ListAccessTokens(userID string) ([]models.AccessToken, error) {
+	rows, err := s.db.Query(`SELECT `+tokenColumns+` FROM access_tokens WHERE user_id = $1 ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanTokens(rows)
+}
+
+func (s *PostgresStorage) DeleteAccessToken(userID, id string) error {
+	res, err := s.db.Exec(`DELETE FROM access_tokens WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// scanTokens reads rows selected with tokenColumns and closes rows.
+func scanTokens(rows *sql.Rows) ([]models.AccessToken, error) {
+	defer func() {
+		_ = rows.Close()
+	}()
+	tokens := make([]models.AccessToken, 0)
+	for rows.Next() {
+		var t models.AccessToken
+		var scopes string
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &scopes, &t.CreatedAt, &t.ExpiresAt); err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Fields(scopes)
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/krackenservices/threadwell/models"
+)
+
+// ErrInvalidQuery is returned for an unknown sort field or order, a negative
+// limit, or a cursor that was not produced by a previous page.
+var ErrInvalidQuery = errors.New("invalid query")
+
+const (
+	SortCreatedAt = "created_at"
+	SortUpdatedAt = "updated_at"
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// ThreadQuery selects one page of threads. The zero value lists every thread
+// oldest first.
+type ThreadQuery struct {
+	OwnerID string // only threads of this user; empty means all
+	Limit   int    // 0 means no limit
+	Cursor  string // NextCursor of the previous page
+	SortBy  string // SortCreatedAt (default) or SortUpdatedAt
+	Order   string // OrderAsc (default) or OrderDesc
+}
+
+// MessageQuery selects one page of a thread's messages, optionally filtered
+// by role and by an inclusive timestamp range. Messages sort by created_at
+// (their timestamp) only.
+type MessageQuery struct {
+	ThreadID string
+	Role     string
+	Since    int64 // 0 means unbounded
+	Until    int64 // 0 means unbounded
+	Limit    int
+	Cursor   string
+	SortBy   string
+	Order    string
+}
+
+// ThreadPage is one page of threads. NextCursor is empty on the last page.
+type ThreadPage struct {
+	Items      []models.Thread `json:"items"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// MessagePage is one page of messages. NextCursor is empty on the last page.
+type MessagePage struct {
+	Items      []models.Message `json:"items"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// Cursor marks the last item of a page by its sort value and ID, so the next
+// page starts strictly after it even when sort values tie.
+type Cursor struct {
+	Value int64
+	ID    string
+}
+
+// EncodeCursor returns the opaque form of c handed to clients.
+func EncodeCursor(c Cursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.Value, 10) + ":" + c.ID))
+}
+
+// DecodeCursor parses a cursor produced by EncodeCursor. The empty string
+// decodes to nil, meaning "start from the beginning".
+func DecodeCursor(s string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	value, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return &Cursor{Value: v, ID: id}, nil
+}
+
+// Normalize fills in defaults and rejects unknown options.
+func (q *ThreadQuery) Normalize() error {
+	if q.SortBy == "" {
+		q.SortBy = SortCreatedAt
+	}
+	if q.SortBy != SortCreatedAt && q.SortBy != SortUpdatedAt {
+		return fmt.Errorf("%w: threads sort by created_at or updated_at", ErrInvalidQuery)
+	}
+	return normalizePage(q.Limit, &q.Order)
+}
+
+// Normalize fills in defaults and rejects unknown options.
+func (q *MessageQuery) Normalize() error {
+	if q.SortBy == "" {
+		q.SortBy = SortCreatedAt
+	}
+	if q.SortBy != SortCreatedAt {
+		return fmt.Errorf("%w: messages sort by created_at", ErrInvalidQuery)
+	}
+	if q.Since != 0 && q.Until != 0 && q.Since > q.Until {
+		return fmt.Errorf("%w: since is after until", ErrInvalidQuery)
+	}
+	return normalizePage(q.Limit, &q.Order)
+}
+
+func normalizePage(limit int, order *string) error {
+	if limit < 0 {
+		return fmt.Errorf("%w: limit must not be negative", ErrInvalidQuery)
+	}
+	if *order == "" {
+		*order = OrderAsc
+	}
+	if *order != OrderAsc && *order != OrderDesc {
+		return fmt.Errorf("%w: order must be asc or desc", ErrInvalidQuery)
+	}
+	return nil
+}
+
+// sortValue returns the field a thread is ordered by.
+func (q ThreadQuery) sortValue(t models.Thread) int64 {
+	if q.SortBy == SortUpdatedAt {
+		return t.UpdatedAt
+	}
+	return t.CreatedAt
+}
+
+// PageThreads applies a normalized query to an unordered set of threads.
+// Backends that cannot push the query down to their store use it, so that
+// they order and split pages exactly as SQLite does.
+func PageThreads(all []models.Thread, q ThreadQuery) (*ThreadPage, error) {
+	var matched []models.Thread
+	for _, t := range all {
+		if q.OwnerID == "" || t.OwnerID == q.OwnerID {
+			matched = append(matched, t)
+		}
+	}
+	keys := make([]Cursor, len(matched))
+	for i, t := range matched {
+		keys[i] = Cursor{Value: q.sortValue(t), ID: t.ID}
+	}
+	idx, next, err := page(keys, q.Cursor, q.Order, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	out := &ThreadPage{Items: make([]models.Thread, 0, len(idx)), NextCursor: next}
+	for _, i := range idx {
+		out.Items = append(out.Items, matched[i])
+	}
+	return out, nil
+}
+
+// PageMessages filters and pages a thread's messages the same way.
+func PageMessages(all []models.Message, q MessageQuery) (*MessagePage, error) {
+	var matched []models.Message
+	for _, m := range all {
+		if m.ThreadID != q.ThreadID ||
+			(q.Role != "" && m.Role != q.Role) ||
+			(q.Since != 0 && m.Timestamp < q.Since) ||
+			(q.Until != 0 && m.Timestamp > q.Until) {
+			continue
+		}
+		matched = append(matched, m)
+	}
+	keys := make([]Cursor, len(matched))
+	for i, m := range matched {
+		keys[i] = Cursor{Value: m.Timestamp, ID: m.ID}
+	}
+	idx, next, err := page(keys, q.Cursor, q.Order, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	out := &MessagePage{Items: make([]models.Message, 0, len(idx)), NextCursor: next}
+	for _, i := range idx {
+		out.Items = append(out.Items, matched[i])
+	}
+	return out, nil
+}
+
+// page orders keys by value then ID, skips everything up to and including
+// the cursor, and returns the indexes of at most limit keys plus the cursor
+// for the page after them.
+func page(keys []Cursor, cursor, order string, limit int) ([]int, string, error) {
+	after, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	less := func(a, b Cursor) bool {
+		if a.Value != b.Value {
+			return a.Value < b.Value
+		}
+		return a.ID < b.ID
+	}
+	if order == OrderDesc {
+		asc := less
+		less = func(a, b Cursor) bool { return asc(b, a) }
+	}
+
+	idx := make([]int, 0, len(keys))
+	for i, k := range keys {
+		if after == nil || less(*after, k) {
+			idx = append(idx, i)
+		}
+	}
+	sort.Slice(idx, func(i, j int) bool { return less(keys[idx[i]], keys[idx[j]]) })
+
+	if limit == 0 || len(idx) <= limit {
+		return idx, "", nil
+	}
+	idx = idx[:limit]
+	return idx, EncodeCursor(keys[idx[limit-1]]), nil
+}
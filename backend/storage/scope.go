@@ -0,0 +1,377 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/krackenservices/threadwell/models"
+)
+
+// ForUser returns a view of s limited to the threads, messages and settings
+// of one user. Records of other users behave exactly as if they did not
+// exist, so callers cannot tell a foreign ID from an unknown one. New
+// threads are owned by the user, and settings are stored under the user's
+// ID whatever ID is passed in. Provider profiles and access tokens are
+// created, listed and changed for the user only, and threads may only name
+// the user's own provider profiles.
+//
+// Creating users, the session methods and GetAccessToken are not scoped;
+// the auth layer uses them before it knows the user.
+func ForUser(s Storage, userID string) Storage {
+	if userID == "" {
+		panic("storage: ForUser needs a user ID")
+	}
+	return &scoped{Storage: s, owner: userID}
+}
+
+type scoped struct {
+	Storage
+	owner string
+}
+
+func (s *scoped) ownsThread(id string) (bool, error) {
+	t, err := s.Storage.GetThread(id)
+	if err != nil {
+		return false, err
+	}
+	return t != nil && t.OwnerID == s.owner, nil
+}
+
+// message returns the message when it is in one of the user's threads.
+func (s *scoped) message(id string) (*models.Message, error) {
+	m, err := s.Storage.GetMessage(id)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	ok, err := s.ownsThread(m.ThreadID)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return m, nil
+}
+
+// checkMessage returns ErrNotFound unless the message belongs to the user.
+func (s *scoped) checkMessage(id string) error {
+	m, err := s.message(id)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return fmt.Errorf("message %w", ErrNotFound)
+	}
+	return nil
+}
+
+func (s *scoped) ListThreads() ([]models.Thread, error) {
+	all, err := s.Storage.ListThreads()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]models.Thread, 0, len(all))
+	for _, t := range all {
+		if t.OwnerID == s.owner {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+func (s *scoped) QueryThreads(q ThreadQuery) (*ThreadPage, error) {
+	q.OwnerID = s.owner
+	return s.Storage.QueryThreads(q)
+}
+
+func (s *scoped) GetThread(id string) (*models.Thread, error) {
+	t, err := s.Storage.GetThread(id)
+	if err != nil || t == nil || t.OwnerID != s.owner {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *scoped) CreateThread(t models.Thread) error {
+	// Checked here too, so no backend can hand someone else's thread over.
+	existing, err := s.Storage.GetThread(t.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("thread %s: %w", t.ID, ErrConflict)
+	}
+	if err := s.checkProviderRef(t.ProviderID); err != nil {
+		return err
+	}
+	t.OwnerID = s.owner
+	return s.Storage.CreateThread(t)
+}
+
+func (s *scoped) UpdateThread(t models.Thread) error {
+	ok, err := s.ownsThread(t.ID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("thread %w", ErrNotFound)
+	}
+	if err := s.checkProviderRef(t.ProviderID); err != nil {
+		return err
+	}
+	t.OwnerID = s.owner
+	return s.Storage.UpdateThread(t)
+}
+
+// checkProviderRef rejects a thread naming another user's provider profile.
+func (s *scoped) checkProviderRef(id string) error {
+	if id == "" {
+		return nil
+	}
+	p, err := s.GetProviderProfile(id)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return fmt.Errorf("provider profile %w", ErrInvalidReference)
+	}
+	return nil
+}
+
+func (s *scoped) DeleteThread(id string) error {
+	ok, err := s.ownsThread(id)
+	if err != nil || !ok {
+		// Deleting an unknown thread is not an error either.
+		return err
+	}
+	return s.Storage.DeleteThread(id)
+}
+
+func (s *scoped) ListMessages(threadID string) ([]models.Message, error) {
+	ok, err := s.ownsThread(threadID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return []models.Message{}, nil
+	}
+	return s.Storage.ListMessages(threadID)
+}
+
+func (s *scoped) QueryMessages(q MessageQuery) (*MessagePage, error) {
+	ok, err := s.ownsThread(q.ThreadID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// Still validate the options, as for an unknown thread.
+		if err := q.Normalize(); err != nil {
+			return nil, err
+		}
+		return PageMessages(nil, q)
+	}
+	return s.Storage.QueryMessages(q)
+}
+
+func (s *scoped) GetMessage(id string) (*models.Message, error) {
+	return s.message(id)
+}
+
+func (s *scoped) CreateMessage(m models.Message) error {
+	ok, err := s.ownsThread(m.ThreadID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("thread %s: %w", m.ThreadID, ErrInvalidReference)
+	}
+	existing, err := s.Storage.GetMessage(m.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("message %s: %w", m.ID, ErrConflict)
+	}
+	return s.Storage.CreateMessage(m)
+}
+
+func (s *scoped) UpdateMessage(m models.Message) error {
+	if err := s.checkMessage(m.ID); err != nil {
+		return err
+	}
+	return s.Storage.UpdateMessage(m)
+}
+
+func (s *scoped) ListMessageRevisions(messageID string) ([]models.MessageRevision, error) {
+	m, err := s.message(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return []models.MessageRevision{}, nil
+	}
+	return s.Storage.ListMessageRevisions(messageID)
+}
+
+func (s *scoped) DeleteMessage(id string) error {
+	if err := s.checkMessage(id); err != nil {
+		return err
+	}
+	return s.Storage.DeleteMessage(id)
+}
+
+func (s *scoped) DeleteSubtree(id string) ([]string, error) {
+	if err := s.checkMessage(id); err != nil {
+		return nil, err
+	}
+	return s.Storage.DeleteSubtree(id)
+}
+
+func (s *scoped) MoveSubtree(fromMessageID string) (string, error) {
+	if err := s.checkMessage(fromMessageID); err != nil {
+		return "", err
+	}
+	return s.Storage.MoveSubtree(fromMessageID)
+}
+
+func (s *scoped) CopySubtree(fromMessageID string) (*BranchResult, error) {
+	if err := s.checkMessage(fromMessageID); err != nil {
+		return nil, err
+	}
+	return s.Storage.CopySubtree(fromMessageID)
+}
+
+func (s *scoped) GraftSubtree(fromMessageID, targetThreadID string, targetParentID *string) (*BranchResult, error) {
+	if err := s.checkMessage(fromMessageID); err != nil {
+		return nil, err
+	}
+	ok, err := s.ownsThread(targetThreadID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("target thread %s: %w", targetThreadID, ErrInvalidReference)
+	}
+	return s.Storage.GraftSubtree(fromMessageID, targetThreadID, targetParentID)
+}
+
+func (s *scoped) Ancestors(id string) ([]models.Message, error) {
+	if err := s.checkMessage(id); err != nil {
+		return nil, err
+	}
+	return s.Storage.Ancestors(id)
+}
+
+func (s *scoped) Descendants(id string) ([]models.Message, error) {
+	if err := s.checkMessage(id); err != nil {
+		return nil, err
+	}
+	return s.Storage.Descendants(id)
+}
+
+func (s *scoped) Search(q SearchQuery) ([]SearchResult, error) {
+	q.OwnerID = s.owner
+	return s.Storage.Search(q)
+}
+
+func (s *scoped) MergeThreads(targetThreadID, sourceThreadID string, targetParentID *string, deleteSource bool) (*MergeResult, error) {
+	ok, err := s.ownsThread(targetThreadID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("target thread %w", ErrNotFound)
+	}
+	if ok, err = s.ownsThread(sourceThreadID); err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("source thread %s: %w", sourceThreadID, ErrInvalidReference)
+	}
+	return s.Storage.MergeThreads(targetThreadID, sourceThreadID, targetParentID, deleteSource)
+}
+
+func (s *scoped) GetSettings(string) (*models.Settings, error) {
+	return s.Storage.GetSettings(s.owner)
+}
+
+func (s *scoped) UpdateSettings(cfg models.Settings) error {
+	cfg.ID = s.owner
+	return s.Storage.UpdateSettings(cfg)
+}
+
+func (s *scoped) GetUser(id string) (*models.User, error) {
+	if id != s.owner {
+		return nil, nil
+	}
+	return s.Storage.GetUser(id)
+}
+
+func (s *scoped) GetUserByUsername(username string) (*models.User, error) {
+	u, err := s.Storage.GetUserByUsername(username)
+	if err != nil || u == nil || u.ID != s.owner {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *scoped) ListUsers() ([]models.User, error) {
+	u, err := s.Storage.GetUser(s.owner)
+	if err != nil || u == nil {
+		return []models.User{}, err
+	}
+	return []models.User{*u}, nil
+}
+
+func (s *scoped) ListProviderProfiles(string) ([]models.ProviderProfile, error) {
+	return s.Storage.ListProviderProfiles(s.owner)
+}
+
+func (s *scoped) GetProviderProfile(id string) (*models.ProviderProfile, error) {
+	p, err := s.Storage.GetProviderProfile(id)
+	if err != nil || p == nil || p.OwnerID != s.owner {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *scoped) CreateProviderProfile(p models.ProviderProfile) error {
+	p.OwnerID = s.owner
+	return s.Storage.CreateProviderProfile(p)
+}
+
+func (s *scoped) UpdateProviderProfile(p models.ProviderProfile) error {
+	if err := s.checkProvider(p.ID); err != nil {
+		return err
+	}
+	p.OwnerID = s.owner
+	return s.Storage.UpdateProviderProfile(p)
+}
+
+func (s *scoped) DeleteProviderProfile(id string) error {
+	if err := s.checkProvider(id); err != nil {
+		return err
+	}
+	return s.Storage.DeleteProviderProfile(id)
+}
+
+// checkProvider returns ErrNotFound unless the profile belongs to the user.
+func (s *scoped) checkProvider(id string) error {
+	p, err := s.GetProviderProfile(id)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return fmt.Errorf("provider profile %w", ErrNotFound)
+	}
+	return nil
+}
+
+func (s *scoped) CreateAccessToken(t models.AccessToken) error {
+	t.UserID = s.owner
+	return s.Storage.CreateAccessToken(t)
+}
+
+func (s *scoped) ListAccessTokens(string) ([]models.AccessToken, error) {
+	return s.Storage.ListAccessTokens(s.owner)
+}
+
+func (s *scoped) DeleteAccessToken(_, id string) error {
+	return s.Storage.DeleteAccessToken(s.owner, id)
+}
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/krackenservices/threadwell/models"
+)
+
+// SearchQuery is a full-text search over message content and thread titles.
+// Text is a list of words and "quoted phrases"; every one must match.
+type SearchQuery struct {
+	Text     string
+	Role     string // only messages with this role; excludes title matches
+	ThreadID string // only this thread
+	OwnerID  string // only threads of this user; empty means all
+	Limit    int    // 0 means DefaultSearchLimit
+}
+
+// DefaultSearchLimit caps the number of results when SearchQuery.Limit is 0.
+const DefaultSearchLimit = 20
+
+// SearchResult is one matching message, or a thread whose title matched
+// (MessageID empty). Matched words in Snippet are wrapped in ** markers.
+type SearchResult struct {
+	ThreadID    string `json:"thread_id"`
+	ThreadTitle string `json:"thread_title"`
+	MessageID   string `json:"message_id,omitempty"`
+	Role        string `json:"role,omitempty"`
+	Snippet     string `json:"snippet"`
+}
+
+const (
+	highlightStart  = "**"
+	highlightEnd    = "**"
+	snippetEllipsis = "…"
+	// snippetContext is how many words to keep either side of the first match.
+	snippetContext = 6
+)
+
+// SearchTerm is one word, or several for a phrase, in lower case.
+type SearchTerm []string
+
+// ParseSearch splits query text into terms. Words inside double quotes form
+// a single phrase term. Returns ErrInvalidQuery if nothing searchable is left
+// or a quote is not closed.
+func ParseSearch(text string) ([]SearchTerm, error) {
+	if strings.Count(text, `"`)%2 != 0 {
+		return nil, fmt.Errorf("%w: unbalanced quotes", ErrInvalidQuery)
+	}
+	var terms []SearchTerm
+	for i, part := range strings.Split(text, `"`) {
+		if i%2 == 1 {
+			if words := Tokenize(part); len(words) > 0 {
+				terms = append(terms, words)
+			}
+			continue
+		}
+		for _, w := range Tokenize(part) {
+			terms = append(terms, SearchTerm{w})
+		}
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("%w: empty search", ErrInvalidQuery)
+	}
+	return terms, nil
+}
+
+// Tokenize lower-cases text and splits it into runs of letters and digits,
+// roughly what SQLite's unicode61 tokenizer does.
+func Tokenize(text string) []string {
+	spans := tokenSpans(text)
+	out := make([]string, len(spans))
+	for i, s := range spans {
+		out[i] = s.word
+	}
+	return out
+}
+
+type span struct {
+	start, end int // byte offsets into the original text
+	word       string
+}
+
+func tokenSpans(text string) []span {
+	var out []span
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isWord && start < 0:
+			start = i
+		case !isWord && start >= 0:
+			out = append(out, span{start, i, strings.ToLower(text[start:i])})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		out = append(out, span{start, len(text), strings.ToLower(text[start:])})
+	}
+	return out
+}
+
+// matchText reports whether every term occurs in text, and returns the index
+// ranges [from, to) of matched words within tokenSpans(text).
+func matchText(spans []span, terms []SearchTerm) (bool, [][2]int) {
+	var hits [][2]int
+	for _, term := range terms {
+		found := false
+		for i := 0; i+len(term) <= len(spans); i++ {
+			ok := true
+			for j, w := range term {
+				if spans[i+j].word != w {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				found = true
+				hits = append(hits, [2]int{i, i + len(term)})
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i][0] < hits[j][0] })
+	return true, hits
+}
+
+// snippet cuts text down to a window around the first hit and highlights
+// every hit inside it.
+func snippet(text string, spans []span, hits [][2]int) string {
+	from := hits[0][0] - snippetContext
+	if from < 0 {
+		from = 0
+	}
+	to := hits[0][1] + snippetContext
+	if to > len(spans) {
+		to = len(spans)
+	}
+
+	var sb strings.Builder
+	if from > 0 {
+		sb.WriteString(snippetEllipsis)
+	}
+	pos := spans[from].start
+	for _, h := range hits {
+		// skip hits outside the window or overlapping one already written
+		if h[0] < from || h[1] > to || spans[h[0]].start < pos {
+			continue
+		}
+		sb.WriteString(text[pos:spans[h[0]].start])
+		sb.WriteString(highlightStart)
+		sb.WriteString(text[spans[h[0]].start:spans[h[1]-1].end])
+		sb.WriteString(highlightEnd)
+		pos = spans[h[1]-1].end
+	}
+	sb.WriteString(text[pos:spans[to-1].end])
+	if to < len(spans) {
+		sb.WriteString(snippetEllipsis)
+	}
+	return sb.String()
+}
+
+// SearchDocuments is the tokenized search used by backends without a
+// full-text index. Results are ranked by number of matched words, then
+// title matches before messages, then newest first.
+func SearchDocuments(threads []models.Thread, msgs []models.Message, q SearchQuery) ([]SearchResult, error) {
+	terms, err := ParseSearch(q.Text)
+	if err != nil {
+		return nil, err
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	type scored struct {
+		SearchResult
+		hits int
+		when int64
+	}
+	titles := map[string]string{}
+	owners := map[string]string{}
+	var found []scored
+	for _, t := range threads {
+		titles[t.ID] = t.Title
+		owners[t.ID] = t.OwnerID
+		if q.Role != "" || (q.ThreadID != "" && t.ID != q.ThreadID) || (q.OwnerID != "" && t.OwnerID != q.OwnerID) {
+			continue
+		}
+		spans := tokenSpans(t.Title)
+		if ok, hits := matchText(spans, terms); ok {
+			found = append(found, scored{
+				SearchResult: SearchResult{ThreadID: t.ID, ThreadTitle: t.Title, Snippet: snippet(t.Title, spans, hits)},
+				hits:         len(hits),
+				when:         t.CreatedAt,
+			})
+		}
+	}
+	for _, m := range msgs {
+		if (q.Role != "" && m.Role != q.Role) || (q.ThreadID != "" && m.ThreadID != q.ThreadID) ||
+			(q.OwnerID != "" && owners[m.ThreadID] != q.OwnerID) {
+			continue
+		}
+		spans := tokenSpans(m.Content)
+		if ok, hits := matchText(spans, terms); ok {
+			found = append(found, scored{
+				SearchResult: SearchResult{
+					ThreadID:  m.ThreadID,
+					MessageID: m.ID,
+					Role:      m.Role,
+					Snippet:   snippet(m.Content, spans, hits),
+				},
+				hits: len(hits),
+				when: m.Timestamp,
+			})
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		switch {
+		case a.hits != b.hits:
+			return a.hits > b.hits
+		case (a.MessageID == "") != (b.MessageID == ""):
+			return a.MessageID == ""
+		case a.when != b.when:
+			return a.when > b.when
+		}
+		return a.ThreadID+a.MessageID < b.ThreadID+b.MessageID
+	})
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	out := make([]SearchResult, len(found))
+	for i, f := range found {
+		out[i] = f.SearchResult
+		out[i].ThreadTitle = titles[f.ThreadID]
+	}
+	return out, nil
+}
@@ -0,0 +1,42 @@
+-- Schema and data as written by builds before schema_version existed.
+CREATE TABLE IF NOT EXISTS threads (
+    id TEXT PRIMARY KEY,
+    title TEXT,
+    created_at INTEGER
+);
+CREATE TABLE IF NOT EXISTS messages (
+    id TEXT PRIMARY KEY,
+    thread_id TEXT,
+    parent_id TEXT,
+    root_id TEXT,
+    role TEXT,
+    content TEXT,
+    timestamp INTEGER,
+    FOREIGN KEY(thread_id) REFERENCES threads(id),
+    FOREIGN KEY(parent_id) REFERENCES messages(id),
+    FOREIGN KEY(root_id) REFERENCES messages(id)
+);
+CREATE TABLE IF NOT EXISTS settings (
+    id TEXT PRIMARY KEY,
+    llm_provider TEXT,
+    llm_endpoint TEXT,
+    llm_api_key TEXT,
+    llm_model TEXT,
+    simulate_only BOOLEAN
+);
+
+INSERT INTO threads (id, title, created_at) VALUES ('t1', 'Legacy thread', 1700000000);
+INSERT INTO messages (id, thread_id, parent_id, root_id, role, content, timestamp)
+VALUES ('m1', 't1', NULL, NULL, 'user', 'hello', 1700000001);
+INSERT INTO messages (id, thread_id, parent_id, root_id, role, content, timestamp)
+VALUES ('m2', 't1', 'm1', 'm1', 'assistant', 'hi there', 1700000002);
+-- Dangling references left behind before foreign keys were enforced: a
+-- message whose thread is gone, and a branch whose parent and root are gone.
+INSERT INTO messages (id, thread_id, parent_id, root_id, role, content, timestamp)
+VALUES ('m3', 'gone', NULL, NULL, 'user', 'lost thread', 1700000003);
+INSERT INTO messages (id, thread_id, parent_id, root_id, role, content, timestamp)
+VALUES ('m4', 't1', 'deleted', 'deleted', 'user', 'lost parent', 1700000004);
+INSERT INTO messages (id, thread_id, parent_id, root_id, role, content, timestamp)
+VALUES ('m5', 't1', 'm4', 'deleted', 'assistant', 'child of lost parent', 1700000005);
+INSERT INTO settings (id, llm_provider, llm_endpoint, llm_api_key, llm_model, simulate_only)
+VALUES ('default', 'openai', 'http://localhost:1234', 'legacy-key', 'gpt-4o', 0);
@@ -0,0 +1,90 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+func (s *SQLiteStorage) MergeThreads(targetThreadID, sourceThreadID string, targetParentID *string, deleteSource bool) (*storage.MergeResult, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`PRAGMA defer_foreign_keys = ON`); err != nil {
+		return nil, rollback(tx, err)
+	}
+
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM threads WHERE id = ?`, targetThreadID).Scan(&n); err != nil {
+		return nil, rollback(tx, err)
+	}
+	if n == 0 {
+		return nil, rollback(tx, fmt.Errorf("thread %w", storage.ErrNotFound))
+	}
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM threads WHERE id = ?`, sourceThreadID).Scan(&n); err != nil {
+		return nil, rollback(tx, err)
+	}
+	if n == 0 || sourceThreadID == targetThreadID {
+		return nil, rollback(tx, fmt.Errorf("%w: cannot merge thread %s into %s", storage.ErrInvalidReference, sourceThreadID, targetThreadID))
+	}
+	if targetParentID != nil {
+		parent, err := getMessage(tx, *targetParentID)
+		if err != nil {
+			return nil, rollback(tx, err)
+		}
+		if parent == nil || parent.ThreadID != targetThreadID {
+			return nil, rollback(tx, fmt.Errorf("%w: parent %s is not in thread %s", storage.ErrInvalidReference, *targetParentID, targetThreadID))
+		}
+	}
+
+	target, err := listMessages(tx, targetThreadID)
+	if err != nil {
+		return nil, rollback(tx, err)
+	}
+	source, err := listMessages(tx, sourceThreadID)
+	if err != nil {
+		return nil, rollback(tx, err)
+	}
+
+	res, created := storage.PlanMerge(targetThreadID, target, source, targetParentID, uuid.NewString)
+	for _, m := range created {
+		if _, err := tx.Exec(`
+			INSERT INTO messages (id, thread_id, parent_id, root_id, role, content, timestamp)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			m.ID, m.ThreadID, m.ParentID, m.RootID, m.Role, m.Content, m.Timestamp,
+		); err != nil {
+			return nil, rollback(tx, fmt.Errorf("insert failed for %s: %w", m.ID, err))
+		}
+	}
+
+	if deleteSource {
+		for _, m := range source {
+			if newID, ok := res.IDMap[m.ID]; ok {
+				_, err = tx.Exec(`UPDATE message_revisions SET message_id = ? WHERE message_id = ?`, newID, m.ID)
+			} else {
+				_, err = tx.Exec(`DELETE FROM message_revisions WHERE message_id = ?`, m.ID)
+			}
+			if err != nil {
+				return nil, rollback(tx, fmt.Errorf("failed to carry revisions of %s: %w", m.ID, err))
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM messages WHERE thread_id = ?`, sourceThreadID); err != nil {
+			return nil, rollback(tx, err)
+		}
+		if _, err := tx.Exec(`DELETE FROM threads WHERE id = ?`, sourceThreadID); err != nil {
+			return nil, rollback(tx, err)
+		}
+		res.SourceDeleted = true
+	}
+
+	if _, err := tx.Exec(`UPDATE threads SET updated_at = ? WHERE id = ?`, time.Now().Unix(), targetThreadID); err != nil {
+		return nil, rollback(tx, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -5,93 +5,63 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+	"strings"
 	"time"
 )
 
 func (s *SQLiteStorage) MoveSubtree(fromID string) (string, error) {
+	res, err := s.branch(fromID, true)
+	if err != nil {
+		return "", err
+	}
+	return res.ThreadID, nil
+}
+
+func (s *SQLiteStorage) CopySubtree(fromID string) (*storage.BranchResult, error) {
+	return s.branch(fromID, false)
+}
+
+// branch copies the ancestry and subtree of fromID into a new thread in one
+// transaction, removing the subtree from the source when move is set.
+func (s *SQLiteStorage) branch(fromID string, move bool) (*storage.BranchResult, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
 	// Step 1: Load the original message
-	origMsg, err := s.GetMessage(fromID)
+	origMsg, err := getMessage(tx, fromID)
 	if err != nil {
-		return "", fmt.Errorf("failed to find root message: %w", err)
+		return nil, rollback(tx, fmt.Errorf("failed to find root message: %w", err))
 	}
 	if origMsg == nil {
-		return "", fmt.Errorf("message not found")
+		return nil, rollback(tx, fmt.Errorf("message %w", storage.ErrNotFound))
 	}
 
-	// Step 2: Walk up to root — build ancestor chain
-	ancestry := []*models.Message{}
-	current := origMsg
-	for current != nil && current.ParentID != nil {
-		parent, err := s.GetMessage(*current.ParentID)
-		if err != nil {
-			break
-		}
-		ancestry = append([]*models.Message{parent}, ancestry...) // prepend
-		current = parent
+	// Step 2: Load the ancestor chain, root first
+	ancestry, err := ancestors(tx, fromID)
+	if err != nil {
+		return nil, rollback(tx, err)
 	}
 
-	// Step 3: BFS to collect descendants
-	descendants := map[string]*models.Message{}
-	queue := []string{fromID}
-	for len(queue) > 0 {
-		parentID := queue[0]
-		queue = queue[1:]
-
-		rows, err := s.db.Query(`
-            SELECT id, thread_id, parent_id, root_id, role, content, timestamp
-            FROM messages WHERE parent_id = ?`, parentID)
-		if err != nil {
-			return "", fmt.Errorf("query descendants: %w", err)
-		}
-
-		for rows.Next() {
-			var m models.Message
-			var parentID sql.NullString
-			if err := rows.Scan(&m.ID, &m.ThreadID, &parentID, &m.RootID, &m.Role, &m.Content, &m.Timestamp); err != nil {
-				closeErr := rows.Close()
-				if closeErr != nil {
-					return "", fmt.Errorf("scan error: %w; additionally failed to close rows: %v", err, closeErr)
-				}
-				return "", err
-			}
-			if parentID.Valid {
-				m.ParentID = &parentID.String
-			}
-			descendants[m.ID] = &m
-			queue = append(queue, m.ID)
-		}
-		err = rows.Close()
-		if err != nil {
-			return "", err
-		}
+	// Step 3: Load everything below the original message
+	subtree, err := descendants(tx, fromID)
+	if err != nil {
+		return nil, rollback(tx, err)
 	}
 
-	// Step 4: Collect all to move
-	messagesToMove := map[string]*models.Message{}
-	for _, m := range ancestry {
-		messagesToMove[m.ID] = m
-	}
-	messagesToMove[origMsg.ID] = origMsg
-	for id, m := range descendants {
-		messagesToMove[id] = m
-	}
+	// Step 4: Collect all to copy, parents before children
+	messagesToMove := append(append(ancestry, *origMsg), subtree...)
 
 	// Step 5: Remap IDs
 	idMap := map[string]string{}
-	for oldID := range messagesToMove {
-		idMap[oldID] = uuid.NewString()
-	}
-	rootNewID := idMap[fromID]
-	if len(ancestry) > 0 {
-		rootNewID = idMap[ancestry[0].ID]
-	}
-
-	// Step 6: Begin transaction and create new thread
-	tx, err := s.db.Begin()
-	if err != nil {
-		return "", err
+	for _, m := range messagesToMove {
+		idMap[m.ID] = uuid.NewString()
 	}
+	rootNewID := idMap[messagesToMove[0].ID]
 
+	// Step 6: Create new thread
 	title := "Branched"
 	if origMsg.Content != "" {
 		preview := origMsg.Content
@@ -106,13 +76,15 @@ func (s *SQLiteStorage) MoveSubtree(fromID string) (string, error) {
 		Title:     title,
 		CreatedAt: time.Now().Unix(),
 	}
-	if _, err := tx.Exec(`INSERT INTO threads (id, title, created_at) VALUES (?, ?, ?)`,
-		newThread.ID, newThread.Title, newThread.CreatedAt); err != nil {
+	if _, err := tx.Exec(`
+		INSERT INTO threads (id, title, created_at, updated_at, owner_id, provider_id, model, system_prompt, temperature, max_tokens)
+		SELECT ?, ?, ?, ?, owner_id, provider_id, model, system_prompt, temperature, max_tokens FROM threads WHERE id = ?`,
+		newThread.ID, newThread.Title, newThread.CreatedAt, newThread.CreatedAt, origMsg.ThreadID); err != nil {
 		rollbackerr := tx.Rollback()
 		if rollbackerr != nil {
-			return "", fmt.Errorf("failed to create thread: %w; additionally failed to rollback transaction: %v", err, rollbackerr)
+			return nil, fmt.Errorf("failed to create thread: %w; additionally failed to rollback transaction: %v", err, rollbackerr)
 		}
-		return "", fmt.Errorf("failed to create thread: %w", err)
+		return nil, fmt.Errorf("failed to create thread: %w", err)
 	}
 
 	// Step 7: Insert copied messages
@@ -123,9 +95,9 @@ func (s *SQLiteStorage) MoveSubtree(fromID string) (string, error) {
 	if err != nil {
 		rollbackerr := tx.Rollback()
 		if rollbackerr != nil {
-			return "", fmt.Errorf("prepare error: %w; additionally failed to rollback transaction: %v", err, rollbackerr)
+			return nil, fmt.Errorf("prepare error: %w; additionally failed to rollback transaction: %v", err, rollbackerr)
 		}
-		return "", err
+		return nil, err
 	}
 	defer func() {
 		if closeErr := stmt.Close(); closeErr != nil {
@@ -160,52 +132,69 @@ func (s *SQLiteStorage) MoveSubtree(fromID string) (string, error) {
 		if err != nil {
 			rollbackerr := tx.Rollback()
 			if rollbackerr != nil {
-				return "", fmt.Errorf("insert failed for %s → %s: %w; additionally failed to rollback transaction: %v", m.ID, newID, err, rollbackerr)
+				return nil, fmt.Errorf("insert failed for %s → %s: %w; additionally failed to rollback transaction: %v", m.ID, newID, err, rollbackerr)
 			}
-			return "", fmt.Errorf("insert failed for %s → %s: %w", m.ID, newID, err)
+			return nil, fmt.Errorf("insert failed for %s → %s: %w", m.ID, newID, err)
 		}
 	}
 
-	// Step 7b: Delete original branch messages (from fromID down)
-	for id := range descendants {
-		_, err := tx.Exec(`DELETE FROM messages WHERE id = ?`, id)
+	if move {
+		// Step 7b: Delete original branch messages (from fromID down), keeping their edit history
+		for i := len(subtree) - 1; i >= 0; i-- {
+			id := subtree[i].ID
+			if _, err := tx.Exec(`UPDATE message_revisions SET message_id = ? WHERE message_id = ?`, idMap[id], id); err != nil {
+				return nil, rollback(tx, fmt.Errorf("failed to move revisions of %s: %w", id, err))
+			}
+			_, err := tx.Exec(`DELETE FROM messages WHERE id = ?`, id)
+			if err != nil {
+				rollbackerr := tx.Rollback()
+				if rollbackerr != nil {
+					return nil, fmt.Errorf("failed to delete descendant %s: %w; additionally failed to rollback transaction: %v", id, err, rollbackerr)
+				}
+				return nil, fmt.Errorf("failed to delete descendant %s: %w", id, err)
+			}
+		}
+
+		// Also delete the original "from" message itself
+		if _, err := tx.Exec(`UPDATE message_revisions SET message_id = ? WHERE message_id = ?`, idMap[fromID], fromID); err != nil {
+			return nil, rollback(tx, fmt.Errorf("failed to move revisions of %s: %w", fromID, err))
+		}
+		_, err = tx.Exec(`DELETE FROM messages WHERE id = ?`, fromID)
 		if err != nil {
 			rollbackerr := tx.Rollback()
 			if rollbackerr != nil {
-				return "", fmt.Errorf("failed to delete descendant %s: %w; additionally failed to rollback transaction: %v", id, err, rollbackerr)
+				return nil, fmt.Errorf("failed to delete original message %s: %w; additionally failed to rollback transaction: %v", fromID, err, rollbackerr)
 			}
-			return "", fmt.Errorf("failed to delete descendant %s: %w", id, err)
+			return nil, fmt.Errorf("failed to delete original message %s: %w", fromID, err)
 		}
 	}
 
-	// Also delete the original "from" message itself
-	_, err = tx.Exec(`DELETE FROM messages WHERE id = ?`, fromID)
-	if err != nil {
-		rollbackerr := tx.Rollback()
-		if rollbackerr != nil {
-			return "", fmt.Errorf("failed to delete original message %s: %w; additionally failed to rollback transaction: %v", fromID, err, rollbackerr)
-		}
-		return "", fmt.Errorf("failed to delete original message %s: %w", fromID, err)
-	}
-
 	err = tx.Commit()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return newThreadID, nil
+	return &storage.BranchResult{ThreadID: newThreadID, IDMap: idMap}, nil
 }
 
 func (s *SQLiteStorage) ListMessages(threadID string) ([]models.Message, error) {
-	rows, err := s.db.Query(`SELECT id, thread_id, parent_id, root_id, role, content, timestamp FROM messages WHERE thread_id = ? ORDER BY timestamp`, threadID)
+	return listMessages(s.db, threadID)
+}
+
+// listMessages loads every message in a thread through q.
+func listMessages(q querier, threadID string) ([]models.Message, error) {
+	rows, err := q.Query(`SELECT id, thread_id, parent_id, root_id, role, content, timestamp FROM messages WHERE thread_id = ? ORDER BY timestamp, id`, threadID)
 	if err != nil {
 		return nil, err
 	}
+	return scanMessages(rows)
+}
+
+// scanMessages reads message rows selected in the usual column order and
+// closes rows.
+func scanMessages(rows *sql.Rows) ([]models.Message, error) {
 	defer func() {
-		closeErr := rows.Close()
-		if err == nil {
-			err = closeErr
-		}
+		_ = rows.Close()
 	}()
 
 	messages := make([]models.Message, 0)
@@ -224,11 +213,17 @@ func (s *SQLiteStorage) ListMessages(threadID string) ([]models.Message, error)
 		}
 		messages = append(messages, m)
 	}
-	return messages, nil
+	return messages, rows.Err()
 }
 
 func (s *SQLiteStorage) GetMessage(id string) (*models.Message, error) {
-	row := s.db.QueryRow(`SELECT id, thread_id, parent_id, root_id, role, content, timestamp FROM messages WHERE id = ?`, id)
+	return getMessage(s.db, id)
+}
+
+// getMessage loads one message through q, which may be the database or a
+// transaction. It returns nil, nil if there is no such message.
+func getMessage(q querier, id string) (*models.Message, error) {
+	row := q.QueryRow(`SELECT id, thread_id, parent_id, root_id, role, content, timestamp FROM messages WHERE id = ?`, id)
 	var m models.Message
 	var parentID, rootID sql.NullString
 
@@ -248,14 +243,175 @@ func (s *SQLiteStorage) GetMessage(id string) (*models.Message, error) {
 }
 
 func (s *SQLiteStorage) CreateMessage(m models.Message) error {
+	if err := s.checkRefs(m); err != nil {
+		return err
+	}
 	_, err := s.db.Exec(
 		`INSERT INTO messages (id, thread_id, parent_id, root_id, role, content, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?)`,
 		m.ID, m.ThreadID, m.ParentID, m.RootID, m.Role, m.Content, m.Timestamp,
 	)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return fmt.Errorf("message %s: %w", m.ID, storage.ErrConflict)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`UPDATE threads SET updated_at = ? WHERE id = ? AND COALESCE(updated_at, 0) < ?`,
+		m.Timestamp, m.ThreadID, m.Timestamp)
 	return err
 }
 
+func (s *SQLiteStorage) QueryMessages(q storage.MessageQuery) (*storage.MessagePage, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+	after, err := storage.DecodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT id, thread_id, parent_id, root_id, role, content, timestamp FROM messages WHERE thread_id = ?`
+	args := []interface{}{q.ThreadID}
+	if q.Role != "" {
+		query += ` AND role = ?`
+		args = append(args, q.Role)
+	}
+	if q.Since != 0 {
+		query += ` AND timestamp >= ?`
+		args = append(args, q.Since)
+	}
+	if q.Until != 0 {
+		query += ` AND timestamp <= ?`
+		args = append(args, q.Until)
+	}
+	cmp, dir := ">", "ASC"
+	if q.Order == storage.OrderDesc {
+		cmp, dir = "<", "DESC"
+	}
+	if after != nil {
+		query += ` AND (timestamp ` + cmp + ` ? OR (timestamp = ? AND id ` + cmp + ` ?))`
+		args = append(args, after.Value, after.Value, after.ID)
+	}
+	query += ` ORDER BY timestamp ` + dir + `, id ` + dir
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit+1)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	page := &storage.MessagePage{Items: msgs}
+	if q.Limit > 0 && len(msgs) > q.Limit {
+		page.Items = msgs[:q.Limit]
+		last := page.Items[q.Limit-1]
+		page.NextCursor = storage.EncodeCursor(storage.Cursor{Value: last.Timestamp, ID: last.ID})
+	}
+	return page, nil
+}
+
 func (s *SQLiteStorage) DeleteMessage(id string) error {
-	_, err := s.db.Exec(`DELETE FROM messages WHERE id = ?`, id)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	var children int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM messages WHERE parent_id = ?`, id).Scan(&children); err != nil {
+		return rollback(tx, err)
+	}
+	if children > 0 {
+		return rollback(tx, storage.ErrHasChildren)
+	}
+	if _, err := tx.Exec(`DELETE FROM message_revisions WHERE message_id = ?`, id); err != nil {
+		return rollback(tx, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM messages WHERE id = ?`, id); err != nil {
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
+
+// checkRefs validates the thread, parent and root a message points at, so
+// callers get ErrInvalidReference rather than a raw constraint failure.
+func (s *SQLiteStorage) checkRefs(m models.Message) error {
+	var threads int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM threads WHERE id = ?`, m.ThreadID).Scan(&threads); err != nil {
+		return err
+	}
+	if threads == 0 {
+		return fmt.Errorf("%w: thread %s does not exist", storage.ErrInvalidReference, m.ThreadID)
+	}
+	refs := []*string{m.ParentID}
+	// A root may point at itself, the way MoveSubtree leaves moved roots.
+	if m.RootID == nil || *m.RootID != m.ID {
+		refs = append(refs, m.RootID)
+	}
+	for _, ref := range refs {
+		if ref == nil {
+			continue
+		}
+		var threadID string
+		err := s.db.QueryRow(`SELECT thread_id FROM messages WHERE id = ?`, *ref).Scan(&threadID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: message %s does not exist", storage.ErrInvalidReference, *ref)
+		}
+		if err != nil {
+			return err
+		}
+		if threadID != m.ThreadID {
+			return fmt.Errorf("%w: message %s belongs to thread %s", storage.ErrInvalidReference, *ref, threadID)
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteSubtree(id string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	var exists int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM messages WHERE id = ?`, id).Scan(&exists); err != nil {
+		return nil, rollback(tx, err)
+	}
+	if exists == 0 {
+		return nil, rollback(tx, storage.ErrNotFound)
+	}
+
+	subtree, err := descendants(tx, id)
+	if err != nil {
+		return nil, rollback(tx, err)
+	}
+	deleted := []string{id}
+	for _, m := range subtree {
+		deleted = append(deleted, m.ID)
+	}
+
+	// Delete leaves first so no row is ever left pointing at a removed parent.
+	for i := len(deleted) - 1; i >= 0; i-- {
+		if _, err := tx.Exec(`DELETE FROM message_revisions WHERE message_id = ?`, deleted[i]); err != nil {
+			return nil, rollback(tx, err)
+		}
+		if _, err := tx.Exec(`DELETE FROM messages WHERE id = ?`, deleted[i]); err != nil {
+			return nil, rollback(tx, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// rollback aborts tx and returns err, noting any rollback failure alongside it.
+func rollback(tx *sql.Tx, err error) error {
+	if rollbackErr := tx.Rollback(); rollbackErr != nil {
+		return fmt.Errorf("%w; additionally failed to rollback transaction: %v", err, rollbackErr)
+	}
 	return err
 }
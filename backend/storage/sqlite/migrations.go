@@ -0,0 +1,254 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration is one ordered schema step. Steps use IF NOT EXISTS where they
+// can so that databases created before versioning (version 0) upgrade cleanly.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrations must only ever be appended to; never edit a released step.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "threads and messages",
+		sql: `
+    CREATE TABLE IF NOT EXISTS threads (
+        id TEXT PRIMARY KEY,
+        title TEXT,
+        created_at INTEGER
+    );
+    CREATE TABLE IF NOT EXISTS messages (
+        id TEXT PRIMARY KEY,
+        thread_id TEXT,
+        parent_id TEXT,
+        root_id TEXT,
+        role TEXT,
+        content TEXT,
+        timestamp INTEGER,
+        FOREIGN KEY(thread_id) REFERENCES threads(id),
+        FOREIGN KEY(parent_id) REFERENCES messages(id),
+        FOREIGN KEY(root_id) REFERENCES messages(id)
+    );`,
+	},
+	{
+		version: 2,
+		name:    "settings",
+		sql: `
+    CREATE TABLE IF NOT EXISTS settings (
+        id TEXT PRIMARY KEY,
+        llm_provider TEXT,
+        llm_endpoint TEXT,
+        llm_api_key TEXT,
+        llm_model TEXT,
+        simulate_only BOOLEAN
+    );`,
+	},
+	{
+		version: 3,
+		name:    "message revisions",
+		sql: `
+    CREATE TABLE IF NOT EXISTS message_revisions (
+        id TEXT PRIMARY KEY,
+        message_id TEXT NOT NULL,
+        version INTEGER NOT NULL,
+        role TEXT,
+        content TEXT,
+        timestamp INTEGER,
+        UNIQUE(message_id, version)
+    );`,
+	},
+	{
+		version: 4,
+		name:    "message tree indexes",
+		sql: `
+    CREATE INDEX IF NOT EXISTS idx_messages_parent_id ON messages(parent_id);
+    CREATE INDEX IF NOT EXISTS idx_messages_thread_id ON messages(thread_id, timestamp);`,
+	},
+	{
+		version: 5,
+		name:    "thread updated_at",
+		sql: `
+    ALTER TABLE threads ADD COLUMN updated_at INTEGER;
+    UPDATE threads SET updated_at = COALESCE(
+        (SELECT MAX(timestamp) FROM messages WHERE messages.thread_id = threads.id AND timestamp > threads.created_at),
+        created_at);
+    CREATE INDEX IF NOT EXISTS idx_threads_created_at ON threads(created_at, id);
+    CREATE INDEX IF NOT EXISTS idx_threads_updated_at ON threads(updated_at, id);`,
+	},
+	{
+		version: 6,
+		name:    "users and thread owners",
+		sql: `
+    CREATE TABLE IF NOT EXISTS users (
+        id TEXT PRIMARY KEY,
+        username TEXT NOT NULL UNIQUE,
+        password_hash TEXT NOT NULL,
+        created_at INTEGER
+    );
+    CREATE TABLE IF NOT EXISTS sessions (
+        id TEXT PRIMARY KEY,
+        user_id TEXT NOT NULL,
+        created_at INTEGER,
+        expires_at INTEGER,
+        FOREIGN KEY(user_id) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+    ALTER TABLE threads ADD COLUMN owner_id TEXT NOT NULL DEFAULT '';
+    CREATE INDEX IF NOT EXISTS idx_threads_owner_id ON threads(owner_id, created_at, id);`,
+	},
+	{
+		version: 7,
+		name:    "access tokens",
+		sql: `
+    CREATE TABLE IF NOT EXISTS access_tokens (
+        id TEXT PRIMARY KEY,
+        user_id TEXT NOT NULL,
+        name TEXT NOT NULL DEFAULT '',
+        token_hash TEXT NOT NULL UNIQUE,
+        scopes TEXT NOT NULL DEFAULT '',
+        created_at INTEGER,
+        expires_at INTEGER,
+        FOREIGN KEY(user_id) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_access_tokens_user_id ON access_tokens(user_id, created_at, id);`,
+	},
+	{
+		version: 8,
+		name:    "provider profiles",
+		sql: `
+    CREATE TABLE IF NOT EXISTS provider_profiles (
+        id TEXT PRIMARY KEY,
+        owner_id TEXT NOT NULL DEFAULT '',
+        name TEXT NOT NULL,
+        llm_provider TEXT NOT NULL DEFAULT '',
+        llm_endpoint TEXT NOT NULL DEFAULT '',
+        llm_api_key TEXT NOT NULL DEFAULT '',
+        llm_model TEXT NOT NULL DEFAULT '',
+        is_default BOOLEAN NOT NULL DEFAULT 0,
+        created_at INTEGER,
+        updated_at INTEGER
+    );
+    CREATE INDEX IF NOT EXISTS idx_provider_profiles_owner_id ON provider_profiles(owner_id, created_at, id);
+    CREATE UNIQUE INDEX IF NOT EXISTS idx_provider_profiles_default ON provider_profiles(owner_id) WHERE is_default;
+    ALTER TABLE threads ADD COLUMN provider_id TEXT NOT NULL DEFAULT '';`,
+	},
+	{
+		version: 9,
+		name:    "thread reply settings",
+		sql: `
+    ALTER TABLE threads ADD COLUMN model TEXT NOT NULL DEFAULT '';
+    ALTER TABLE threads ADD COLUMN system_prompt TEXT NOT NULL DEFAULT '';
+    ALTER TABLE threads ADD COLUMN temperature REAL;
+    ALTER TABLE threads ADD COLUMN max_tokens INTEGER NOT NULL DEFAULT 0;`,
+	},
+	{
+		// Builds before foreign keys were enforced could leave messages
+		// whose thread or parent is gone. Drop messages without a thread,
+		// make messages with a missing parent roots of their own, and point
+		// root_id at each message's actual root, the way MoveSubtree does
+		// for a new root.
+		version: 10,
+		name:    "remove dangling message references",
+		sql: `
+    DELETE FROM message_revisions WHERE message_id IN (
+        SELECT id FROM messages WHERE thread_id IS NULL OR thread_id NOT IN (SELECT id FROM threads));
+    DELETE FROM messages WHERE thread_id IS NULL OR thread_id NOT IN (SELECT id FROM threads);
+    UPDATE messages SET parent_id = NULL, root_id = id
+    WHERE parent_id IS NOT NULL AND parent_id NOT IN (SELECT id FROM messages);
+    UPDATE messages SET root_id = (
+        WITH RECURSIVE up(id, parent_id) AS (
+            SELECT m.id, m.parent_id FROM messages m WHERE m.id = messages.parent_id
+            UNION
+            SELECT p.id, p.parent_id FROM messages p JOIN up ON p.id = up.parent_id
+        )
+        SELECT id FROM up WHERE parent_id IS NULL)
+    WHERE parent_id IS NOT NULL AND (root_id IS NULL OR root_id NOT IN (SELECT id FROM messages));`,
+	},
+}
+
+// LatestSchemaVersion is the schema version this build migrates databases to.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func (s *SQLiteStorage) ensureVersionTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT,
+			applied_at INTEGER
+		)
+	`)
+	return err
+}
+
+// SchemaVersion reports the highest migration applied to the database,
+// or 0 for a database that predates versioning. It only reads, so it works
+// on a store from OpenReadOnly.
+func (s *SQLiteStorage) SchemaVersion() (int, error) {
+	var tables int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'`).Scan(&tables); err != nil {
+		return 0, err
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+	var version sql.NullInt64
+	if err := s.db.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// Migrate applies every pending migration in order, each in its own
+// transaction, and returns the versions it applied.
+func (s *SQLiteStorage) Migrate() ([]int, error) {
+	if err := s.ensureVersionTable(); err != nil {
+		return nil, err
+	}
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if current > LatestSchemaVersion() {
+		return nil, fmt.Errorf("database schema version %d is newer than this build supports (%d)", current, LatestSchemaVersion())
+	}
+
+	var applied []int
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := s.apply(m); err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		applied = append(applied, m.version)
+	}
+	return applied, nil
+}
+
+func (s *SQLiteStorage) apply(m migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`PRAGMA defer_foreign_keys = ON`); err != nil {
+		return rollback(tx, err)
+	}
+	if _, err := tx.Exec(m.sql); err != nil {
+		return rollback(tx, err)
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.version, m.name, time.Now().Unix()); err != nil {
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,136 @@
+package sqlite_test
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+	"github.com/krackenservices/threadwell/storage/sqlite"
+	"github.com/stretchr/testify/require"
+)
+
+// loadFixture writes the given SQL script into a fresh database file.
+func loadFixture(t *testing.T, script string) string {
+	path := filepath.Join(t.TempDir(), "fixture.db")
+	raw, err := os.ReadFile(script)
+	require.NoError(t, err)
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	_, err = db.Exec(string(raw))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	return path
+}
+
+func TestSchemaVersionReadOnly(t *testing.T) {
+	path := loadFixture(t, "fixtures/v0.sql")
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	store, err := sqlite.OpenReadOnly(path)
+	require.NoError(t, err)
+	version, err := store.SchemaVersion()
+	require.NoError(t, err)
+	require.Equal(t, 0, version)
+	_, err = store.Migrate()
+	require.Error(t, err)
+	require.NoError(t, store.Close())
+
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+
+	// A missing database is reported, not created.
+	missing := filepath.Join(t.TempDir(), "missing.db")
+	_, err = sqlite.OpenReadOnly(missing)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(missing)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestMigrateFromVersionZero(t *testing.T) {
+	path := loadFixture(t, "fixtures/v0.sql")
+
+	store, err := sqlite.Open(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	version, err := store.SchemaVersion()
+	require.NoError(t, err)
+	require.Equal(t, 0, version)
+
+	applied, err := store.Migrate()
+	require.NoError(t, err)
+	require.Len(t, applied, sqlite.LatestSchemaVersion())
+
+	version, err = store.SchemaVersion()
+	require.NoError(t, err)
+	require.Equal(t, sqlite.LatestSchemaVersion(), version)
+
+	// Existing data survives the upgrade and new features work on it
+	msgs, err := store.ListMessages("t1")
+	require.NoError(t, err)
+	require.Len(t, msgs, 4)
+
+	// Dangling references are gone: the thread-less message is dropped and
+	// the branch with a missing parent becomes a root of its own.
+	lost, err := store.GetMessage("m3")
+	require.NoError(t, err)
+	require.Nil(t, lost)
+	m4, err := store.GetMessage("m4")
+	require.NoError(t, err)
+	require.Nil(t, m4.ParentID)
+	require.Equal(t, "m4", *m4.RootID)
+	m5, err := store.GetMessage("m5")
+	require.NoError(t, err)
+	require.Equal(t, "m4", *m5.RootID)
+	require.NoError(t, store.UpdateMessage(models.Message{ID: "m5", Role: "assistant", Content: "still editable"}))
+	_, err = store.DeleteSubtree("m4")
+	require.NoError(t, err)
+
+	cfg, err := store.GetSettings(storage.DefaultSettingsID)
+	require.NoError(t, err)
+	require.Equal(t, "legacy-key", cfg.LLMApiKey)
+
+	require.NoError(t, store.UpdateMessage(models.Message{ID: "m2", Role: "assistant", Content: "edited"}))
+	revs, err := store.ListMessageRevisions("m2")
+	require.NoError(t, err)
+	require.Len(t, revs, 1)
+	require.Equal(t, "hi there", revs[0].Content)
+
+	// Running again is a no-op
+	applied, err = store.Migrate()
+	require.NoError(t, err)
+	require.Empty(t, applied)
+}
+
+func TestNewMigratesFreshDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fresh.db")
+	store, err := sqlite.New(path)
+	require.NoError(t, err)
+
+	version, err := store.(*sqlite.SQLiteStorage).SchemaVersion()
+	require.NoError(t, err)
+	require.Equal(t, sqlite.LatestSchemaVersion(), version)
+}
+
+func TestInitRejectsNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "future.db")
+	store, err := sqlite.New(path)
+	require.NoError(t, err)
+	require.NoError(t, store.(*sqlite.SQLiteStorage).Close())
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'from the future', 0)`,
+		sqlite.LatestSchemaVersion()+1)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = sqlite.New(path)
+	require.ErrorContains(t, err, "newer than this build supports")
+}
@@ -0,0 +1,130 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+const providerColumns = `id, owner_id, name, llm_provider, llm_endpoint, llm_api_key, llm_model, is_default, created_at, updated_at`
+
+func (s *SQLiteStorage) ListProviderProfiles(ownerID string) ([]models.ProviderProfile, error) {
+	rows, err := s.db.Query(`SELECT `+providerColumns+` FROM provider_profiles WHERE owner_id = ? ORDER BY created_at, id`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	return scanProviders(rows)
+}
+
+func (s *SQLiteStorage) GetProviderProfile(id string) (*models.ProviderProfile, error) {
+	rows, err := s.db.Query(`SELECT `+providerColumns+` FROM provider_profiles WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := scanProviders(rows)
+	if err != nil || len(profiles) == 0 {
+		return nil, err
+	}
+	return &profiles[0], nil
+}
+
+func (s *SQLiteStorage) CreateProviderProfile(p models.ProviderProfile) error {
+	if p.UpdatedAt == 0 {
+		p.UpdatedAt = p.CreatedAt
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := clearDefault(tx, p); err != nil {
+		return rollback(tx, err)
+	}
+	_, err = tx.Exec(`INSERT INTO provider_profiles (`+providerColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.ID, p.OwnerID, p.Name, p.LLMProvider, p.LLMEndpoint, p.LLMApiKey, p.LLMName, p.IsDefault, p.CreatedAt, p.UpdatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			err = storage.ErrConflict
+		}
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) UpdateProviderProfile(p models.ProviderProfile) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	var owner string
+	if err := tx.QueryRow(`SELECT owner_id FROM provider_profiles WHERE id = ?`, p.ID).Scan(&owner); err != nil {
+		if err == sql.ErrNoRows {
+			err = storage.ErrNotFound
+		}
+		return rollback(tx, err)
+	}
+	if p.OwnerID == "" {
+		p.OwnerID = owner
+	}
+	if err := clearDefault(tx, p); err != nil {
+		return rollback(tx, err)
+	}
+	_, err = tx.Exec(`
+		UPDATE provider_profiles
+		SET owner_id = ?, name = ?, llm_provider = ?, llm_endpoint = ?, llm_api_key = ?, llm_model = ?, is_default = ?, updated_at = ?
+		WHERE id = ?`,
+		p.OwnerID, p.Name, p.LLMProvider, p.LLMEndpoint, p.LLMApiKey, p.LLMName, p.IsDefault, p.UpdatedAt, p.ID)
+	if err != nil {
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) DeleteProviderProfile(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM provider_profiles WHERE id = ?`, id)
+	if err != nil {
+		return rollback(tx, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return rollback(tx, err)
+	}
+	if n == 0 {
+		return rollback(tx, storage.ErrNotFound)
+	}
+	if _, err := tx.Exec(`UPDATE threads SET provider_id = '' WHERE provider_id = ?`, id); err != nil {
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
+
+// clearDefault unsets the owner's current default when p is the new one.
+func clearDefault(tx *sql.Tx, p models.ProviderProfile) error {
+	if !p.IsDefault {
+		return nil
+	}
+	_, err := tx.Exec(`UPDATE provider_profiles SET is_default = 0 WHERE owner_id = ? AND is_default AND id <> ?`, p.OwnerID, p.ID)
+	return err
+}
+
+// scanProviders reads rows selected with providerColumns and closes rows.
+func scanProviders(rows *sql.Rows) ([]models.ProviderProfile, error) {
+	defer func() {
+		_ = rows.Close()
+	}()
+	profiles := make([]models.ProviderProfile, 0)
+	for rows.Next() {
+		var p models.ProviderProfile
+		if err := rows.Scan(&p.ID, &p.OwnerID, &p.Name, &p.LLMProvider, &p.LLMEndpoint, &p.LLMApiKey, &p.LLMName,
+			&p.IsDefault, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
@@ -0,0 +1,65 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+func (s *SQLiteStorage) UpdateMessage(m models.Message) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var prevRole, prevContent sql.NullString
+	err = tx.QueryRow(`SELECT role, content FROM messages WHERE id = ?`, m.ID).Scan(&prevRole, &prevContent)
+	if err == sql.ErrNoRows {
+		return rollback(tx, storage.ErrNotFound)
+	}
+	if err != nil {
+		return rollback(tx, err)
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO message_revisions (id, message_id, version, role, content, timestamp)
+		SELECT ?, ?, COALESCE(MAX(version), 0) + 1, ?, ?, ?
+		FROM message_revisions WHERE message_id = ?`,
+		uuid.NewString(), m.ID, prevRole.String, prevContent.String, time.Now().Unix(), m.ID,
+	); err != nil {
+		return rollback(tx, err)
+	}
+
+	if _, err := tx.Exec(`UPDATE messages SET role = ?, content = ? WHERE id = ?`, m.Role, m.Content, m.ID); err != nil {
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) ListMessageRevisions(messageID string) ([]models.MessageRevision, error) {
+	rows, err := s.db.Query(`
+		SELECT id, message_id, version, role, content, timestamp
+		FROM message_revisions WHERE message_id = ? ORDER BY version`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		closeErr := rows.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	revs := make([]models.MessageRevision, 0)
+	for rows.Next() {
+		var r models.MessageRevision
+		if err := rows.Scan(&r.ID, &r.MessageID, &r.Version, &r.Role, &r.Content, &r.Timestamp); err != nil {
+			return nil, err
+		}
+		revs = append(revs, r)
+	}
+	return revs, rows.Err()
+}
@@ -0,0 +1,253 @@
+package sqlite
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+// The full-text index lives outside the versioned migrations because FTS5 is
+// only compiled into go-sqlite3 with the sqlite_fts5 build tag. A build
+// without it drops the triggers, so a later FTS5 build sees a stale index and
+// rebuilds it.
+//
+// search_keys gives every message and thread a stable integer rowid in
+// search_fts; the tables' own rowids may change on VACUUM.
+const searchTriggers = `
+    CREATE TRIGGER IF NOT EXISTS search_message_insert AFTER INSERT ON messages BEGIN
+        INSERT INTO search_keys (kind, ref_id) VALUES ('message', new.id);
+        INSERT INTO search_fts (rowid, body)
+            VALUES ((SELECT id FROM search_keys WHERE kind = 'message' AND ref_id = new.id), new.content);
+    END;
+    CREATE TRIGGER IF NOT EXISTS search_message_update AFTER UPDATE OF content ON messages BEGIN
+        UPDATE search_fts SET body = new.content
+            WHERE rowid = (SELECT id FROM search_keys WHERE kind = 'message' AND ref_id = old.id);
+    END;
+    CREATE TRIGGER IF NOT EXISTS search_message_delete AFTER DELETE ON messages BEGIN
+        DELETE FROM search_fts
+            WHERE rowid = (SELECT id FROM search_keys WHERE kind = 'message' AND ref_id = old.id);
+        DELETE FROM search_keys WHERE kind = 'message' AND ref_id = old.id;
+    END;
+    CREATE TRIGGER IF NOT EXISTS search_thread_insert AFTER INSERT ON threads BEGIN
+        INSERT INTO search_keys (kind, ref_id) VALUES ('thread', new.id);
+        INSERT INTO search_fts (rowid, body)
+            VALUES ((SELECT id FROM search_keys WHERE kind = 'thread' AND ref_id = new.id), new.title);
+    END;
+    CREATE TRIGGER IF NOT EXISTS search_thread_update AFTER UPDATE OF title ON threads BEGIN
+        UPDATE search_fts SET body = new.title
+            WHERE rowid = (SELECT id FROM search_keys WHERE kind = 'thread' AND ref_id = old.id);
+    END;
+    CREATE TRIGGER IF NOT EXISTS search_thread_delete AFTER DELETE ON threads BEGIN
+        DELETE FROM search_fts
+            WHERE rowid = (SELECT id FROM search_keys WHERE kind = 'thread' AND ref_id = old.id);
+        DELETE FROM search_keys WHERE kind = 'thread' AND ref_id = old.id;
+    END;`
+
+var searchTriggerNames = []string{
+	"search_message_insert", "search_message_update", "search_message_delete",
+	"search_thread_insert", "search_thread_update", "search_thread_delete",
+}
+
+// initSearch sets up the FTS5 index when the driver supports it, and falls
+// back to tokenized search otherwise.
+func (s *SQLiteStorage) initSearch() error {
+	if s.searchReady {
+		return nil
+	}
+	_, err := s.db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS search_fts USING fts5(body)`)
+	if err != nil {
+		if !strings.Contains(err.Error(), "no such module") {
+			return fmt.Errorf("create search index: %w", err)
+		}
+		s.fts = false
+		for _, name := range searchTriggerNames {
+			if _, err := s.db.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+				return err
+			}
+		}
+		log.Printf("sqlite: FTS5 not available in this build, using tokenized search")
+		s.searchReady = true
+		return nil
+	}
+
+	var triggers int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'search_%'`).Scan(&triggers); err != nil {
+		return err
+	}
+	if triggers != len(searchTriggerNames) {
+		if err := s.rebuildSearch(); err != nil {
+			return err
+		}
+	}
+	s.fts, s.searchReady = true, true
+	return nil
+}
+
+// rebuildSearch repopulates the index from scratch and installs the
+// triggers that keep it current.
+func (s *SQLiteStorage) rebuildSearch() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS search_keys (
+            id INTEGER PRIMARY KEY,
+            kind TEXT NOT NULL,
+            ref_id TEXT NOT NULL,
+            UNIQUE(kind, ref_id)
+        )`,
+		`DELETE FROM search_fts`,
+		`DELETE FROM search_keys`,
+		`INSERT INTO search_keys (kind, ref_id) SELECT 'thread', id FROM threads`,
+		`INSERT INTO search_keys (kind, ref_id) SELECT 'message', id FROM messages`,
+		`INSERT INTO search_fts (rowid, body)
+            SELECT k.id, t.title FROM search_keys k JOIN threads t ON k.kind = 'thread' AND t.id = k.ref_id`,
+		`INSERT INTO search_fts (rowid, body)
+            SELECT k.id, m.content FROM search_keys k JOIN messages m ON k.kind = 'message' AND m.id = k.ref_id`,
+		searchTriggers,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return rollback(tx, fmt.Errorf("rebuild search index: %w", err))
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) Search(q storage.SearchQuery) ([]storage.SearchResult, error) {
+	terms, err := storage.ParseSearch(q.Text)
+	if err != nil {
+		return nil, err
+	}
+	if !s.fts {
+		return s.searchFallback(q, terms)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = storage.DefaultSearchLimit
+	}
+
+	// Quote every term so user input is never read as FTS5 syntax.
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + strings.Join(t, " ") + `"`
+	}
+
+	query := `
+		SELECT COALESCE(m.thread_id, t.id), COALESCE(mt.title, t.title, ''),
+		       CASE WHEN k.kind = 'message' THEN k.ref_id ELSE '' END,
+		       COALESCE(m.role, ''),
+		       snippet(search_fts, 0, '**', '**', '…', 12)
+		FROM search_fts f
+		JOIN search_keys k ON k.id = f.rowid
+		LEFT JOIN messages m ON k.kind = 'message' AND m.id = k.ref_id
+		LEFT JOIN threads mt ON mt.id = m.thread_id
+		LEFT JOIN threads t ON k.kind = 'thread' AND t.id = k.ref_id
+		WHERE search_fts MATCH ?`
+	args := []interface{}{strings.Join(quoted, " AND ")}
+	if q.Role != "" {
+		query += ` AND m.role = ?`
+		args = append(args, q.Role)
+	}
+	if q.ThreadID != "" {
+		query += ` AND COALESCE(m.thread_id, t.id) = ?`
+		args = append(args, q.ThreadID)
+	}
+	if q.OwnerID != "" {
+		query += ` AND COALESCE(mt.owner_id, t.owner_id) = ?`
+		args = append(args, q.OwnerID)
+	}
+	query += ` ORDER BY rank, k.ref_id LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	results := make([]storage.SearchResult, 0)
+	for rows.Next() {
+		var r storage.SearchResult
+		if err := rows.Scan(&r.ThreadID, &r.ThreadTitle, &r.MessageID, &r.Role, &r.Snippet); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// searchFallback narrows candidates with LIKE on the first word and ranks
+// them with the same tokenized matcher as the memory backend.
+func (s *SQLiteStorage) searchFallback(q storage.SearchQuery, terms []storage.SearchTerm) ([]storage.SearchResult, error) {
+	// LIKE only folds ASCII case, so a non-ASCII word cannot narrow the scan.
+	like := "%"
+	if w := terms[0][0]; isASCII(w) {
+		like = "%" + escapeLike(w) + "%"
+	}
+
+	threadQuery := `SELECT ` + threadColumns + ` FROM threads`
+	var where []string
+	threadArgs := []interface{}{}
+	if q.ThreadID != "" {
+		where = append(where, `id = ?`)
+		threadArgs = append(threadArgs, q.ThreadID)
+	}
+	if q.OwnerID != "" {
+		where = append(where, `owner_id = ?`)
+		threadArgs = append(threadArgs, q.OwnerID)
+	}
+	if len(where) > 0 {
+		threadQuery += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	rows, err := s.db.Query(threadQuery, threadArgs...)
+	if err != nil {
+		return nil, err
+	}
+	threads, err := scanThreads(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	msgQuery := `SELECT id, thread_id, parent_id, root_id, role, content, timestamp FROM messages WHERE content LIKE ? ESCAPE '\'`
+	msgArgs := []interface{}{like}
+	if q.Role != "" {
+		msgQuery += ` AND role = ?`
+		msgArgs = append(msgArgs, q.Role)
+	}
+	if q.ThreadID != "" {
+		msgQuery += ` AND thread_id = ?`
+		msgArgs = append(msgArgs, q.ThreadID)
+	}
+	if q.OwnerID != "" {
+		msgQuery += ` AND thread_id IN (SELECT id FROM threads WHERE owner_id = ?)`
+		msgArgs = append(msgArgs, q.OwnerID)
+	}
+	rows, err = s.db.Query(msgQuery, msgArgs...)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []models.Message
+	if msgs, err = scanMessages(rows); err != nil {
+		return nil, err
+	}
+	return storage.SearchDocuments(threads, msgs, q)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -5,34 +5,15 @@ import (
 	"github.com/krackenservices/threadwell/models"
 )
 
-func (s *SQLiteStorage) ensureSettingsTable() error {
-	_, err := s.db.Exec(`
-		CREATE TABLE IF NOT EXISTS settings (
-			id TEXT PRIMARY KEY,
-			llm_provider TEXT,
-			llm_endpoint TEXT,
-			llm_api_key TEXT,
-			llm_model TEXT,
-			simulate_only BOOLEAN
-		)
-	`)
-	return err
-}
-
-func (s *SQLiteStorage) GetSettings() (*models.Settings, error) {
-	err := s.ensureSettingsTable()
-	if err != nil {
-		return nil, err
-	}
-
-	row := s.db.QueryRow(`SELECT id, llm_provider, llm_endpoint, llm_api_key, llm_model, simulate_only FROM settings WHERE id = "default"`)
+func (s *SQLiteStorage) GetSettings(id string) (*models.Settings, error) {
+	row := s.db.QueryRow(`SELECT id, llm_provider, llm_endpoint, llm_api_key, llm_model, simulate_only FROM settings WHERE id = ?`, id)
 
 	var cfg models.Settings
-	err = row.Scan(&cfg.ID, &cfg.LLMProvider, &cfg.LLMEndpoint, &cfg.LLMApiKey, &cfg.LLMName, &cfg.SimulateOnly)
+	err := row.Scan(&cfg.ID, &cfg.LLMProvider, &cfg.LLMEndpoint, &cfg.LLMApiKey, &cfg.LLMName, &cfg.SimulateOnly)
 	if err == sql.ErrNoRows {
 		// Insert default
 		cfg = models.Settings{
-			ID:           "default",
+			ID:           id,
 			LLMProvider:  "ollama",
 			LLMEndpoint:  "http://localhost:11434",
 			LLMName:      "llama3",
@@ -48,12 +29,7 @@ func (s *SQLiteStorage) GetSettings() (*models.Settings, error) {
 }
 
 func (s *SQLiteStorage) UpdateSettings(cfg models.Settings) error {
-	err := s.ensureSettingsTable()
-	if err != nil {
-		return err
-	}
-
-	_, err = s.db.Exec(`
+	_, err := s.db.Exec(`
 		INSERT INTO settings (id, llm_provider, llm_endpoint, llm_api_key, llm_model, simulate_only)
 		VALUES (?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
@@ -2,6 +2,10 @@ package sqlite
 
 import (
 	"database/sql"
+	"log"
+	"os"
+	"strings"
+
 	_ "github.com/mattn/go-sqlite3"
 
 	//"github.com/krackenservices/threadwell/models"
@@ -9,37 +13,77 @@ import (
 )
 
 type SQLiteStorage struct {
-	db *sql.DB
+	db          *sql.DB
+	fts         bool // search_fts is available and kept current by triggers
+	searchReady bool // initSearch has run for this handle
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
 func New(path string) (storage.Storage, error) {
-	db, err := sql.Open("sqlite3", path)
+	s, err := Open(path)
 	if err != nil {
 		return nil, err
 	}
-	s := &SQLiteStorage{db: db}
 	return s, s.Init()
 }
 
+// Open connects to the database without touching its schema, for tooling
+// such as the migrate command that needs to inspect it first.
+func Open(path string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite3", withForeignKeys(path))
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteStorage{db: db}, nil
+}
+
+// OpenReadOnly connects to an existing database without being able to
+// change it, for inspecting it. A missing file is an error rather than
+// being created.
+func OpenReadOnly(path string) (*SQLiteStorage, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteStorage{db: db}, nil
+}
+
+// withForeignKeys adds the DSN flag that makes every pooled connection
+// enforce foreign keys, which SQLite leaves off by default.
+func withForeignKeys(path string) string {
+	if strings.Contains(path, "_foreign_keys=") || strings.Contains(path, "_fk=") {
+		return path
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + "_foreign_keys=on"
+}
+
+// Init brings the schema up to date. It refuses to run against a database
+// written by a newer build.
 func (s *SQLiteStorage) Init() error {
-	_, err := s.db.Exec(`
-    CREATE TABLE IF NOT EXISTS threads (
-        id TEXT PRIMARY KEY,
-        title TEXT,
-        created_at INTEGER
-    );
-    CREATE TABLE IF NOT EXISTS messages (
-    id TEXT PRIMARY KEY,
-    thread_id TEXT,
-    parent_id TEXT,
-    root_id TEXT,
-    role TEXT,
-    content TEXT,
-    timestamp INTEGER,
-    FOREIGN KEY(thread_id) REFERENCES threads(id),
-    FOREIGN KEY(parent_id) REFERENCES messages(id),
-    FOREIGN KEY(root_id) REFERENCES messages(id)
-);
-`)
-	return err
+	applied, err := s.Migrate()
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		log.Printf("sqlite: applied schema migrations %v (now at version %d)", applied, LatestSchemaVersion())
+	}
+	return s.initSearch()
+}
+
+// Close releases the underlying database handle.
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
 }
@@ -2,6 +2,7 @@ package sqlite_test
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/krackenservices/threadwell/storage/sqlite"
@@ -18,8 +19,28 @@ func TestSQLiteStorage(t *testing.T) {
 		t.Fatalf("setup failed: %v", err)
 	}
 	testhelpers.RunStorageSuite(t, "sqlite", store)
+	testhelpers.RunDeleteSubtreeSuite(t, "sqlite", store)
+	testhelpers.RunIntegritySuite(t, "sqlite", store)
 	testhelpers.RunMoveSubtreeSuite(t, "sqlite", store)
+	testhelpers.RunCopySubtreeSuite(t, "sqlite", store)
+	testhelpers.RunGraftSubtreeSuite(t, "sqlite", store)
+	testhelpers.RunMergeThreadsSuite(t, "sqlite", store)
+	testhelpers.RunTreeSuite(t, "sqlite", store)
+	testhelpers.RunAncestrySuite(t, "sqlite", store)
+	testhelpers.RunPaginationSuite(t, "sqlite", store)
+	testhelpers.RunSearchSuite(t, "sqlite", store)
 	testhelpers.RunSettingsSuite(t, "sqlite", store)
+	testhelpers.RunUserSuite(t, "sqlite", store)
+	testhelpers.RunOwnershipSuite(t, "sqlite", store)
+	testhelpers.RunProviderSuite(t, "sqlite", store)
 
 	_ = os.RemoveAll("./testdata")
 }
+
+func BenchmarkSQLiteTreeQueries(b *testing.B) {
+	store, err := sqlite.New(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatalf("setup failed: %v", err)
+	}
+	testhelpers.RunTreeBenchmarks(b, "sqlite", store)
+}
@@ -2,37 +2,106 @@ package sqlite
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 
 	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
 )
 
 func (s *SQLiteStorage) ListThreads() ([]models.Thread, error) {
-	rows, err := s.db.Query(`SELECT id, title, created_at FROM threads`)
+	rows, err := s.db.Query(`SELECT ` + threadColumns + ` FROM threads ORDER BY created_at, id`)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		closeErr := rows.Close()
-		if err == nil { // Only overwrite if no earlier error
-			err = closeErr
+	return scanThreads(rows)
+}
+
+func (s *SQLiteStorage) QueryThreads(q storage.ThreadQuery) (*storage.ThreadPage, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+	after, err := storage.DecodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	// SortBy is one of two known column names after Normalize.
+	col := q.SortBy
+	query := `SELECT ` + threadColumns + ` FROM threads`
+	var where []string
+	var args []interface{}
+	cmp, dir := ">", "ASC"
+	if q.Order == storage.OrderDesc {
+		cmp, dir = "<", "DESC"
+	}
+	if q.OwnerID != "" {
+		where = append(where, `owner_id = ?`)
+		args = append(args, q.OwnerID)
+	}
+	if after != nil {
+		where = append(where, `(`+col+` `+cmp+` ? OR (`+col+` = ? AND id `+cmp+` ?))`)
+		args = append(args, after.Value, after.Value, after.ID)
+	}
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += ` ORDER BY ` + col + ` ` + dir + `, id ` + dir
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit+1)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	threads, err := scanThreads(rows)
+	if err != nil {
+		return nil, err
+	}
+	page := &storage.ThreadPage{Items: threads}
+	if q.Limit > 0 && len(threads) > q.Limit {
+		page.Items = threads[:q.Limit]
+		last := page.Items[q.Limit-1]
+		value := last.CreatedAt
+		if q.SortBy == storage.SortUpdatedAt {
+			value = last.UpdatedAt
 		}
+		page.NextCursor = storage.EncodeCursor(storage.Cursor{Value: value, ID: last.ID})
+	}
+	return page, nil
+}
+
+const threadColumns = `id, title, created_at, COALESCE(updated_at, created_at), owner_id, provider_id, model, system_prompt, temperature, max_tokens`
+
+// threadFields returns the scan destinations for threadColumns.
+func threadFields(t *models.Thread) []interface{} {
+	return []interface{}{&t.ID, &t.Title, &t.CreatedAt, &t.UpdatedAt, &t.OwnerID,
+		&t.ProviderID, &t.Model, &t.SystemPrompt, &t.Temperature, &t.MaxTokens}
+}
+
+// scanThreads reads rows selected with threadColumns and closes rows.
+func scanThreads(rows *sql.Rows) ([]models.Thread, error) {
+	defer func() {
+		_ = rows.Close()
 	}()
 
 	threads := make([]models.Thread, 0)
 	for rows.Next() {
 		var t models.Thread
-		if err := rows.Scan(&t.ID, &t.Title, &t.CreatedAt); err != nil {
+		if err := rows.Scan(threadFields(&t)...); err != nil {
 			return nil, err
 		}
 		threads = append(threads, t)
 	}
-	return threads, nil
+	return threads, rows.Err()
 }
 
 func (s *SQLiteStorage) GetThread(id string) (*models.Thread, error) {
-	row := s.db.QueryRow(`SELECT id, title, created_at FROM threads WHERE id = ?`, id)
+	row := s.db.QueryRow(`SELECT `+threadColumns+` FROM threads WHERE id = ?`, id)
 	var t models.Thread
-	if err := row.Scan(&t.ID, &t.Title, &t.CreatedAt); err != nil {
+	if err := row.Scan(threadFields(&t)...); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -42,18 +111,53 @@ func (s *SQLiteStorage) GetThread(id string) (*models.Thread, error) {
 }
 
 func (s *SQLiteStorage) CreateThread(t models.Thread) error {
-	_, err := s.db.Exec(`INSERT INTO threads (id, title, created_at) VALUES (?, ?, ?)`,
-		t.ID, t.Title, t.CreatedAt)
+	if t.UpdatedAt == 0 {
+		t.UpdatedAt = t.CreatedAt
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO threads (id, title, created_at, updated_at, owner_id, provider_id, model, system_prompt, temperature, max_tokens)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.Title, t.CreatedAt, t.UpdatedAt, t.OwnerID, t.ProviderID, t.Model, t.SystemPrompt, t.Temperature, t.MaxTokens)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return fmt.Errorf("thread %s: %w", t.ID, storage.ErrConflict)
+	}
 	return err
 }
 
 func (s *SQLiteStorage) UpdateThread(t models.Thread) error {
-	_, err := s.db.Exec(`UPDATE threads SET title = ? WHERE id = ?`,
-		t.Title, t.ID)
+	query := `UPDATE threads SET title = ?, provider_id = ?, model = ?, system_prompt = ?, temperature = ?, max_tokens = ?`
+	args := []interface{}{t.Title, t.ProviderID, t.Model, t.SystemPrompt, t.Temperature, t.MaxTokens}
+	if t.UpdatedAt != 0 {
+		query += `, updated_at = ?`
+		args = append(args, t.UpdatedAt)
+	}
+	if t.OwnerID != "" {
+		query += `, owner_id = ?`
+		args = append(args, t.OwnerID)
+	}
+	_, err := s.db.Exec(query+` WHERE id = ?`, append(args, t.ID)...)
 	return err
 }
 
 func (s *SQLiteStorage) DeleteThread(id string) error {
-	_, err := s.db.Exec(`DELETE FROM threads WHERE id = ?`, id)
-	return err
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	// Messages reference each other, so check foreign keys once at commit.
+	if _, err := tx.Exec(`PRAGMA defer_foreign_keys = ON`); err != nil {
+		return rollback(tx, err)
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM message_revisions
+		WHERE message_id IN (SELECT id FROM messages WHERE thread_id = ?)`, id); err != nil {
+		return rollback(tx, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM messages WHERE thread_id = ?`, id); err != nil {
+		return rollback(tx, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM threads WHERE id = ?`, id); err != nil {
+		return rollback(tx, err)
+	}
+	return tx.Commit()
 }
@@ -0,0 +1,147 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+const userColumns = `id, username, password_hash, created_at`
+
+func (s *SQLiteStorage) CreateUser(u models.User) error {
+	_, err := s.db.Exec(`INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?)`,
+		u.ID, u.Username, u.PasswordHash, u.CreatedAt)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return storage.ErrConflict
+	}
+	return err
+}
+
+func (s *SQLiteStorage) GetUser(id string) (*models.User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+func (s *SQLiteStorage) GetUserByUsername(username string) (*models.User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = ?`, username))
+}
+
+func (s *SQLiteStorage) ListUsers() ([]models.User, error) {
+	rows, err := s.db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	users := make([]models.User, 0)
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func scanUser(row *sql.Row) (*models.User, error) {
+	var u models.User
+	if err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *SQLiteStorage) CreateSession(sess models.Session) error {
+	_, err := s.db.Exec(`INSERT INTO sessions (id, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+		sess.ID, sess.UserID, sess.CreatedAt, sess.ExpiresAt)
+	return err
+}
+
+func (s *SQLiteStorage) GetSession(id string) (*models.Session, error) {
+	var sess models.Session
+	err := s.db.QueryRow(`SELECT id, user_id, created_at, expires_at FROM sessions WHERE id = ?`, id).
+		Scan(&sess.ID, &sess.UserID, &sess.CreatedAt, &sess.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func (s *SQLiteStorage) DeleteSession(id string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	return err
+}
+
+// Scopes are stored space-separated, as in OAuth.
+const tokenColumns = `id, user_id, name, token_hash, scopes, created_at, expires_at`
+
+func (s *SQLiteStorage) CreateAccessToken(t models.AccessToken) error {
+	_, err := s.db.Exec(`INSERT INTO access_tokens (`+tokenColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.UserID, t.Name, t.TokenHash, strings.Join(t.Scopes, " "), t.CreatedAt, t.ExpiresAt)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return storage.ErrConflict
+	}
+	return err
+}
+
+func (s *SQLiteStorage) GetAccessToken(hash string) (*models.AccessToken, error) {
+	rows, err := s.db.Query(`SELECT `+tokenColumns+` FROM access_tokens WHERE token_hash = ?`, hash)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := scanTokens(rows)
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	return &tokens[0], nil
+}
+
+func (s *SQLiteStorage) ListAccessTokens(userID string) ([]models.AccessToken, error) {
+	rows, err := s.db.Query(`SELECT `+tokenColumns+` FROM access_tokens WHERE user_id = ? ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanTokens(rows)
+}
+
+func (s *SQLiteStorage) DeleteAccessToken(userID, id string) error {
+	res, err := s.db.Exec(`DELETE FROM access_tokens WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// scanTokens reads rows selected with tokenColumns and closes rows.
+func scanTokens(rows *sql.Rows) ([]models.AccessToken, error) {
+	defer func() {
+		_ = rows.Close()
+	}()
+	tokens := make([]models.AccessToken, 0)
+	for rows.Next() {
+		var t models.AccessToken
+		var scopes string
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &scopes, &t.CreatedAt, &t.ExpiresAt); err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Fields(scopes)
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
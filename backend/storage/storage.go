@@ -1,27 +1,152 @@
 package storage
 
-import "github.com/krackenservices/threadwell/models"
+import (
+	"errors"
+
+	"github.com/krackenservices/threadwell/models"
+)
+
+var (
+	// ErrNotFound is returned when the requested record does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrHasChildren is returned when deleting a message that still has replies.
+	ErrHasChildren = errors.New("message has replies")
+	// ErrInvalidReference is returned when a message points at a thread,
+	// parent or root that does not exist or belongs to another thread.
+	ErrInvalidReference = errors.New("invalid reference")
+	// ErrCycle is returned when a message would be grafted under itself or
+	// one of its own descendants.
+	ErrCycle = errors.New("target is inside the moved subtree")
+	// ErrConflict is returned when creating a record whose unique key, such
+	// as a username or a thread or message ID, is already taken.
+	ErrConflict = errors.New("already exists")
+)
+
+// DefaultSettingsID keys the settings shared by everyone in local mode.
+const DefaultSettingsID = "default"
+
+// BranchResult describes a thread created from part of another thread.
+type BranchResult struct {
+	ThreadID string            `json:"thread_id"`
+	IDMap    map[string]string `json:"id_map"` // original message ID → new message ID
+}
 
 type Storage interface {
 	Init() error
 
 	// Threads
 	ListThreads() ([]models.Thread, error)
+	// QueryThreads returns one page of threads in the requested order.
+	// Returns ErrInvalidQuery for bad options or a foreign cursor.
+	QueryThreads(q ThreadQuery) (*ThreadPage, error)
 	GetThread(id string) (*models.Thread, error)
 	CreateThread(t models.Thread) error
+	// UpdateThread replaces a thread's title and reply settings. A zero
+	// UpdatedAt or an empty OwnerID keeps the stored value.
 	UpdateThread(t models.Thread) error
+	// DeleteThread removes a thread together with all of its messages.
 	DeleteThread(id string) error
 
 	// Messages
 	ListMessages(threadID string) ([]models.Message, error)
+	// QueryMessages returns one filtered page of a thread's messages.
+	// Returns ErrInvalidQuery for bad options or a foreign cursor.
+	QueryMessages(q MessageQuery) (*MessagePage, error)
 	GetMessage(id string) (*models.Message, error)
+	// CreateMessage stores a new message. Its thread must exist, and its parent
+	// and root, if set, must be messages in that same thread; otherwise it
+	// returns ErrInvalidReference.
 	CreateMessage(m models.Message) error
+	// UpdateMessage replaces the role and content of an existing message and
+	// records the previous version as a revision. Thread and parent are left
+	// untouched. Returns ErrNotFound for unknown IDs.
+	UpdateMessage(m models.Message) error
+	// ListMessageRevisions returns a message's previous versions, oldest first.
+	ListMessageRevisions(messageID string) ([]models.MessageRevision, error)
+	// DeleteMessage removes a single leaf message. It returns ErrHasChildren
+	// if other messages reply to it; use DeleteSubtree to remove those too.
 	DeleteMessage(id string) error
+	// DeleteSubtree removes a message and all of its descendants atomically
+	// and returns the deleted IDs. Returns ErrNotFound for unknown IDs.
+	DeleteSubtree(id string) ([]string, error)
 
 	// Tree operations (optional later)
+	// MoveSubtree moves the message and its descendants, with copies of its
+	// ancestors, into a new thread with the same owner and reply settings.
 	MoveSubtree(fromMessageID string) (string, error)
+	// CopySubtree creates a new thread holding copies of the message, its
+	// ancestors and its descendants, leaving the original thread intact. The
+	// new thread has the same owner and reply settings.
+	CopySubtree(fromMessageID string) (*BranchResult, error)
+	// GraftSubtree moves the message and its descendants under targetParentID
+	// in an existing thread, or makes it a new root there when targetParentID
+	// is nil. Moved messages get new IDs. Returns ErrCycle if the target parent
+	// is inside the subtree and ErrInvalidReference for a bad target. The
+	// target thread's UpdatedAt is set to the current time.
+	GraftSubtree(fromMessageID, targetThreadID string, targetParentID *string) (*BranchResult, error)
+	// Ancestors returns the messages above id, root first, not including id.
+	// Returns ErrNotFound for an unknown id.
+	Ancestors(id string) ([]models.Message, error)
+	// Descendants returns every message below id, not including id, level by
+	// level with each level ordered by timestamp then ID, so parents always
+	// come before their children. Returns ErrNotFound for an unknown id.
+	Descendants(id string) ([]models.Message, error)
+	// Search finds messages and thread titles containing every word and
+	// phrase in q.Text. Returns ErrInvalidQuery for an empty or malformed query.
+	Search(q SearchQuery) ([]SearchResult, error)
+	// MergeThreads copies the source thread's messages into the target thread
+	// as planned by PlanMerge, marks the target as updated now, and removes
+	// the source thread when deleteSource is set. Returns ErrNotFound for an unknown target and ErrInvalidReference
+	// for an unknown source or a target parent outside the target thread.
+	MergeThreads(targetThreadID, sourceThreadID string, targetParentID *string, deleteSource bool) (*MergeResult, error)
 
 	// Settings
-	GetSettings() (*models.Settings, error)
+	// GetSettings returns the settings stored under id (a user ID, or
+	// DefaultSettingsID), creating defaults on first use.
+	GetSettings(id string) (*models.Settings, error)
+	// UpdateSettings stores settings under their ID.
 	UpdateSettings(models.Settings) error
+
+	// Users
+	// CreateUser stores a new account. Returns ErrConflict if the username
+	// is taken.
+	CreateUser(u models.User) error
+	GetUser(id string) (*models.User, error)
+	GetUserByUsername(username string) (*models.User, error)
+	// ListUsers returns every account, oldest first.
+	ListUsers() ([]models.User, error)
+
+	// Sessions
+	CreateSession(s models.Session) error
+	// GetSession returns the session with the given token hash, or nil.
+	GetSession(id string) (*models.Session, error)
+	DeleteSession(id string) error
+
+	// Provider profiles
+	// ListProviderProfiles returns the profiles of one owner ("" in local
+	// mode), oldest first.
+	ListProviderProfiles(ownerID string) ([]models.ProviderProfile, error)
+	// GetProviderProfile returns the profile with the given ID, or nil.
+	GetProviderProfile(id string) (*models.ProviderProfile, error)
+	// CreateProviderProfile stores a new profile. Returns ErrConflict if the
+	// ID is taken. A default profile replaces the owner's previous default.
+	CreateProviderProfile(p models.ProviderProfile) error
+	// UpdateProviderProfile replaces a profile, keeping its creation time.
+	// An empty OwnerID keeps the stored owner. Returns ErrNotFound for an
+	// unknown ID. A default profile replaces the owner's previous default.
+	UpdateProviderProfile(p models.ProviderProfile) error
+	// DeleteProviderProfile removes a profile and clears it from the threads
+	// that named it, so they use the owner's default again. Returns
+	// ErrNotFound for an unknown ID.
+	DeleteProviderProfile(id string) error
+
+	// Access tokens
+	CreateAccessToken(t models.AccessToken) error
+	// GetAccessToken returns the token with the given hash, or nil.
+	GetAccessToken(hash string) (*models.AccessToken, error)
+	// ListAccessTokens returns a user's tokens, oldest first.
+	ListAccessTokens(userID string) ([]models.AccessToken, error)
+	// DeleteAccessToken revokes one of a user's tokens. Returns ErrNotFound
+	// if the user has no token with that ID.
+	DeleteAccessToken(userID, id string) error
 }
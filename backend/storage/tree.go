@@ -0,0 +1,37 @@
+package storage
+
+import "github.com/krackenservices/threadwell/models"
+
+// TreeNode is a message with its replies nested beneath it.
+type TreeNode struct {
+	models.Message
+	Depth           int         `json:"depth"`
+	ChildCount      int         `json:"child_count"`
+	DescendantCount int         `json:"descendant_count"`
+	Children        []*TreeNode `json:"children"`
+}
+
+// BuildTree nests a thread's messages under their parents. Roots, and
+// messages whose parent is not in msgs, are returned at depth 0. Siblings are
+// ordered by timestamp, then ID, so the result does not depend on the order
+// msgs came back from storage.
+func BuildTree(msgs []models.Message) []*TreeNode {
+	children := childrenByParent(msgs)
+	var build func(m models.Message, depth int) *TreeNode
+	build = func(m models.Message, depth int) *TreeNode {
+		n := &TreeNode{Message: m, Depth: depth, Children: []*TreeNode{}}
+		for _, c := range children[m.ID] {
+			child := build(c, depth+1)
+			n.Children = append(n.Children, child)
+			n.DescendantCount += 1 + child.DescendantCount
+		}
+		n.ChildCount = len(n.Children)
+		return n
+	}
+
+	roots := make([]*TreeNode, 0, len(children[""]))
+	for _, r := range children[""] {
+		roots = append(roots, build(r, 0))
+	}
+	return roots
+}
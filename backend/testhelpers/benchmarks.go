@@ -0,0 +1,95 @@
+package testhelpers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/krackenservices/threadwell/models"
+	"github.com/krackenservices/threadwell/storage"
+)
+
+// SeedThread creates a thread of n messages and returns its ID with the IDs
+// of the root and the deepest message. Messages form one long conversation
+// with a side reply every tenth turn, so the tree is both deep and branched.
+func SeedThread(tb testing.TB, store storage.Storage, n int) (threadID, rootID, leafID string) {
+	tb.Helper()
+	thread := models.Thread{ID: uuid.NewString(), Title: "Seeded", CreatedAt: 1}
+	if err := store.CreateThread(thread); err != nil {
+		tb.Fatalf("seed thread: %v", err)
+	}
+
+	root := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, Role: "user", Content: "0", Timestamp: 0}
+	if err := store.CreateMessage(root); err != nil {
+		tb.Fatalf("seed root: %v", err)
+	}
+	leaf := root
+	for i := 1; i < n; i++ {
+		role := "assistant"
+		if i%2 == 0 {
+			role = "user"
+		}
+		parentID := leaf.ID
+		msg := models.Message{
+			ID:        uuid.NewString(),
+			ThreadID:  thread.ID,
+			ParentID:  &parentID,
+			RootID:    &root.ID,
+			Role:      role,
+			Content:   fmt.Sprint(i),
+			Timestamp: int64(i),
+		}
+		if err := store.CreateMessage(msg); err != nil {
+			tb.Fatalf("seed message %d: %v", i, err)
+		}
+		if i%10 != 0 {
+			leaf = msg
+		}
+	}
+	return thread.ID, root.ID, leaf.ID
+}
+
+// RunTreeBenchmarks times the tree queries against a 10k-message thread.
+func RunTreeBenchmarks(b *testing.B, name string, store storage.Storage) {
+	if err := store.Init(); err != nil {
+		b.Fatalf("init: %v", err)
+	}
+	threadID, rootID, leafID := SeedThread(b, store, 10000)
+
+	b.Run(name+"/Ancestors_10k", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := store.Ancestors(leafID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run(name+"/Descendants_10k", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := store.Descendants(rootID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run(name+"/BuildTree_10k", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			msgs, err := store.ListMessages(threadID)
+			if err != nil {
+				b.Fatal(err)
+			}
+			storage.BuildTree(msgs)
+		}
+	})
+	b.Run(name+"/CopySubtree_10k", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			res, err := store.CopySubtree(leafID)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+			if err := store.DeleteThread(res.ThreadID); err != nil {
+				b.Fatal(err)
+			}
+			b.StartTimer()
+		}
+	})
+}
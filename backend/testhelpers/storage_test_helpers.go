@@ -1,6 +1,7 @@
 package testhelpers
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -54,6 +55,200 @@ func RunStorageSuite(t *testing.T, name string, store storage.Storage) {
 		threads, _ = store.ListThreads()
 		require.Len(t, threads, 0)
 	})
+
+	t.Run(name+"/UpdateMessage", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		thread := models.Thread{ID: uuid.NewString(), Title: "Edit", CreatedAt: time.Now().Unix()}
+		require.NoError(t, store.CreateThread(thread))
+
+		root := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, Role: "user", Content: "root", Timestamp: time.Now().Unix()}
+		child := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &root.ID, RootID: &root.ID, Role: "user", Content: "before", Timestamp: 42}
+		require.NoError(t, store.CreateMessage(root))
+		require.NoError(t, store.CreateMessage(child))
+
+		edit := child
+		edit.Role = "assistant"
+		edit.Content = "after"
+		edit.ThreadID = "ignored"
+		edit.ParentID = nil
+		require.NoError(t, store.UpdateMessage(edit))
+
+		got, err := store.GetMessage(child.ID)
+		require.NoError(t, err)
+		require.Equal(t, "assistant", got.Role)
+		require.Equal(t, "after", got.Content)
+		require.Equal(t, thread.ID, got.ThreadID)
+		require.Equal(t, root.ID, *got.ParentID)
+		require.Equal(t, int64(42), got.Timestamp)
+
+		err = store.UpdateMessage(models.Message{ID: "missing", Content: "x"})
+		require.ErrorIs(t, err, storage.ErrNotFound)
+
+		require.NoError(t, store.DeleteThread(thread.ID))
+	})
+
+	t.Run(name+"/MessageRevisions", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		thread := models.Thread{ID: uuid.NewString(), Title: "Revisions", CreatedAt: time.Now().Unix()}
+		require.NoError(t, store.CreateThread(thread))
+
+		msg := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, Role: "user", Content: "v1", Timestamp: time.Now().Unix()}
+		require.NoError(t, store.CreateMessage(msg))
+
+		revs, err := store.ListMessageRevisions(msg.ID)
+		require.NoError(t, err)
+		require.Empty(t, revs)
+
+		msg.Content = "v2"
+		require.NoError(t, store.UpdateMessage(msg))
+		msg.Role = "assistant"
+		msg.Content = "v3"
+		require.NoError(t, store.UpdateMessage(msg))
+
+		revs, err = store.ListMessageRevisions(msg.ID)
+		require.NoError(t, err)
+		require.Len(t, revs, 2)
+		require.Equal(t, 1, revs[0].Version)
+		require.Equal(t, "v1", revs[0].Content)
+		require.Equal(t, "user", revs[0].Role)
+		require.Equal(t, 2, revs[1].Version)
+		require.Equal(t, "v2", revs[1].Content)
+		require.Equal(t, msg.ID, revs[1].MessageID)
+
+		// Revisions follow a message moved into a new thread
+		newThreadID, err := store.MoveSubtree(msg.ID)
+		require.NoError(t, err)
+		moved, err := store.ListMessages(newThreadID)
+		require.NoError(t, err)
+		require.Len(t, moved, 1)
+
+		revs, err = store.ListMessageRevisions(moved[0].ID)
+		require.NoError(t, err)
+		require.Len(t, revs, 2)
+		require.Equal(t, moved[0].ID, revs[0].MessageID)
+
+		require.NoError(t, store.DeleteMessage(moved[0].ID))
+		revs, err = store.ListMessageRevisions(moved[0].ID)
+		require.NoError(t, err)
+		require.Empty(t, revs)
+
+		require.NoError(t, store.DeleteThread(thread.ID))
+		require.NoError(t, store.DeleteThread(newThreadID))
+	})
+}
+
+func RunDeleteSubtreeSuite(t *testing.T, name string, store storage.Storage) {
+	t.Run(name+"/DeleteSubtree", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		// Tree: root → a → a1, root → b
+		thread := models.Thread{ID: uuid.NewString(), Title: "Prune", CreatedAt: time.Now().Unix()}
+		require.NoError(t, store.CreateThread(thread))
+
+		root := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, Role: "user", Content: "root", Timestamp: time.Now().Unix()}
+		a := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &root.ID, RootID: &root.ID, Role: "assistant", Content: "a", Timestamp: time.Now().Unix()}
+		a1 := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &a.ID, RootID: &root.ID, Role: "user", Content: "a1", Timestamp: time.Now().Unix()}
+		b := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &root.ID, RootID: &root.ID, Role: "assistant", Content: "b", Timestamp: time.Now().Unix()}
+		for _, m := range []models.Message{root, a, a1, b} {
+			require.NoError(t, store.CreateMessage(m))
+		}
+
+		// Non-leaf messages are refused by DeleteMessage
+		require.ErrorIs(t, store.DeleteMessage(a.ID), storage.ErrHasChildren)
+		msgs, err := store.ListMessages(thread.ID)
+		require.NoError(t, err)
+		require.Len(t, msgs, 4)
+
+		deleted, err := store.DeleteSubtree(a.ID)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{a.ID, a1.ID}, deleted)
+
+		msgs, err = store.ListMessages(thread.ID)
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+		for _, m := range msgs {
+			require.Contains(t, []string{root.ID, b.ID}, m.ID)
+		}
+
+		_, err = store.DeleteSubtree(a.ID)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+
+		deleted, err = store.DeleteSubtree(root.ID)
+		require.NoError(t, err)
+		require.Len(t, deleted, 2)
+		msgs, err = store.ListMessages(thread.ID)
+		require.NoError(t, err)
+		require.Empty(t, msgs)
+
+		require.NoError(t, store.DeleteThread(thread.ID))
+	})
+}
+
+func RunIntegritySuite(t *testing.T, name string, store storage.Storage) {
+	t.Run(name+"/Integrity_RejectsBadReferences", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		t1 := models.Thread{ID: uuid.NewString(), Title: "One", CreatedAt: time.Now().Unix()}
+		t2 := models.Thread{ID: uuid.NewString(), Title: "Two", CreatedAt: time.Now().Unix()}
+		require.NoError(t, store.CreateThread(t1))
+		require.NoError(t, store.CreateThread(t2))
+
+		root := models.Message{ID: uuid.NewString(), ThreadID: t1.ID, Role: "user", Content: "root", Timestamp: time.Now().Unix()}
+		require.NoError(t, store.CreateMessage(root))
+
+		missing := "missing"
+		err := store.CreateMessage(models.Message{ID: uuid.NewString(), ThreadID: "no-thread", Role: "user"})
+		require.ErrorIs(t, err, storage.ErrInvalidReference)
+
+		err = store.CreateMessage(models.Message{ID: uuid.NewString(), ThreadID: t1.ID, ParentID: &missing, Role: "user"})
+		require.ErrorIs(t, err, storage.ErrInvalidReference)
+
+		err = store.CreateMessage(models.Message{ID: uuid.NewString(), ThreadID: t2.ID, ParentID: &root.ID, RootID: &root.ID, Role: "user"})
+		require.ErrorIs(t, err, storage.ErrInvalidReference)
+
+		msgs, err := store.ListMessages(t2.ID)
+		require.NoError(t, err)
+		require.Empty(t, msgs)
+
+		self := uuid.NewString()
+		require.NoError(t, store.CreateMessage(models.Message{ID: self, ThreadID: t1.ID, RootID: &self, Role: "user", Content: "own root"}))
+		got, err := store.GetMessage(self)
+		require.NoError(t, err)
+		require.Equal(t, self, *got.RootID)
+
+		require.NoError(t, store.DeleteThread(t1.ID))
+		require.NoError(t, store.DeleteThread(t2.ID))
+	})
+
+	t.Run(name+"/Integrity_DeleteThreadCascades", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		thread := models.Thread{ID: uuid.NewString(), Title: "Cascade", CreatedAt: time.Now().Unix()}
+		require.NoError(t, store.CreateThread(thread))
+
+		root := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, Role: "user", Content: "root", Timestamp: time.Now().Unix()}
+		child := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &root.ID, RootID: &root.ID, Role: "assistant", Content: "child", Timestamp: time.Now().Unix()}
+		require.NoError(t, store.CreateMessage(root))
+		require.NoError(t, store.CreateMessage(child))
+		child.Content = "edited"
+		require.NoError(t, store.UpdateMessage(child))
+
+		require.NoError(t, store.DeleteThread(thread.ID))
+
+		got, err := store.GetThread(thread.ID)
+		require.NoError(t, err)
+		require.Nil(t, got)
+		for _, id := range []string{root.ID, child.ID} {
+			m, err := store.GetMessage(id)
+			require.NoError(t, err)
+			require.Nil(t, m)
+		}
+		revs, err := store.ListMessageRevisions(child.ID)
+		require.NoError(t, err)
+		require.Empty(t, revs)
+	})
 }
 
 func RunMoveSubtreeSuite(t *testing.T, name string, store storage.Storage) {
@@ -87,6 +282,46 @@ func RunMoveSubtreeSuite(t *testing.T, name string, store storage.Storage) {
 		require.True(t, contents["root"])
 		require.True(t, contents["child"])
 	})
+	t.Run(name+"/MoveSubtree_InheritsReplySettings", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		profile := models.ProviderProfile{ID: uuid.NewString(), Name: "branch profile", LLMProvider: "ollama", CreatedAt: 1}
+		require.NoError(t, store.CreateProviderProfile(profile))
+		defer func() {
+			_ = store.DeleteProviderProfile(profile.ID)
+		}()
+		temperature := 0.0
+		thread := models.Thread{ID: uuid.NewString(), Title: "Configured", CreatedAt: time.Now().Unix(),
+			ProviderID: profile.ID, Model: "llama3:70b", SystemPrompt: "Answer in French.", Temperature: &temperature, MaxTokens: 512}
+		require.NoError(t, store.CreateThread(thread))
+		thread.UpdatedAt = thread.CreatedAt
+
+		stored, err := store.GetThread(thread.ID)
+		require.NoError(t, err)
+		require.Equal(t, thread, *stored)
+
+		root := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, Role: "user", Content: "root", Timestamp: time.Now().Unix()}
+		child := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &root.ID, RootID: &root.ID, Role: "assistant", Content: "child", Timestamp: time.Now().Unix()}
+		require.NoError(t, store.CreateMessage(root))
+		require.NoError(t, store.CreateMessage(child))
+
+		newThreadID, err := store.MoveSubtree(child.ID)
+		require.NoError(t, err)
+		branch, err := store.GetThread(newThreadID)
+		require.NoError(t, err)
+		require.Equal(t, thread.ProviderID, branch.ProviderID)
+		require.Equal(t, thread.Model, branch.Model)
+		require.Equal(t, thread.SystemPrompt, branch.SystemPrompt)
+		require.Equal(t, thread.Temperature, branch.Temperature)
+		require.Equal(t, thread.MaxTokens, branch.MaxTokens)
+
+		// Updating replaces the settings; a nil temperature clears it.
+		thread.Model, thread.SystemPrompt, thread.Temperature, thread.MaxTokens = "", "Be brief.", nil, 0
+		require.NoError(t, store.UpdateThread(thread))
+		stored, err = store.GetThread(thread.ID)
+		require.NoError(t, err)
+		require.Equal(t, thread, *stored)
+	})
 	t.Run(name+"/MoveSubtree_SimpleChain", func(t *testing.T) {
 		require.NoError(t, store.Init())
 
@@ -188,12 +423,594 @@ func RunMoveSubtreeSuite(t *testing.T, name string, store storage.Storage) {
 	})
 }
 
+func RunCopySubtreeSuite(t *testing.T, name string, store storage.Storage) {
+	t.Run(name+"/CopySubtree_KeepsOriginal", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		// Thread: M1 → M2 → M3, M1 → S
+		thread := models.Thread{ID: uuid.NewString(), Title: "Source", CreatedAt: time.Now().Unix()}
+		require.NoError(t, store.CreateThread(thread))
+
+		m1 := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, Role: "user", Content: "M1", Timestamp: time.Now().Unix()}
+		m2 := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &m1.ID, RootID: &m1.ID, Role: "assistant", Content: "M2", Timestamp: time.Now().Unix()}
+		m3 := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &m2.ID, RootID: &m1.ID, Role: "user", Content: "M3", Timestamp: time.Now().Unix()}
+		sib := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &m1.ID, RootID: &m1.ID, Role: "assistant", Content: "S", Timestamp: time.Now().Unix()}
+		for _, m := range []models.Message{m1, m2, m3, sib} {
+			require.NoError(t, store.CreateMessage(m))
+		}
+
+		res, err := store.CopySubtree(m2.ID)
+		require.NoError(t, err)
+		require.NotEqual(t, thread.ID, res.ThreadID)
+
+		// Original thread is untouched
+		msgsOrig, err := store.ListMessages(thread.ID)
+		require.NoError(t, err)
+		require.Len(t, msgsOrig, 4)
+
+		// New thread has M1 (ancestor), M2 and M3, but not the sibling
+		msgsNew, err := store.ListMessages(res.ThreadID)
+		require.NoError(t, err)
+		require.Len(t, msgsNew, 3)
+
+		require.Len(t, res.IDMap, 3)
+		require.NotContains(t, res.IDMap, sib.ID)
+		byID := map[string]models.Message{}
+		for _, m := range msgsNew {
+			byID[m.ID] = m
+		}
+		for oldID, newID := range res.IDMap {
+			require.NotEqual(t, oldID, newID)
+			require.Contains(t, byID, newID)
+		}
+		newM1, newM2, newM3 := byID[res.IDMap[m1.ID]], byID[res.IDMap[m2.ID]], byID[res.IDMap[m3.ID]]
+		require.Equal(t, "M1", newM1.Content)
+		require.Nil(t, newM1.ParentID)
+		require.Equal(t, newM1.ID, *newM2.ParentID)
+		require.Equal(t, newM2.ID, *newM3.ParentID)
+		require.Equal(t, newM1.ID, *newM3.RootID)
+
+		_, err = store.CopySubtree("missing")
+		require.Error(t, err)
+	})
+}
+
+func RunGraftSubtreeSuite(t *testing.T, name string, store storage.Storage) {
+	t.Run(name+"/GraftSubtree_IntoExistingThread", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		// Source: A → B → C    Target: X → Y
+		src := models.Thread{ID: uuid.NewString(), Title: "Source", CreatedAt: time.Now().Unix()}
+		dst := models.Thread{ID: uuid.NewString(), Title: "Target", CreatedAt: 100}
+		require.NoError(t, store.CreateThread(src))
+		require.NoError(t, store.CreateThread(dst))
+
+		a := models.Message{ID: uuid.NewString(), ThreadID: src.ID, Role: "user", Content: "A", Timestamp: 1}
+		b := models.Message{ID: uuid.NewString(), ThreadID: src.ID, ParentID: &a.ID, RootID: &a.ID, Role: "assistant", Content: "B", Timestamp: 2}
+		c := models.Message{ID: uuid.NewString(), ThreadID: src.ID, ParentID: &b.ID, RootID: &a.ID, Role: "user", Content: "C", Timestamp: 3}
+		x := models.Message{ID: uuid.NewString(), ThreadID: dst.ID, Role: "user", Content: "X", Timestamp: 4}
+		y := models.Message{ID: uuid.NewString(), ThreadID: dst.ID, ParentID: &x.ID, RootID: &x.ID, Role: "assistant", Content: "Y", Timestamp: 5}
+		for _, m := range []models.Message{a, b, c, x, y} {
+			require.NoError(t, store.CreateMessage(m))
+		}
+
+		before := time.Now().Unix()
+		res, err := store.GraftSubtree(b.ID, dst.ID, &y.ID)
+		require.NoError(t, err)
+		require.Equal(t, dst.ID, res.ThreadID)
+		require.Len(t, res.IDMap, 2)
+
+		// The target now sorts as recently updated
+		touched, err := store.GetThread(dst.ID)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, touched.UpdatedAt, before)
+
+		msgsSrc, err := store.ListMessages(src.ID)
+		require.NoError(t, err)
+		require.Len(t, msgsSrc, 1)
+		require.Equal(t, a.ID, msgsSrc[0].ID)
+
+		msgsDst, err := store.ListMessages(dst.ID)
+		require.NoError(t, err)
+		require.Len(t, msgsDst, 4)
+
+		newB, err := store.GetMessage(res.IDMap[b.ID])
+		require.NoError(t, err)
+		require.Equal(t, "B", newB.Content)
+		require.Equal(t, y.ID, *newB.ParentID)
+		require.Equal(t, x.ID, *newB.RootID)
+
+		newC, err := store.GetMessage(res.IDMap[c.ID])
+		require.NoError(t, err)
+		require.Equal(t, newB.ID, *newC.ParentID)
+		require.Equal(t, x.ID, *newC.RootID)
+		require.Equal(t, int64(3), newC.Timestamp)
+	})
+
+	t.Run(name+"/GraftSubtree_AsNewRoot", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		thread := models.Thread{ID: uuid.NewString(), Title: "Reroot", CreatedAt: time.Now().Unix()}
+		require.NoError(t, store.CreateThread(thread))
+
+		a := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, Role: "user", Content: "A", Timestamp: 1}
+		b := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &a.ID, RootID: &a.ID, Role: "assistant", Content: "B", Timestamp: 2}
+		require.NoError(t, store.CreateMessage(a))
+		require.NoError(t, store.CreateMessage(b))
+
+		res, err := store.GraftSubtree(b.ID, thread.ID, nil)
+		require.NoError(t, err)
+
+		newB, err := store.GetMessage(res.IDMap[b.ID])
+		require.NoError(t, err)
+		require.Nil(t, newB.ParentID)
+		require.Equal(t, newB.ID, *newB.RootID)
+
+		msgs, err := store.ListMessages(thread.ID)
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+	})
+
+	t.Run(name+"/GraftSubtree_RejectsCyclesAndBadTargets", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		thread := models.Thread{ID: uuid.NewString(), Title: "Cycle", CreatedAt: time.Now().Unix()}
+		other := models.Thread{ID: uuid.NewString(), Title: "Other", CreatedAt: time.Now().Unix()}
+		require.NoError(t, store.CreateThread(thread))
+		require.NoError(t, store.CreateThread(other))
+
+		a := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, Role: "user", Content: "A", Timestamp: 1}
+		b := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &a.ID, RootID: &a.ID, Role: "assistant", Content: "B", Timestamp: 2}
+		c := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &b.ID, RootID: &a.ID, Role: "user", Content: "C", Timestamp: 3}
+		for _, m := range []models.Message{a, b, c} {
+			require.NoError(t, store.CreateMessage(m))
+		}
+
+		_, err := store.GraftSubtree(a.ID, thread.ID, &c.ID)
+		require.ErrorIs(t, err, storage.ErrCycle)
+		_, err = store.GraftSubtree(b.ID, thread.ID, &b.ID)
+		require.ErrorIs(t, err, storage.ErrCycle)
+
+		_, err = store.GraftSubtree(b.ID, "no-thread", nil)
+		require.ErrorIs(t, err, storage.ErrInvalidReference)
+		_, err = store.GraftSubtree(c.ID, other.ID, &a.ID)
+		require.ErrorIs(t, err, storage.ErrInvalidReference)
+
+		_, err = store.GraftSubtree("missing", thread.ID, nil)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+
+		// Nothing changed
+		msgs, err := store.ListMessages(thread.ID)
+		require.NoError(t, err)
+		require.Len(t, msgs, 3)
+	})
+}
+
+func RunMergeThreadsSuite(t *testing.T, name string, store storage.Storage) {
+	t.Run(name+"/MergeThreads_UndoesMove", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		// Thread: A → B → C, then C is moved out and grows a reply D
+		thread := models.Thread{ID: uuid.NewString(), Title: "Orig", CreatedAt: 1}
+		require.NoError(t, store.CreateThread(thread))
+
+		a := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, Role: "user", Content: "A", Timestamp: 1}
+		b := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &a.ID, RootID: &a.ID, Role: "assistant", Content: "B", Timestamp: 2}
+		c := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &b.ID, RootID: &a.ID, Role: "user", Content: "C", Timestamp: 3}
+		for _, m := range []models.Message{a, b, c} {
+			require.NoError(t, store.CreateMessage(m))
+		}
+
+		branchID, err := store.MoveSubtree(c.ID)
+		require.NoError(t, err)
+
+		branch, err := store.ListMessages(branchID)
+		require.NoError(t, err)
+		require.Len(t, branch, 3)
+		var movedC models.Message
+		for _, m := range branch {
+			if m.Content == "C" {
+				movedC = m
+			}
+		}
+		d := models.Message{ID: uuid.NewString(), ThreadID: branchID, ParentID: &movedC.ID, RootID: movedC.RootID, Role: "assistant", Content: "D", Timestamp: 4}
+		require.NoError(t, store.CreateMessage(d))
+
+		before := time.Now().Unix()
+		res, err := store.MergeThreads(thread.ID, branchID, nil, true)
+		require.NoError(t, err)
+		require.True(t, res.SourceDeleted)
+		require.Len(t, res.Deduplicated, 2)
+		require.Len(t, res.IDMap, 2)
+
+		// The merge counts as an update to the target.
+		merged, err := store.GetThread(thread.ID)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, merged.UpdatedAt, before)
+
+		msgs, err := store.ListMessages(thread.ID)
+		require.NoError(t, err)
+		require.Len(t, msgs, 4)
+
+		newC, err := store.GetMessage(res.IDMap[movedC.ID])
+		require.NoError(t, err)
+		require.Equal(t, "C", newC.Content)
+		require.Equal(t, b.ID, *newC.ParentID)
+		require.Equal(t, a.ID, *newC.RootID)
+
+		newD, err := store.GetMessage(res.IDMap[d.ID])
+		require.NoError(t, err)
+		require.Equal(t, newC.ID, *newD.ParentID)
+		require.Equal(t, a.ID, *newD.RootID)
+
+		gone, err := store.GetThread(branchID)
+		require.NoError(t, err)
+		require.Nil(t, gone)
+		leftover, err := store.ListMessages(branchID)
+		require.NoError(t, err)
+		require.Len(t, leftover, 0)
+	})
+
+	t.Run(name+"/MergeThreads_UnderParentKeepsSource", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		dst := models.Thread{ID: uuid.NewString(), Title: "Target", CreatedAt: time.Now().Unix()}
+		src := models.Thread{ID: uuid.NewString(), Title: "Source", CreatedAt: time.Now().Unix()}
+		require.NoError(t, store.CreateThread(dst))
+		require.NoError(t, store.CreateThread(src))
+
+		x := models.Message{ID: uuid.NewString(), ThreadID: dst.ID, Role: "user", Content: "X", Timestamp: 1}
+		p := models.Message{ID: uuid.NewString(), ThreadID: src.ID, Role: "user", Content: "P", Timestamp: 2}
+		q := models.Message{ID: uuid.NewString(), ThreadID: src.ID, ParentID: &p.ID, RootID: &p.ID, Role: "assistant", Content: "Q", Timestamp: 3}
+		for _, m := range []models.Message{x, p, q} {
+			require.NoError(t, store.CreateMessage(m))
+		}
+
+		res, err := store.MergeThreads(dst.ID, src.ID, &x.ID, false)
+		require.NoError(t, err)
+		require.False(t, res.SourceDeleted)
+		require.Empty(t, res.Deduplicated)
+
+		newP, err := store.GetMessage(res.IDMap[p.ID])
+		require.NoError(t, err)
+		require.Equal(t, x.ID, *newP.ParentID)
+		require.Equal(t, x.ID, *newP.RootID)
+
+		newQ, err := store.GetMessage(res.IDMap[q.ID])
+		require.NoError(t, err)
+		require.Equal(t, newP.ID, *newQ.ParentID)
+
+		msgsSrc, err := store.ListMessages(src.ID)
+		require.NoError(t, err)
+		require.Len(t, msgsSrc, 2)
+		msgsDst, err := store.ListMessages(dst.ID)
+		require.NoError(t, err)
+		require.Len(t, msgsDst, 3)
+	})
+
+	t.Run(name+"/MergeThreads_RejectsBadReferences", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		dst := models.Thread{ID: uuid.NewString(), Title: "Target", CreatedAt: time.Now().Unix()}
+		src := models.Thread{ID: uuid.NewString(), Title: "Source", CreatedAt: time.Now().Unix()}
+		require.NoError(t, store.CreateThread(dst))
+		require.NoError(t, store.CreateThread(src))
+		s := models.Message{ID: uuid.NewString(), ThreadID: src.ID, Role: "user", Content: "S", Timestamp: 1}
+		require.NoError(t, store.CreateMessage(s))
+
+		_, err := store.MergeThreads("no-thread", src.ID, nil, false)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = store.MergeThreads(dst.ID, "no-thread", nil, false)
+		require.ErrorIs(t, err, storage.ErrInvalidReference)
+		_, err = store.MergeThreads(dst.ID, dst.ID, nil, false)
+		require.ErrorIs(t, err, storage.ErrInvalidReference)
+		_, err = store.MergeThreads(dst.ID, src.ID, &s.ID, true)
+		require.ErrorIs(t, err, storage.ErrInvalidReference)
+
+		// Nothing changed
+		msgs, err := store.ListMessages(src.ID)
+		require.NoError(t, err)
+		require.Len(t, msgs, 1)
+		msgs, err = store.ListMessages(dst.ID)
+		require.NoError(t, err)
+		require.Len(t, msgs, 0)
+	})
+}
+
+func RunTreeSuite(t *testing.T, name string, store storage.Storage) {
+	t.Run(name+"/Tree_DeterministicOrder", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		thread := models.Thread{ID: uuid.NewString(), Title: "Tree", CreatedAt: time.Now().Unix()}
+		require.NoError(t, store.CreateThread(thread))
+
+		// r1 → {c, b (same timestamp, ordered by ID), d → e}, r2
+		r1 := models.Message{ID: "t-r1", ThreadID: thread.ID, Role: "user", Content: "r1", Timestamp: 1}
+		r2 := models.Message{ID: "t-r2", ThreadID: thread.ID, Role: "user", Content: "r2", Timestamp: 1}
+		c := models.Message{ID: "t-c", ThreadID: thread.ID, ParentID: &r1.ID, RootID: &r1.ID, Role: "assistant", Content: "c", Timestamp: 2}
+		b := models.Message{ID: "t-b", ThreadID: thread.ID, ParentID: &r1.ID, RootID: &r1.ID, Role: "assistant", Content: "b", Timestamp: 2}
+		d := models.Message{ID: "t-d", ThreadID: thread.ID, ParentID: &r1.ID, RootID: &r1.ID, Role: "assistant", Content: "d", Timestamp: 3}
+		e := models.Message{ID: "t-e", ThreadID: thread.ID, ParentID: &d.ID, RootID: &r1.ID, Role: "user", Content: "e", Timestamp: 4}
+		for _, m := range []models.Message{r2, r1, d, c, e, b} {
+			require.NoError(t, store.CreateMessage(m))
+		}
+		defer func() {
+			require.NoError(t, store.DeleteThread(thread.ID))
+		}()
+
+		msgs, err := store.ListMessages(thread.ID)
+		require.NoError(t, err)
+		var order []string
+		for _, m := range msgs {
+			order = append(order, m.ID)
+		}
+		require.Equal(t, []string{"t-r1", "t-r2", "t-b", "t-c", "t-d", "t-e"}, order)
+
+		tree := storage.BuildTree(msgs)
+		require.Len(t, tree, 2)
+		require.Equal(t, "t-r1", tree[0].ID)
+		require.Equal(t, 3, tree[0].ChildCount)
+		require.Equal(t, 4, tree[0].DescendantCount)
+		require.Equal(t, "t-r2", tree[1].ID)
+		require.Equal(t, 0, tree[1].DescendantCount)
+		require.Empty(t, tree[1].Children)
+
+		var kids []string
+		for _, k := range tree[0].Children {
+			kids = append(kids, k.ID)
+			require.Equal(t, 1, k.Depth)
+		}
+		require.Equal(t, []string{"t-b", "t-c", "t-d"}, kids)
+
+		leaf := tree[0].Children[2].Children[0]
+		require.Equal(t, "t-e", leaf.ID)
+		require.Equal(t, 2, leaf.Depth)
+		require.Equal(t, 0, leaf.ChildCount)
+	})
+}
+
+func RunAncestrySuite(t *testing.T, name string, store storage.Storage) {
+	t.Run(name+"/Ancestors_And_Descendants", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		thread := models.Thread{ID: uuid.NewString(), Title: "Ancestry", CreatedAt: time.Now().Unix()}
+		require.NoError(t, store.CreateThread(thread))
+
+		// A → {C, B → D}: B and C share a timestamp so ID breaks the tie
+		a := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, Role: "user", Content: "A", Timestamp: 1}
+		b := models.Message{ID: "anc-b-" + uuid.NewString(), ThreadID: thread.ID, ParentID: &a.ID, RootID: &a.ID, Role: "assistant", Content: "B", Timestamp: 2}
+		c := models.Message{ID: "anc-c-" + uuid.NewString(), ThreadID: thread.ID, ParentID: &a.ID, RootID: &a.ID, Role: "assistant", Content: "C", Timestamp: 2}
+		d := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &b.ID, RootID: &a.ID, Role: "user", Content: "D", Timestamp: 3}
+		for _, m := range []models.Message{a, c, b, d} {
+			require.NoError(t, store.CreateMessage(m))
+		}
+
+		up, err := store.Ancestors(d.ID)
+		require.NoError(t, err)
+		require.Len(t, up, 2)
+		require.Equal(t, a.ID, up[0].ID)
+		require.Equal(t, b.ID, up[1].ID)
+
+		up, err = store.Ancestors(a.ID)
+		require.NoError(t, err)
+		require.Empty(t, up)
+
+		down, err := store.Descendants(a.ID)
+		require.NoError(t, err)
+		require.Len(t, down, 3)
+		require.Equal(t, []string{b.ID, c.ID, d.ID}, []string{down[0].ID, down[1].ID, down[2].ID})
+		require.Equal(t, "D", down[2].Content)
+
+		down, err = store.Descendants(d.ID)
+		require.NoError(t, err)
+		require.Empty(t, down)
+
+		_, err = store.Ancestors("missing")
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = store.Descendants("missing")
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	})
+}
+
+func RunPaginationSuite(t *testing.T, name string, store storage.Storage) {
+	// collect pages through every thread, checking each page's size
+	pageThreads := func(t *testing.T, q storage.ThreadQuery) []string {
+		var ids []string
+		for {
+			page, err := store.QueryThreads(q)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page.Items), q.Limit)
+			for _, th := range page.Items {
+				ids = append(ids, th.ID)
+			}
+			if page.NextCursor == "" {
+				return ids
+			}
+			q.Cursor = page.NextCursor
+		}
+	}
+
+	t.Run(name+"/QueryThreads_Pages", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		// Other suites share this store, so only check relative order
+		base := time.Now().Unix() + 1000
+		a := models.Thread{ID: "page-a-" + uuid.NewString(), Title: "a", CreatedAt: base, UpdatedAt: base + 5}
+		b := models.Thread{ID: "page-b-" + uuid.NewString(), Title: "b", CreatedAt: base, UpdatedAt: base + 1}
+		c := models.Thread{ID: "page-c-" + uuid.NewString(), Title: "c", CreatedAt: base + 1, UpdatedAt: base + 1}
+		for _, th := range []models.Thread{c, b, a} {
+			require.NoError(t, store.CreateThread(th))
+		}
+		defer func() {
+			for _, th := range []models.Thread{a, b, c} {
+				require.NoError(t, store.DeleteThread(th.ID))
+			}
+		}()
+
+		all, err := store.QueryThreads(storage.ThreadQuery{})
+		require.NoError(t, err)
+		require.Empty(t, all.NextCursor)
+		var want []string
+		for _, th := range all.Items {
+			want = append(want, th.ID)
+		}
+		require.Equal(t, want, pageThreads(t, storage.ThreadQuery{Limit: 2}))
+
+		require.Equal(t, []string{a.ID, b.ID, c.ID}, only(pageThreads(t, storage.ThreadQuery{Limit: 2}), a.ID, b.ID, c.ID))
+		require.Equal(t, []string{c.ID, b.ID, a.ID}, only(pageThreads(t, storage.ThreadQuery{Limit: 1, Order: storage.OrderDesc}), a.ID, b.ID, c.ID))
+		require.Equal(t, []string{b.ID, c.ID, a.ID}, only(pageThreads(t, storage.ThreadQuery{Limit: 2, SortBy: storage.SortUpdatedAt}), a.ID, b.ID, c.ID))
+
+		// Posting a message bumps updated_at
+		m := models.Message{ID: uuid.NewString(), ThreadID: b.ID, Role: "user", Content: "hi", Timestamp: base + 10}
+		require.NoError(t, store.CreateMessage(m))
+		got, err := store.GetThread(b.ID)
+		require.NoError(t, err)
+		require.Equal(t, base+10, got.UpdatedAt)
+		require.Equal(t, []string{c.ID, a.ID, b.ID}, only(pageThreads(t, storage.ThreadQuery{Limit: 2, SortBy: storage.SortUpdatedAt}), a.ID, b.ID, c.ID))
+	})
+
+	t.Run(name+"/QueryMessages_FiltersAndPages", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		thread := models.Thread{ID: uuid.NewString(), Title: "Paged", CreatedAt: time.Now().Unix()}
+		require.NoError(t, store.CreateThread(thread))
+
+		var ids []string
+		for i := 0; i < 6; i++ {
+			role := "user"
+			if i%2 == 1 {
+				role = "assistant"
+			}
+			// pairs share a timestamp, so the cursor must break ties by ID
+			m := models.Message{ID: fmt.Sprintf("page-m%d-%s", i, uuid.NewString()), ThreadID: thread.ID, Role: role, Content: fmt.Sprint(i), Timestamp: int64(10 + i/2)}
+			require.NoError(t, store.CreateMessage(m))
+			ids = append(ids, m.ID)
+		}
+
+		var got []string
+		q := storage.MessageQuery{ThreadID: thread.ID, Limit: 4}
+		for {
+			page, err := store.QueryMessages(q)
+			require.NoError(t, err)
+			for _, m := range page.Items {
+				got = append(got, m.ID)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+		require.Equal(t, ids, got)
+
+		page, err := store.QueryMessages(storage.MessageQuery{ThreadID: thread.ID, Role: "assistant", Order: storage.OrderDesc})
+		require.NoError(t, err)
+		require.Len(t, page.Items, 3)
+		require.Equal(t, ids[5], page.Items[0].ID)
+		require.Equal(t, ids[1], page.Items[2].ID)
+
+		page, err = store.QueryMessages(storage.MessageQuery{ThreadID: thread.ID, Since: 11, Until: 11})
+		require.NoError(t, err)
+		require.Len(t, page.Items, 2)
+		require.Equal(t, ids[2], page.Items[0].ID)
+		require.Equal(t, ids[3], page.Items[1].ID)
+
+		_, err = store.QueryMessages(storage.MessageQuery{ThreadID: thread.ID, Cursor: "not a cursor"})
+		require.ErrorIs(t, err, storage.ErrInvalidQuery)
+		_, err = store.QueryMessages(storage.MessageQuery{ThreadID: thread.ID, SortBy: "content"})
+		require.ErrorIs(t, err, storage.ErrInvalidQuery)
+		_, err = store.QueryThreads(storage.ThreadQuery{Order: "sideways"})
+		require.ErrorIs(t, err, storage.ErrInvalidQuery)
+	})
+}
+
+// only keeps the ids that are in keep, preserving their order.
+func only(ids []string, keep ...string) []string {
+	set := map[string]bool{}
+	for _, id := range keep {
+		set[id] = true
+	}
+	var out []string
+	for _, id := range ids {
+		if set[id] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func RunSearchSuite(t *testing.T, name string, store storage.Storage) {
+	t.Run(name+"/Search_WordsPhrasesAndFilters", func(t *testing.T) {
+		require.NoError(t, store.Init())
+
+		// Other suites share this store, so search for words only used here
+		thread := models.Thread{ID: uuid.NewString(), Title: "Zebrafish husbandry notes", CreatedAt: time.Now().Unix()}
+		other := models.Thread{ID: uuid.NewString(), Title: "Unrelated", CreatedAt: time.Now().Unix()}
+		require.NoError(t, store.CreateThread(thread))
+		require.NoError(t, store.CreateThread(other))
+
+		q := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, Role: "user", Content: "How warm should zebrafish tanks be?", Timestamp: 1}
+		a := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &q.ID, RootID: &q.ID, Role: "assistant", Content: "Zebrafish tanks are usually kept warm, around 28 degrees.", Timestamp: 2}
+		o := models.Message{ID: uuid.NewString(), ThreadID: other.ID, Role: "user", Content: "Tanks warm zebrafish quickly in summer", Timestamp: 3}
+		for _, m := range []models.Message{q, a, o} {
+			require.NoError(t, store.CreateMessage(m))
+		}
+
+		results, err := store.Search(storage.SearchQuery{Text: "ZEBRAFISH"})
+		require.NoError(t, err)
+		require.Len(t, results, 4)
+
+		ids := func(rs []storage.SearchResult) map[string]bool {
+			out := map[string]bool{}
+			for _, r := range rs {
+				out[r.MessageID] = true
+			}
+			return out
+		}
+		require.True(t, ids(results)[""], "thread title should match")
+
+		results, err = store.Search(storage.SearchQuery{Text: `"zebrafish tanks" warm`})
+		require.NoError(t, err)
+		require.Equal(t, map[string]bool{q.ID: true, a.ID: true}, ids(results))
+
+		results, err = store.Search(storage.SearchQuery{Text: "zebrafish", Role: "assistant"})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Equal(t, a.ID, results[0].MessageID)
+		require.Equal(t, thread.ID, results[0].ThreadID)
+		require.Equal(t, thread.Title, results[0].ThreadTitle)
+		require.Equal(t, "assistant", results[0].Role)
+		require.Contains(t, results[0].Snippet, "**Zebrafish**")
+
+		results, err = store.Search(storage.SearchQuery{Text: "zebrafish", ThreadID: other.ID})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Equal(t, o.ID, results[0].MessageID)
+
+		results, err = store.Search(storage.SearchQuery{Text: "zebrafish", Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+
+		// Edits and deletes are reflected
+		a.Content = "Keep the water at 28 degrees."
+		require.NoError(t, store.UpdateMessage(a))
+		require.NoError(t, store.DeleteThread(other.ID))
+		results, err = store.Search(storage.SearchQuery{Text: "zebrafish"})
+		require.NoError(t, err)
+		require.Equal(t, map[string]bool{"": true, q.ID: true}, ids(results))
+
+		_, err = store.Search(storage.SearchQuery{Text: "  "})
+		require.ErrorIs(t, err, storage.ErrInvalidQuery)
+		_, err = store.Search(storage.SearchQuery{Text: `"open phrase`})
+		require.ErrorIs(t, err, storage.ErrInvalidQuery)
+	})
+}
+
 func RunSettingsSuite(t *testing.T, name string, s storage.Storage) {
 	t.Run(name+"/Settings_CRUD", func(t *testing.T) {
 		require.NoError(t, s.Init())
 
 		// Get default
-		settings, err := s.GetSettings()
+		settings, err := s.GetSettings(storage.DefaultSettingsID)
 		require.NoError(t, err)
 		require.Equal(t, "default", settings.ID)
 
@@ -209,8 +1026,356 @@ func RunSettingsSuite(t *testing.T, name string, s storage.Storage) {
 
 		require.NoError(t, s.UpdateSettings(input))
 
-		out, err := s.GetSettings()
+		out, err := s.GetSettings(storage.DefaultSettingsID)
+		require.NoError(t, err)
+		require.Equal(t, input, *out)
+	})
+
+	t.Run(name+"/Settings_PerUser", func(t *testing.T) {
+		require.NoError(t, s.Init())
+
+		userID := uuid.NewString()
+		fresh, err := s.GetSettings(userID)
+		require.NoError(t, err)
+		require.Equal(t, userID, fresh.ID)
+		require.Empty(t, fresh.LLMApiKey)
+
+		input := models.Settings{ID: userID, LLMProvider: "openai", LLMApiKey: "user-key", LLMName: "gpt"}
+		require.NoError(t, s.UpdateSettings(input))
+		out, err := s.GetSettings(userID)
 		require.NoError(t, err)
 		require.Equal(t, input, *out)
+
+		shared, err := s.GetSettings(storage.DefaultSettingsID)
+		require.NoError(t, err)
+		require.NotEqual(t, "user-key", shared.LLMApiKey)
 	})
 }
+
+func RunUserSuite(t *testing.T, name string, s storage.Storage) {
+	t.Run(name+"/Users", func(t *testing.T) {
+		require.NoError(t, s.Init())
+
+		alice := models.User{ID: uuid.NewString(), Username: "alice-" + uuid.NewString(), PasswordHash: "hash-a", CreatedAt: 1}
+		bob := models.User{ID: uuid.NewString(), Username: "bob-" + uuid.NewString(), PasswordHash: "hash-b", CreatedAt: 2}
+		require.NoError(t, s.CreateUser(alice))
+		require.NoError(t, s.CreateUser(bob))
+
+		dup := models.User{ID: uuid.NewString(), Username: alice.Username, PasswordHash: "x", CreatedAt: 3}
+		require.ErrorIs(t, s.CreateUser(dup), storage.ErrConflict)
+
+		got, err := s.GetUser(alice.ID)
+		require.NoError(t, err)
+		require.Equal(t, alice, *got)
+		got, err = s.GetUserByUsername(bob.Username)
+		require.NoError(t, err)
+		require.Equal(t, bob, *got)
+
+		missing, err := s.GetUser("missing")
+		require.NoError(t, err)
+		require.Nil(t, missing)
+		missing, err = s.GetUserByUsername("missing")
+		require.NoError(t, err)
+		require.Nil(t, missing)
+
+		users, err := s.ListUsers()
+		require.NoError(t, err)
+		var ids []string
+		for _, u := range users {
+			if u.ID == alice.ID || u.ID == bob.ID {
+				ids = append(ids, u.ID)
+			}
+		}
+		require.Equal(t, []string{alice.ID, bob.ID}, ids)
+	})
+
+	t.Run(name+"/Sessions", func(t *testing.T) {
+		require.NoError(t, s.Init())
+
+		user := models.User{ID: uuid.NewString(), Username: "sess-" + uuid.NewString(), PasswordHash: "h", CreatedAt: 1}
+		require.NoError(t, s.CreateUser(user))
+
+		sess := models.Session{ID: uuid.NewString(), UserID: user.ID, CreatedAt: 10, ExpiresAt: 20}
+		require.NoError(t, s.CreateSession(sess))
+		got, err := s.GetSession(sess.ID)
+		require.NoError(t, err)
+		require.Equal(t, sess, *got)
+
+		require.NoError(t, s.DeleteSession(sess.ID))
+		got, err = s.GetSession(sess.ID)
+		require.NoError(t, err)
+		require.Nil(t, got)
+		require.NoError(t, s.DeleteSession(sess.ID))
+	})
+
+	t.Run(name+"/AccessTokens", func(t *testing.T) {
+		require.NoError(t, s.Init())
+
+		user := models.User{ID: uuid.NewString(), Username: "tok-" + uuid.NewString(), PasswordHash: "h", CreatedAt: 1}
+		other := models.User{ID: uuid.NewString(), Username: "tok-" + uuid.NewString(), PasswordHash: "h", CreatedAt: 1}
+		require.NoError(t, s.CreateUser(user))
+		require.NoError(t, s.CreateUser(other))
+
+		first := models.AccessToken{ID: uuid.NewString(), UserID: user.ID, Name: "ci", TokenHash: uuid.NewString(),
+			Scopes: []string{"threads:read", "messages:write"}, CreatedAt: 10}
+		second := models.AccessToken{ID: uuid.NewString(), UserID: user.ID, Name: "backup", TokenHash: uuid.NewString(),
+			Scopes: []string{"settings:admin"}, CreatedAt: 20, ExpiresAt: 30}
+		require.NoError(t, s.CreateAccessToken(second))
+		require.NoError(t, s.CreateAccessToken(first))
+
+		dup := first
+		dup.ID = uuid.NewString()
+		require.ErrorIs(t, s.CreateAccessToken(dup), storage.ErrConflict)
+
+		got, err := s.GetAccessToken(first.TokenHash)
+		require.NoError(t, err)
+		require.Equal(t, first, *got)
+		got, err = s.GetAccessToken("missing")
+		require.NoError(t, err)
+		require.Nil(t, got)
+
+		tokens, err := s.ListAccessTokens(user.ID)
+		require.NoError(t, err)
+		require.Equal(t, []models.AccessToken{first, second}, tokens)
+		tokens, err = s.ListAccessTokens(other.ID)
+		require.NoError(t, err)
+		require.Empty(t, tokens)
+
+		// Only the owner can revoke a token.
+		require.ErrorIs(t, s.DeleteAccessToken(other.ID, first.ID), storage.ErrNotFound)
+		require.NoError(t, s.DeleteAccessToken(user.ID, first.ID))
+		require.ErrorIs(t, s.DeleteAccessToken(user.ID, first.ID), storage.ErrNotFound)
+		got, err = s.GetAccessToken(first.TokenHash)
+		require.NoError(t, err)
+		require.Nil(t, got)
+
+		// The scoped view only reaches the user's own tokens.
+		view := storage.ForUser(s, other.ID)
+		tokens, err = view.ListAccessTokens(user.ID)
+		require.NoError(t, err)
+		require.Empty(t, tokens)
+		require.ErrorIs(t, view.DeleteAccessToken(user.ID, second.ID), storage.ErrNotFound)
+	})
+}
+
+// RunOwnershipSuite checks that storage.ForUser keeps users apart on top of
+// the backend, including the owner and search filters it pushes down.
+func RunOwnershipSuite(t *testing.T, name string, base storage.Storage) {
+	t.Run(name+"/Ownership", func(t *testing.T) {
+		require.NoError(t, base.Init())
+		aliceID, bobID := uuid.NewString(), uuid.NewString()
+		alice, bob := storage.ForUser(base, aliceID), storage.ForUser(base, bobID)
+
+		thread := models.Thread{ID: uuid.NewString(), Title: "Alice plans", CreatedAt: 1}
+		require.NoError(t, alice.CreateThread(thread))
+		root := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, Role: "user", Content: "secret ownership words", Timestamp: 2}
+		require.NoError(t, alice.CreateMessage(root))
+		reply := models.Message{ID: uuid.NewString(), ThreadID: thread.ID, ParentID: &root.ID, RootID: &root.ID, Role: "assistant", Content: "reply", Timestamp: 3}
+		require.NoError(t, alice.CreateMessage(reply))
+		defer func() {
+			_ = base.DeleteThread(thread.ID)
+		}()
+
+		stored, err := base.GetThread(thread.ID)
+		require.NoError(t, err)
+		require.Equal(t, aliceID, stored.OwnerID)
+
+		// Bob sees nothing of Alice's.
+		got, err := bob.GetThread(thread.ID)
+		require.NoError(t, err)
+		require.Nil(t, got)
+		threads, err := bob.ListThreads()
+		require.NoError(t, err)
+		require.Empty(t, threads)
+		page, err := bob.QueryThreads(storage.ThreadQuery{})
+		require.NoError(t, err)
+		require.Empty(t, page.Items)
+		msgs, err := bob.ListMessages(thread.ID)
+		require.NoError(t, err)
+		require.Empty(t, msgs)
+		msgPage, err := bob.QueryMessages(storage.MessageQuery{ThreadID: thread.ID})
+		require.NoError(t, err)
+		require.Empty(t, msgPage.Items)
+		msg, err := bob.GetMessage(root.ID)
+		require.NoError(t, err)
+		require.Nil(t, msg)
+		results, err := bob.Search(storage.SearchQuery{Text: "ownership"})
+		require.NoError(t, err)
+		require.Empty(t, results)
+
+		// Nor can he change it, or take it over by reusing its IDs.
+		require.ErrorIs(t, bob.CreateMessage(models.Message{ID: uuid.NewString(), ThreadID: thread.ID, Role: "user", Timestamp: 4}), storage.ErrInvalidReference)
+		require.ErrorIs(t, bob.CreateThread(models.Thread{ID: thread.ID, Title: "hijacked", CreatedAt: 4}), storage.ErrConflict)
+		require.ErrorIs(t, base.CreateThread(models.Thread{ID: thread.ID, Title: "hijacked", OwnerID: bobID, CreatedAt: 4}), storage.ErrConflict)
+		bobThread := models.Thread{ID: uuid.NewString(), Title: "Bob plans", CreatedAt: 4}
+		require.NoError(t, bob.CreateThread(bobThread))
+		defer func() {
+			_ = base.DeleteThread(bobThread.ID)
+		}()
+		require.ErrorIs(t, bob.CreateMessage(models.Message{ID: root.ID, ThreadID: bobThread.ID, Role: "user", Content: "hijacked", Timestamp: 5}), storage.ErrConflict)
+		require.ErrorIs(t, base.CreateMessage(models.Message{ID: root.ID, ThreadID: bobThread.ID, Role: "user", Content: "hijacked", Timestamp: 5}), storage.ErrConflict)
+		stored, err = alice.GetThread(thread.ID)
+		require.NoError(t, err)
+		require.Equal(t, "Alice plans", stored.Title)
+		msg, err = alice.GetMessage(root.ID)
+		require.NoError(t, err)
+		require.Equal(t, "secret ownership words", msg.Content)
+		require.ErrorIs(t, bob.UpdateMessage(models.Message{ID: root.ID, Role: "user", Content: "hijacked"}), storage.ErrNotFound)
+		require.ErrorIs(t, bob.UpdateThread(models.Thread{ID: thread.ID, Title: "hijacked"}), storage.ErrNotFound)
+		_, err = bob.DeleteSubtree(root.ID)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = bob.MoveSubtree(reply.ID)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = bob.Ancestors(reply.ID)
+		require.ErrorIs(t, err, storage.ErrNotFound)
+		require.NoError(t, bob.DeleteThread(thread.ID))
+		msgs, err = alice.ListMessages(thread.ID)
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+
+		// Alice sees her own, and threads branched from it stay hers.
+		threads, err = alice.ListThreads()
+		require.NoError(t, err)
+		require.Len(t, threads, 1)
+		results, err = alice.Search(storage.SearchQuery{Text: "ownership"})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		branch, err := alice.CopySubtree(reply.ID)
+		require.NoError(t, err)
+		defer func() {
+			_ = base.DeleteThread(branch.ThreadID)
+		}()
+		copied, err := alice.GetThread(branch.ThreadID)
+		require.NoError(t, err)
+		require.NotNil(t, copied)
+		require.Equal(t, aliceID, copied.OwnerID)
+
+		// An update without an owner keeps the stored one.
+		require.NoError(t, base.UpdateThread(models.Thread{ID: thread.ID, Title: "Renamed"}))
+		stored, err = base.GetThread(thread.ID)
+		require.NoError(t, err)
+		require.Equal(t, "Renamed", stored.Title)
+		require.Equal(t, aliceID, stored.OwnerID)
+
+		// Settings are kept per user whatever ID is asked for.
+		require.NoError(t, alice.UpdateSettings(models.Settings{ID: storage.DefaultSettingsID, LLMProvider: "openai", LLMApiKey: "alice-key"}))
+		cfg, err := bob.GetSettings(aliceID)
+		require.NoError(t, err)
+		require.Equal(t, bobID, cfg.ID)
+		require.Empty(t, cfg.LLMApiKey)
+		cfg, err = alice.GetSettings(storage.DefaultSettingsID)
+		require.NoError(t, err)
+		require.Equal(t, "alice-key", cfg.LLMApiKey)
+		shared, err := base.GetSettings(storage.DefaultSettingsID)
+		require.NoError(t, err)
+		require.NotEqual(t, "alice-key", shared.LLMApiKey)
+	})
+}
+
+// RunProviderSuite checks provider profiles, the single default per owner,
+// and the profile a thread names.
+func RunProviderSuite(t *testing.T, name string, s storage.Storage) {
+	t.Run(name+"/ProviderProfiles", func(t *testing.T) {
+		require.NoError(t, s.Init())
+		owner, other := uuid.NewString(), uuid.NewString()
+
+		local := models.ProviderProfile{ID: uuid.NewString(), OwnerID: owner, Name: "local", LLMProvider: "ollama",
+			LLMEndpoint: "http://localhost:11434", LLMName: "llama3", IsDefault: true, CreatedAt: 1, UpdatedAt: 1}
+		hosted := models.ProviderProfile{ID: uuid.NewString(), OwnerID: owner, Name: "hosted", LLMProvider: "openai",
+			LLMApiKey: "sk-test", LLMName: "gpt-4o", CreatedAt: 2, UpdatedAt: 2}
+		theirs := models.ProviderProfile{ID: uuid.NewString(), OwnerID: other, Name: "theirs", LLMProvider: "ollama",
+			IsDefault: true, CreatedAt: 3, UpdatedAt: 3}
+		require.NoError(t, s.CreateProviderProfile(local))
+		require.NoError(t, s.CreateProviderProfile(hosted))
+		require.NoError(t, s.CreateProviderProfile(theirs))
+		require.ErrorIs(t, s.CreateProviderProfile(local), storage.ErrConflict)
+
+		got, err := s.GetProviderProfile(hosted.ID)
+		require.NoError(t, err)
+		require.Equal(t, hosted, *got)
+		got, err = s.GetProviderProfile("missing")
+		require.NoError(t, err)
+		require.Nil(t, got)
+
+		profiles, err := s.ListProviderProfiles(owner)
+		require.NoError(t, err)
+		require.Equal(t, []models.ProviderProfile{local, hosted}, profiles)
+
+		// Making another profile the default clears the previous one, but
+		// only for the same owner. An empty owner and the creation time are
+		// kept.
+		hosted.IsDefault = true
+		hosted.UpdatedAt = 5
+		changed := hosted
+		changed.OwnerID, changed.CreatedAt = "", 99
+		require.NoError(t, s.UpdateProviderProfile(changed))
+		profiles, err = s.ListProviderProfiles(owner)
+		require.NoError(t, err)
+		local.IsDefault = false
+		require.Equal(t, []models.ProviderProfile{local, hosted}, profiles)
+		got, err = s.GetProviderProfile(theirs.ID)
+		require.NoError(t, err)
+		require.True(t, got.IsDefault)
+
+		require.ErrorIs(t, s.UpdateProviderProfile(models.ProviderProfile{ID: "missing", Name: "x"}), storage.ErrNotFound)
+
+		// Threads keep the profile they name until it is deleted.
+		thread := models.Thread{ID: uuid.NewString(), Title: "with profile", CreatedAt: 1, ProviderID: local.ID}
+		require.NoError(t, s.CreateThread(thread))
+		defer func() {
+			_ = s.DeleteThread(thread.ID)
+		}()
+		stored, err := s.GetThread(thread.ID)
+		require.NoError(t, err)
+		require.Equal(t, local.ID, stored.ProviderID)
+		stored.ProviderID = hosted.ID
+		require.NoError(t, s.UpdateThread(*stored))
+		stored, err = s.GetThread(thread.ID)
+		require.NoError(t, err)
+		require.Equal(t, hosted.ID, stored.ProviderID)
+
+		require.NoError(t, s.DeleteProviderProfile(hosted.ID))
+		require.ErrorIs(t, s.DeleteProviderProfile(hosted.ID), storage.ErrNotFound)
+		stored, err = s.GetThread(thread.ID)
+		require.NoError(t, err)
+		require.Empty(t, stored.ProviderID)
+		profiles, err = s.ListProviderProfiles(owner)
+		require.NoError(t, err)
+		require.Equal(t, []models.ProviderProfile{local}, profiles)
+
+		// A user's view only reaches their own profiles.
+		view := storage.ForUser(s, other)
+		profiles, err = view.ListProviderProfiles(owner)
+		require.NoError(t, err)
+		require.Equal(t, []string{theirs.ID}, providerIDs(profiles))
+		got, err = view.GetProviderProfile(local.ID)
+		require.NoError(t, err)
+		require.Nil(t, got)
+		require.ErrorIs(t, view.UpdateProviderProfile(local), storage.ErrNotFound)
+		require.ErrorIs(t, view.DeleteProviderProfile(local.ID), storage.ErrNotFound)
+		foreign := models.Thread{ID: uuid.NewString(), Title: "foreign profile", CreatedAt: 1, ProviderID: local.ID}
+		require.ErrorIs(t, view.CreateThread(foreign), storage.ErrInvalidReference)
+
+		// Naming an owner hands the profile over.
+		local.OwnerID, local.IsDefault = other, true
+		require.NoError(t, s.UpdateProviderProfile(local))
+		profiles, err = s.ListProviderProfiles(other)
+		require.NoError(t, err)
+		theirs.IsDefault = false
+		require.Equal(t, []models.ProviderProfile{local, theirs}, profiles)
+		profiles, err = s.ListProviderProfiles(owner)
+		require.NoError(t, err)
+		require.Empty(t, profiles)
+
+		require.NoError(t, s.DeleteProviderProfile(local.ID))
+		require.NoError(t, s.DeleteProviderProfile(theirs.ID))
+	})
+}
+
+func providerIDs(profiles []models.ProviderProfile) []string {
+	ids := make([]string, len(profiles))
+	for i, p := range profiles {
+		ids[i] = p.ID
+	}
+	return ids
+}
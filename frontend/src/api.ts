@@ -47,6 +47,20 @@ export const updateMessage = (id: string, msg: Partial<ChatMessage>) =>
 export const deleteMessage = (id: string) =>
     fetchJson<void>(`/api/messages/${id}`, { method: "DELETE" });
 
+interface ReplyResponse {
+    user: ChatMessage;
+    assistant: ChatMessage;
+}
+
+// The backend posts content (when given) under id, asks the configured LLM
+// and stores its answer, so API keys never reach the browser.
+export const replyToMessage = (id: string, content?: string) =>
+    fetchJson<ReplyResponse>(`/api/messages/${id}/reply`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(content === undefined ? {} : { content }),
+    });
+
 // BRANCHING
 
 interface MoveResponse {
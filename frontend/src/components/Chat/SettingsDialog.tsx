@@ -105,6 +105,7 @@ export const SettingsDialog: React.FC<{ onClose: () => void }> = ({
                                 <input
                                     type="password"
                                     value={settings.llm_api_key || ""}
+                                    placeholder={settings.has_api_key ? `Saved (${settings.llm_api_key_hint}), leave blank to keep` : ""}
                                     onChange={(e) => handleChange("llm_api_key", e.target.value)}
                                     className={inputStyles}
                                 />
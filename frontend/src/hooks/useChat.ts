@@ -6,9 +6,9 @@ import {
     createMessage,
     createThread,
     moveSubtree,
+    replyToMessage,
     updateThread,
 } from '@/api';
-import { buildLLMHistory, callLLM } from '@/services/llm/llm';
 import { findDefaultParent } from "@/utils/tree"; // <-- Import the new function
 
 /**
@@ -76,41 +76,26 @@ export function useChat() {
             parent = findDefaultParent(messages);
         }
 
-        const parentId = parent?.id;
-        // The root of the new message is the parent's root, or the parent itself if it's a root.
-        // If parent is null, this will be undefined, creating a new root message as before.
-        const rootId = parent?.root_id || parent?.id;
-
-
         setIsLoading(true);
         try {
-            const userMsg = await createMessage({
-                thread_id: currentThreadId,
-                root_id: rootId,
-                parent_id: parentId,
-                role: "user",
-                content,
-                timestamp: Date.now(),
-            });
-            setMessages((prev) => [...(prev || []), userMsg]);
-
-            const messageHistory = userMsg.parent_id
-                ? buildLLMHistory([...messages, userMsg], userMsg.id)
-                : [{ role: "user", content: userMsg.content }];
-
-            const llmReply = await callLLM({ messages: messageHistory });
-
-            const reply = await createMessage({
-                thread_id: currentThreadId,
-                root_id: userMsg.root_id || userMsg.id,
-                parent_id: userMsg.id,
-                role: "assistant",
-                content: llmReply.content,
-                timestamp: Date.now() + 1,
-            });
-
-            setMessages((prev) => [...(prev || []), reply]);
-            setActiveThreadId(reply.id);
+            if (parent) {
+                // The backend stores the user message and the reply together.
+                const { user, assistant } = await replyToMessage(parent.id, content);
+                setMessages((prev) => [...(prev || []), user, assistant]);
+                setActiveThreadId(assistant.id);
+            } else {
+                // A new root has nothing to reply under, so post it first.
+                // The backend timestamps it, in the same seconds as replies.
+                const userMsg = await createMessage({
+                    thread_id: currentThreadId,
+                    role: "user",
+                    content,
+                });
+                setMessages((prev) => [...(prev || []), userMsg]);
+                const { assistant } = await replyToMessage(userMsg.id);
+                setMessages((prev) => [...(prev || []), assistant]);
+                setActiveThreadId(assistant.id);
+            }
         } catch (error) {
             console.error("Failed to send message:", error);
         } finally {
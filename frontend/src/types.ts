@@ -25,7 +25,9 @@ export interface Settings {
     id: string;
     llm_provider: LLMProvider;
     llm_endpoint: string;
-    llm_api_key?: string;
+    llm_api_key?: string; // write-only: never returned by the API
     llm_model?: string;
     simulate_only: boolean;
+    has_api_key?: boolean;
+    llm_api_key_hint?: string;
 }